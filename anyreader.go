@@ -0,0 +1,53 @@
+package dca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrUnknownFormat = errors.New("Unknown format, not dca, raw dca or ogg opus")
+)
+
+// NewAnyReader detects the format of r and returns an OpusReader for it,
+// a *Decoder for dca and raw dca frames, and a *OggOpusReader for Ogg Opus.
+//
+// If r implements io.Seeker it's seeked back after sniffing so the returned reader can still seek.
+func NewAnyReader(r io.Reader) (OpusReader, error) {
+	head := make([]byte, 4)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+	head = head[:n]
+
+	if seeker, ok := r.(io.Seeker); ok {
+		_, err = seeker.Seek(int64(-n), io.SeekCurrent)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		r = io.MultiReader(bytes.NewReader(head), r)
+	}
+
+	switch {
+	case bytes.HasPrefix(head, []byte("DCA")):
+		return NewDecoder(r), nil
+	case bytes.HasPrefix(head, []byte("OggS")):
+		reader, err := NewOggOpusReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return reader, nil
+	case n == 0:
+		// Empty, the decoder will return io.EOF
+		return NewDecoder(r), nil
+	case n >= 2 && int16(binary.LittleEndian.Uint16(head)) > 0:
+		// Looks like a length prefixed frame
+		return NewDecoder(r), nil
+	}
+
+	return nil, ErrUnknownFormat
+}
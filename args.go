@@ -0,0 +1,134 @@
+package dca
+
+import (
+	"strconv"
+)
+
+// ffmpegArgsEnv is what the ffmpeg arguments depend on besides the options, found by probing the input and ffmpeg
+type ffmpegArgsEnv struct {
+	copyOpus bool        // Copy the opus stream of the input as is
+	downmix  string      // Pan filter used to downmix the input, empty for none
+	ffmpeg   *FFmpegInfo // Version of ffmpeg, nil if unknown (assumed to be recent)
+}
+
+// BuildArgs returns the arguments ffmpeg is run with (excluding the executable) to encode input with options,
+// useful for tests and for checking what an encode will do.
+//
+// Options depending on the input (CopyOpus, Downmix, AutoChannels and AutoFrameRate) aren't applied
+// since the input isn't probed, and ffmpeg is assumed to be recent. Use EncodeSession.FFmpegArgs
+// to get the arguments a session was actually started with.
+func BuildArgs(input string, options *EncodeOptions) ([]string, error) {
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	return options.ffmpegArgs(input, ffmpegArgsEnv{}), nil
+}
+
+// ffmpegAtLeast returns true if ffmpeg is at least major.minor, or unknown
+func (env ffmpegArgsEnv) ffmpegAtLeast(major, minor int) bool {
+	return env.ffmpeg == nil || env.ffmpeg.AtLeast(major, minor)
+}
+
+// ffmpegArgs returns the arguments to run ffmpeg with
+func (e EncodeOptions) ffmpegArgs(inFile string, env ffmpegArgsEnv) []string {
+	// Launch ffmpeg with a variety of different fruits and goodies mixed togheter
+	args := []string{"-stats"}
+
+	if e.InputFormat != "" {
+		args = append(args, "-f", e.InputFormat)
+	}
+	if e.InputSampleRate != 0 {
+		args = append(args, "-ar", strconv.Itoa(e.InputSampleRate))
+	}
+	if e.InputChannels != 0 {
+		args = append(args, "-ac", strconv.Itoa(e.InputChannels))
+	}
+
+	if isHTTPInput(inFile) {
+		args = append(args, e.httpInputArgs()...)
+	}
+
+	if e.LowLatency {
+		args = append(args,
+			"-fflags", "nobuffer",
+			"-probesize", "32",
+			"-analyzeduration", "0",
+		)
+	}
+
+	if e.Realtime {
+		args = append(args, "-re")
+	}
+
+	args = append(args, e.ExtraInputArgs...)
+	args = append(args,
+		"-i", inFile,
+		"-map", "0:a:"+strconv.Itoa(e.AudioStreamIndex),
+	)
+
+	if env.copyOpus {
+		args = append(args,
+			"-acodec", "copy",
+			"-f", "ogg",
+			"-ss", strconv.Itoa(e.StartTime),
+		)
+	} else {
+		args = append(args,
+			"-acodec", "libopus",
+			"-f", "ogg",
+			"-vbr", string(e.vbrMode()),
+			"-compression_level", strconv.Itoa(e.CompressionLevel),
+			"-ar", strconv.Itoa(e.FrameRate),
+			"-ac", strconv.Itoa(e.Channels),
+			"-b:a", strconv.Itoa(e.Bitrate*1000),
+			"-application", string(e.Application),
+			"-frame_duration", strconv.Itoa(e.FrameDuration),
+			"-packet_loss", strconv.Itoa(e.PacketLoss),
+			"-threads", strconv.Itoa(e.Threads),
+			"-ss", strconv.Itoa(e.StartTime),
+		)
+	}
+
+	if !env.copyOpus && e.FEC {
+		if env.ffmpegAtLeast(4, 3) {
+			args = append(args, "-fec", "1")
+		} else {
+			e.logger().Warn("FEC requires ffmpeg 4.3 or newer, ignoring it")
+		}
+	}
+	if !env.copyOpus && e.DTX {
+		if env.ffmpegAtLeast(5, 0) {
+			args = append(args, "-dtx", "1")
+		} else {
+			e.logger().Warn("DTX requires ffmpeg 5.0 or newer, ignoring it")
+		}
+	}
+
+	filter := e.audioFilter()
+	if env.downmix != "" && !env.copyOpus {
+		if filter != "" {
+			filter = env.downmix + "," + filter
+		} else {
+			filter = env.downmix
+		}
+	}
+
+	if filter != "" {
+		// Lit af
+		args = append(args, "-af", filter)
+	}
+
+	if e.LowLatency {
+		// The ogg muxer buffers up to 1 second of audio per page by default
+		args = append(args, "-flush_packets", "1")
+		if env.ffmpegAtLeast(4, 0) {
+			args = append(args, "-page_duration", strconv.Itoa(e.FrameDuration*1000))
+		}
+	}
+
+	args = append(args, e.ExtraOutputArgs...)
+	args = append(args, "pipe:1")
+	return args
+}
@@ -0,0 +1,239 @@
+package dca
+
+import (
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+var (
+	// Number of frames queued for each target of a Broadcaster
+	BroadcastBufferFrames = 50
+	// How long a Broadcaster waits for a target with a full queue before dropping its oldest frames
+	BroadcastSlowTimeout = time.Second
+)
+
+// Broadcaster sends the frames from a single source to multiple targets, for example the same radio stream
+// to voice connections in many guilds.
+//
+// It's paced by the slowest target, but targets that don't keep up within BroadcastSlowTimeout
+// have their oldest frames dropped instead of holding back the others.
+// Targets that fails to send a frame are removed. While there are no targets reading from the source is paused.
+type Broadcaster struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	source  OpusReader
+	targets map[OpusSender]*broadcastTarget
+	stopped bool
+	senders sync.WaitGroup
+
+	// If not nil, an error will be sent on it when the source ends (io.EOF) or fails
+	done chan error
+}
+
+type broadcastTarget struct {
+	frames  chan []byte
+	dropped int
+}
+
+// NewBroadcaster creates a new broadcaster and starts reading from source, which is paused while there are no targets
+// done   : If not nil, an error will be sent on it when the source ends (io.EOF) or fails, after the queued frames has been sent
+func NewBroadcaster(source OpusReader, done chan error) *Broadcaster {
+	b := &Broadcaster{
+		source:  source,
+		targets: make(map[OpusSender]*broadcastTarget),
+		done:    done,
+	}
+	b.cond = sync.NewCond(b)
+
+	go b.run()
+	return b
+}
+
+// Add starts sending frames to sender
+func (b *Broadcaster) Add(sender OpusSender) {
+	b.Lock()
+	defer b.Unlock()
+
+	if _, ok := b.targets[sender]; ok || b.stopped {
+		return
+	}
+
+	target := &broadcastTarget{
+		frames: make(chan []byte, BroadcastBufferFrames),
+	}
+	b.targets[sender] = target
+	b.senders.Add(1)
+	go b.send(sender, target)
+
+	b.cond.Broadcast()
+}
+
+// AddVoiceConnection starts sending frames to vc, returning the sender to use with Remove
+func (b *Broadcaster) AddVoiceConnection(vc *discordgo.VoiceConnection) OpusSender {
+	sender := NewDiscordSender(vc)
+	b.Add(sender)
+	return sender
+}
+
+// Remove stops sending frames to sender
+func (b *Broadcaster) Remove(sender OpusSender) {
+	b.Lock()
+	b.remove(sender)
+	b.Unlock()
+}
+
+func (b *Broadcaster) remove(sender OpusSender) {
+	target, ok := b.targets[sender]
+	if !ok {
+		return
+	}
+
+	close(target.frames)
+	delete(b.targets, sender)
+	b.cond.Broadcast()
+}
+
+// Targets returns the number of targets
+func (b *Broadcaster) Targets() int {
+	b.Lock()
+	defer b.Unlock()
+	return len(b.targets)
+}
+
+// Dropped returns the number of frames dropped for sender because it was too slow
+func (b *Broadcaster) Dropped(sender OpusSender) int {
+	b.Lock()
+	defer b.Unlock()
+
+	if target, ok := b.targets[sender]; ok {
+		return target.dropped
+	}
+	return 0
+}
+
+// Stop stops reading from the source and removes all targets
+func (b *Broadcaster) Stop() {
+	b.Lock()
+	b.stop()
+	b.Unlock()
+}
+
+func (b *Broadcaster) stop() {
+	b.stopped = true
+	for sender := range b.targets {
+		b.remove(sender)
+	}
+	b.cond.Broadcast()
+}
+
+func (b *Broadcaster) run() {
+	for {
+		frame, err := b.source.OpusFrame()
+
+		b.Lock()
+		if err != nil {
+			b.stop()
+			b.Unlock()
+
+			b.senders.Wait()
+			if b.done != nil {
+				b.done <- err
+			}
+			return
+		}
+
+		if !b.waitForRoom() {
+			b.Unlock()
+			return
+		}
+
+		for _, target := range b.targets {
+			select {
+			case target.frames <- frame:
+				continue
+			default:
+			}
+
+			// Too slow, drop the oldest frame
+			select {
+			case <-target.frames:
+				target.dropped++
+			default:
+			}
+
+			select {
+			case target.frames <- frame:
+			default:
+			}
+		}
+		b.Unlock()
+	}
+}
+
+// waitForRoom waits until there's room in the queue of every target, or BroadcastSlowTimeout passed
+// and there's at least one target. Returns false if stopped.
+func (b *Broadcaster) waitForRoom() bool {
+	var deadline time.Time
+	var timer *time.Timer
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	for {
+		if b.stopped {
+			return false
+		}
+
+		if len(b.targets) > 0 {
+			full := false
+			for _, target := range b.targets {
+				if len(target.frames) == cap(target.frames) {
+					full = true
+					break
+				}
+			}
+
+			if !full {
+				return true
+			}
+
+			if deadline.IsZero() {
+				deadline = time.Now().Add(BroadcastSlowTimeout)
+				timer = time.AfterFunc(BroadcastSlowTimeout, func() {
+					b.Lock()
+					b.cond.Broadcast()
+					b.Unlock()
+				})
+			} else if !time.Now().Before(deadline) {
+				return true
+			}
+		}
+
+		b.cond.Wait()
+	}
+}
+
+// send sends the frames queued for the target until it's removed
+func (b *Broadcaster) send(sender OpusSender, target *broadcastTarget) {
+	defer b.senders.Done()
+	for frame := range target.frames {
+		err := sender.SendOpus(frame)
+
+		b.Lock()
+		if err != nil {
+			logger().Warn("Broadcast target failed, removing it", "err", err)
+			b.remove(sender)
+		}
+		b.cond.Broadcast()
+		b.Unlock()
+
+		if err != nil {
+			return
+		}
+	}
+}
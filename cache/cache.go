@@ -0,0 +1,320 @@
+// Package cache implements a disk cache of encoded dca files, keyed by the source and encode options.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jonas747/dca"
+)
+
+const fileExt = ".dca"
+
+// Cache stores encoded dca files in a directory, removing the least recently used ones
+// when the total size goes above the max size
+type Cache struct {
+	dir     string
+	maxSize int64
+
+	mu sync.Mutex
+}
+
+// New returns a cache storing files in dir (created if it doesn't exist), maxSize is in bytes, 0 for no limit
+func New(dir string, maxSize int64) (*Cache, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Cache{
+		dir:     dir,
+		maxSize: maxSize,
+	}, nil
+}
+
+// Key returns the cache key for source (a file path or url) encoded with options.
+// Options that don't change the output (buffering, paths to ffmpeg, http headers etc) are ignored.
+func Key(source string, options *dca.EncodeOptions) string {
+	if options == nil {
+		options = dca.StdEncodeOptions
+	}
+
+	o := *options
+	o.BufferedFrames = 0
+	o.SpillToDisk = false
+	o.SpillDir = ""
+	o.LowLatency = false
+	o.Realtime = false
+	o.Threads = 0
+	o.ProcessPriority = 0
+	o.ResourceLimits = nil
+	o.StopGracePeriod = 0
+	o.Limiter = nil
+	o.Logger = nil
+	o.Metrics = nil
+	o.LogArgs = false
+	o.CommandRunner = nil
+	o.RawOutput = false
+	o.UserAgent = ""
+	o.Headers = nil
+	o.Reconnect = false
+	o.ReconnectDelayMax = 0
+	o.FFmpegPath = ""
+	o.FFprobePath = ""
+
+	h := sha256.New()
+	io.WriteString(h, source)
+	h.Write([]byte{0})
+	json.NewEncoder(h).Encode(o)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// path returns the path of the file for key
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.dir, key+fileExt)
+}
+
+// Open returns the cached audio of source encoded with options if it's in the cache, otherwise it starts encoding it
+// and stores the result in the cache once it's been read to the end. In both cases the returned reader
+// has to be cleaned up with Cleanup when done.
+func (c *Cache) Open(source string, options *dca.EncodeOptions) (*Reader, error) {
+	if options == nil {
+		options = dca.StdEncodeOptions
+	}
+
+	key := Key(source, options)
+	r, err := c.OpenCached(key)
+	if err == nil {
+		return r, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Metadata is needed to decode the cached file later
+	encodeOptions := *options
+	encodeOptions.RawOutput = false
+	session, err := dca.EncodeFile(source, &encodeOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, key+"-*.tmp")
+	if err != nil {
+		session.Cleanup()
+		return nil, err
+	}
+
+	return &Reader{
+		OpusReader: dca.TeeOpusReader(session, tmp),
+		cache:      c,
+		key:        key,
+		session:    session,
+		tmp:        tmp,
+	}, nil
+}
+
+// OpenCached returns the cached file for key, an error satisfying os.IsNotExist is returned if it's not cached
+func (c *Cache) OpenCached(key string) (*Reader, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	path := c.path(key)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Used for finding the least recently used files
+	now := time.Now()
+	os.Chtimes(path, now, now)
+
+	return &Reader{
+		OpusReader: dca.NewDecoder(file),
+		cache:      c,
+		key:        key,
+		file:       file,
+	}, nil
+}
+
+// Has returns true if key is cached
+func (c *Cache) Has(key string) bool {
+	_, err := os.Stat(c.path(key))
+	return err == nil
+}
+
+// Remove removes key from the cache
+func (c *Cache) Remove(key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return os.Remove(c.path(key))
+}
+
+// Size returns the total size of the cached files in bytes
+func (c *Cache) Size() (int64, error) {
+	files, err := c.files()
+	if err != nil {
+		return 0, err
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+	return size, nil
+}
+
+// files returns the cached files
+func (c *Cache) files() ([]os.FileInfo, error) {
+	entries, err := ioutil.ReadDir(c.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	files := entries[:0]
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), fileExt) {
+			files = append(files, e)
+		}
+	}
+	return files, nil
+}
+
+// Evict removes the least recently used files until the total size is at most the max size
+func (c *Cache) Evict() error {
+	if c.maxSize <= 0 {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	files, err := c.files()
+	if err != nil {
+		return err
+	}
+
+	var size int64
+	for _, f := range files {
+		size += f.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].ModTime().Before(files[j].ModTime())
+	})
+
+	for _, f := range files {
+		if size <= c.maxSize {
+			break
+		}
+
+		err = os.Remove(filepath.Join(c.dir, f.Name()))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		size -= f.Size()
+	}
+
+	return nil
+}
+
+// store moves a fully written temporary file into the cache
+func (c *Cache) store(key string, tmp *os.File) error {
+	err := tmp.Close()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	c.mu.Lock()
+	err = os.Rename(tmp.Name(), c.path(key))
+	c.mu.Unlock()
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return c.Evict()
+}
+
+// Reader is an OpusReader of either a cached file, or an encode session that's being cached
+type Reader struct {
+	dca.OpusReader
+
+	cache *Cache
+	key   string
+
+	// Set when reading a cached file
+	file *os.File
+
+	// Set when encoding
+	session *dca.EncodeSession
+	tmp     *os.File
+	stored  bool
+}
+
+// Cached returns true if reading from the cache, false if encoding
+func (r *Reader) Cached() bool {
+	return r.file != nil
+}
+
+// Key returns the cache key
+func (r *Reader) Key() string {
+	return r.key
+}
+
+// OpusFrame implements dca.OpusReader, the encoded file is stored in the cache when the end is reached
+func (r *Reader) OpusFrame() ([]byte, error) {
+	frame, err := r.OpusReader.OpusFrame()
+	if err == io.EOF && r.session != nil && !r.stored {
+		r.stored = true
+		tee := r.OpusReader.(*dca.TeeReader)
+		if r.session.Error() == nil && tee.Err() == nil {
+			storeErr := r.cache.store(r.key, r.tmp)
+			if storeErr != nil {
+				return nil, storeErr
+			}
+		} else {
+			r.tmp.Close()
+			os.Remove(r.tmp.Name())
+		}
+	}
+	return frame, err
+}
+
+// Metadata returns the metadata, nil if not available
+func (r *Reader) Metadata() *dca.Metadata {
+	if r.session != nil {
+		return r.session.Metadata()
+	}
+
+	decoder := r.OpusReader.(*dca.Decoder)
+	if decoder.Metadata == nil {
+		decoder.ReadMetadata()
+	}
+	return decoder.Metadata
+}
+
+// Cleanup closes the cached file, or stops the encoding and discards the partially cached file
+func (r *Reader) Cleanup() error {
+	if r.file != nil {
+		return r.file.Close()
+	}
+
+	err := r.session.Cleanup()
+	if !r.stored {
+		r.stored = true
+		r.tmp.Close()
+		os.Remove(r.tmp.Name())
+	}
+	return err
+}
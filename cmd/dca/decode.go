@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/jonas747/dca"
+)
+
+// decodeCommand converts a dca file to ogg opus without re-encoding, or decodes it with ffmpeg to wav etc
+func decodeCommand(args []string) {
+	fs := flag.NewFlagSet("decode", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dca decode [flags] file.dca out.(opus|ogg|wav|...)")
+		fmt.Fprintln(os.Stderr, "\n.opus and .ogg are remuxed as is, other formats are decoded with ffmpeg.")
+		fmt.Fprintln(os.Stderr, "Use - for stdin or stdout, -f is needed when writing to stdout.")
+		fs.PrintDefaults()
+	}
+
+	format := fs.String("f", "", "output format (ogg, opus, wav or anything ffmpeg can write), defaults to the extension of the output")
+	ffmpegPath := fs.String("ffmpeg", dca.FFmpegPath, "ffmpeg executable")
+	fs.Parse(args)
+
+	if fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	inPath, outPath := fs.Arg(0), fs.Arg(1)
+
+	if *format == "" {
+		*format = strings.ToLower(strings.TrimPrefix(filepath.Ext(outPath), "."))
+		if *format == "" || outPath == "-" {
+			fmt.Fprintln(os.Stderr, "error: no output format, set it with -f")
+			os.Exit(1)
+		}
+	}
+
+	var input io.Reader = os.Stdin
+	if inPath != "-" {
+		file, err := os.Open(inPath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed opening infile:", err)
+			os.Exit(1)
+		}
+		defer file.Close()
+		input = file
+	}
+
+	output, err := createOutput(outPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed creating outfile:", err)
+		os.Exit(1)
+	}
+
+	if *format == "opus" || *format == "ogg" {
+		err = dca.WriteOggOpus(output, input)
+	} else {
+		err = decodeFFmpeg(*ffmpegPath, *format, input, output)
+	}
+	closeErr := output.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed decoding:", err)
+		if outPath != "-" {
+			os.Remove(outPath)
+		}
+		os.Exit(1)
+	}
+}
+
+// decodeFFmpeg remuxes the dca file in r to ogg opus and has ffmpeg decode it to format, written to w
+func decodeFFmpeg(ffmpegPath, format string, r io.Reader, w io.Writer) error {
+	oggReader, oggWriter := io.Pipe()
+	go func() {
+		oggWriter.CloseWithError(dca.WriteOggOpus(oggWriter, r))
+	}()
+
+	var stderr bytes.Buffer
+	cmd := exec.Command(ffmpegPath, "-hide_banner", "-loglevel", "error", "-f", "ogg", "-i", "pipe:0", "-f", format, "pipe:1")
+	cmd.Stdin = oggReader
+	cmd.Stdout = w
+	cmd.Stderr = &stderr
+
+	err := cmd.Run()
+	// Unblock WriteOggOpus if ffmpeg exited early
+	oggReader.Close()
+	if err != nil && stderr.Len() > 0 {
+		return fmt.Errorf("ffmpeg: %v: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return err
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jonas747/dca"
+)
+
+// extraFlag collects key=value pairs for the extra metadata
+type extraFlag map[string]string
+
+func (e extraFlag) String() string {
+	return fmt.Sprint(map[string]string(e))
+}
+
+func (e extraFlag) Set(value string) error {
+	split := strings.SplitN(value, "=", 2)
+	if len(split) != 2 {
+		return fmt.Errorf("expected key=value, got %q", value)
+	}
+	e[split[0]] = split[1]
+	return nil
+}
+
+// editCommand changes the metadata of an existing dca file
+func editCommand(args []string) {
+	fs := flag.NewFlagSet("edit", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dca edit [flags] file.dca")
+		fs.PrintDefaults()
+	}
+
+	title := fs.String("title", "", "song title")
+	artist := fs.String("artist", "", "song artist")
+	album := fs.String("album", "", "song album")
+	genre := fs.String("genre", "", "song genre")
+	comment := fs.String("com", "", "comment")
+	cover := fs.String("cover", "", "image file to use as cover art")
+	extra := extraFlag{}
+	fs.Var(extra, "extra", "key=value to store in the extra metadata, can be repeated")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	// Only change what was specified
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var coverImage string
+	if *cover != "" {
+		data, err := ioutil.ReadFile(*cover)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "Failed reading cover:", err)
+			os.Exit(1)
+		}
+		coverImage = base64.StdEncoding.EncodeToString(data)
+	}
+
+	err := dca.EditMetadataFile(fs.Arg(0), func(m *dca.Metadata) error {
+		if set["title"] {
+			m.SongInfo.Title = *title
+		}
+		if set["artist"] {
+			m.SongInfo.Artist = *artist
+		}
+		if set["album"] {
+			m.SongInfo.Album = *album
+		}
+		if set["genre"] {
+			m.SongInfo.Genre = *genre
+		}
+		if set["com"] {
+			m.SongInfo.Comments = *comment
+		}
+		if set["cover"] {
+			m.SongInfo.Cover = &coverImage
+		}
+		for k, v := range extra {
+			err := m.Extra.Set(k, v)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed editing metadata:", err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jonas747/dca"
+)
+
+// encodeCommand wraps ffmpeg and outputs a dca file, or raw opus frames
+// with a uint16 header for each frame with the frame length in bytes
+func encodeCommand(args []string) {
+	fs := flag.NewFlagSet("encode", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dca encode [flags] [infile]")
+		fs.PrintDefaults()
+	}
+
+	encodeFlags := addEncodeFlags(fs)
+	inFile := fs.String("i", "pipe:0", "infile, a file or anything else ffmpeg accepts (ex a url)")
+	outFile := fs.String("o", "pipe:1", "outfile")
+	raw := fs.Bool("raw", false, "Raw opus output (no metadata or magic bytes)")
+	quiet := fs.Bool("quiet", false, "disable stats output to stderr")
+	dryRun := fs.Bool("dryrun", false, "check that the input can be encoded and print the ffmpeg command without encoding")
+	fs.Parse(args)
+
+	if fs.NArg() > 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if fs.NArg() == 1 {
+		*inFile = fs.Arg(0)
+	}
+
+	options := encodeFlags.options()
+	options.RawOutput = *raw
+
+	if *inFile == "pipe:0" {
+		if *dryRun {
+			fmt.Fprintln(os.Stderr, "error: -dryrun needs an input file or url")
+			os.Exit(1)
+		}
+
+		fi, err := os.Stdin.Stat()
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		if fi.Mode()&os.ModeCharDevice != 0 {
+			fmt.Fprintln(os.Stderr, "error: stdin is not a pipe.")
+			fs.Usage()
+			os.Exit(1)
+		}
+	} else if !fileExists(*inFile) {
+		fmt.Fprintln(os.Stderr, "warning: infile does not exist as a file on this system, will still continue on incase this is something else that ffmpeg accepts")
+	}
+
+	if *dryRun {
+		dryRunEncode(*inFile, options)
+		return
+	}
+
+	output, err := createOutput(*outFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed creating outfile:", err)
+		os.Exit(1)
+	}
+	defer output.Close()
+
+	var session *dca.EncodeSession
+	if *inFile == "pipe:0" {
+		session, err = dca.EncodeMem(os.Stdin, options)
+	} else {
+		session, err = dca.EncodeFile(*inFile, options)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed creating an encoding session:", err)
+		os.Exit(1)
+	}
+
+	if !*quiet {
+		go statusPrinter(session)
+	}
+
+	_, err = io.Copy(output, session)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "\nError writing:", err)
+		os.Exit(1)
+	} else if err = session.Error(); err != nil {
+		fmt.Fprintln(os.Stderr, "\nError encoding:", err)
+		os.Exit(1)
+	} else if !*quiet {
+		fmt.Fprintf(os.Stderr, "\nFinished encoding\n")
+		fmt.Fprint(os.Stderr, "ffmpeg output\n\n", session.FFMPEGMessages())
+	}
+}
+
+// createOutput creates the file at path, or returns stdout for pipe:1 and -
+func createOutput(path string) (io.WriteCloser, error) {
+	if path == "pipe:1" || path == "-" {
+		return os.Stdout, nil
+	}
+	return os.Create(path)
+}
+
+// dryRunEncode prints what encoding inFile would do, exiting with an error if it can't be encoded
+func dryRunEncode(inFile string, options *dca.EncodeOptions) {
+	result, err := dca.DryRun(context.Background(), inFile, options)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Not encodable:", err)
+		os.Exit(1)
+	}
+
+	duration := "unknown"
+	if result.Duration > 0 {
+		duration = result.Duration.String()
+	}
+
+	fmt.Printf("Encodable: %s\n", inFile)
+	fmt.Printf("Duration:  %s\n", duration)
+	fmt.Printf("Stream:    %s, %d channels, %s hz\n", result.Stream.CodecName, result.Stream.Channels, result.Stream.SampleRate)
+	fmt.Printf("Copy opus: %t\n", result.CopyOpus)
+	fmt.Printf("Command:   %s %s\n", options.FFmpegPath, strings.Join(result.Args, " "))
+}
+
+func statusPrinter(session *dca.EncodeSession) {
+	ticker := time.NewTicker(time.Millisecond * 500)
+	defer ticker.Stop()
+	for {
+		<-ticker.C
+		stats := session.Stats()
+		if stats.SourceDuration > 0 {
+			fmt.Fprintf(os.Stderr, "Time: %10s, Bitrate: %6.1fkbits/s, Size: %6dkB, Speed: %7.1f, Progress: %5.1f%%, ETA: %8s\r", stats.Duration.String(), stats.Bitrate, stats.Size, stats.Speed, stats.Progress, stats.ETA.String())
+		} else {
+			fmt.Fprintf(os.Stderr, "Time: %10s, Bitrate: %6.1fkbits/s, Size: %6dkB, Speed: %7.1f\r", stats.Duration.String(), stats.Bitrate, stats.Size, stats.Speed)
+		}
+		if !session.Running() {
+			break
+		}
+	}
+}
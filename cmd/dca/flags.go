@@ -0,0 +1,95 @@
+package main
+
+import (
+	"flag"
+
+	"github.com/jonas747/dca"
+)
+
+// encodeFlags are the encoding options shared by the commands that encode
+type encodeFlags struct {
+	Volume        int     // change audio volume (256=normal)
+	Gain          float64 // volume multiplier (1=normal), overrides Volume if set
+	Channels      int     // 1 for mono, 2 for stereo
+	FrameRate     int     // Must be one of 8000, 12000, 16000, 24000, or 48000
+	FrameDuration int     // Duration in ms of each audio frame
+	Bitrate       int     // in kb/s, rates from 1 to 512 are meaningful
+	Application   string  // voip, audio, or lowdelay
+	VBR           bool    // Wether variable bitrate is used or not
+	VBRMode       string  // off, on or constrained, overrides VBR if set
+	PacketLoss    int     // expected packet loss percentage
+	FEC           bool    // in-band forward error correction
+	DTX           bool    // discontinuous transmission
+	Threads       int     // number of threads to use, 0 for auto
+	Priority      int     // nice level of ffmpeg
+	CoverFormat   string  // format the cover art is encoded with
+	Comment       string  // Comment left in the metadata
+	AutoFormat    bool    // keep the channels and sample rate of the input
+	CopyOpus      bool    // copy opus input as is when possible
+	Realtime      bool    // encode at playback speed
+	FFmpegPath    string  // ffmpeg executable
+	FFprobePath   string  // ffprobe executable
+}
+
+// addEncodeFlags registers the encoding options on fs
+func addEncodeFlags(fs *flag.FlagSet) *encodeFlags {
+	f := &encodeFlags{}
+	fs.IntVar(&f.Volume, "vol", 256, "change audio volume (256=normal)")
+	fs.Float64Var(&f.Gain, "gain", 0, "volume multiplier (1=normal), overrides -vol if set")
+	fs.IntVar(&f.Channels, "ac", 2, "audio channels")
+	fs.IntVar(&f.FrameRate, "ar", 48000, "audio sampling rate")
+	fs.IntVar(&f.FrameDuration, "as", 20, "audio frame duration can be 20, 40, or 60 (ms)")
+	fs.IntVar(&f.Bitrate, "ab", 128, "audio encoding bitrate in kb/s can be 8 - 128")
+	fs.StringVar(&f.Application, "aa", "audio", "audio application can be voip, audio, or lowdelay")
+	fs.BoolVar(&f.VBR, "vbr", true, "variable bitrate")
+	fs.StringVar(&f.VBRMode, "vbrmode", "", "bitrate mode can be off, on or constrained, overrides -vbr")
+	fs.IntVar(&f.PacketLoss, "pl", 0, "expected packet loss percentage")
+	fs.BoolVar(&f.FEC, "fec", false, "enable in-band forward error correction")
+	fs.BoolVar(&f.DTX, "dtx", false, "enable discontinuous transmission")
+	fs.IntVar(&f.Threads, "threads", 0, "number of threads to use, 0 for auto")
+	fs.IntVar(&f.Priority, "nice", 0, "priority (nice level) of ffmpeg, -20 to 19, higher is lower priority")
+	fs.StringVar(&f.CoverFormat, "cf", "jpeg", "format the cover art will be encoded with (jpeg, png, webp or original)")
+	fs.StringVar(&f.Comment, "com", "", "leave a comment in the metadata")
+	fs.BoolVar(&f.AutoFormat, "auto", false, "keep the number of channels and sample rate of the input file instead of -ac and -ar")
+	fs.BoolVar(&f.CopyOpus, "copy", false, "copy opus audio (ex webm) without re-encoding when possible")
+	fs.BoolVar(&f.Realtime, "re", false, "encode at playback speed instead of as fast as possible")
+	fs.StringVar(&f.FFmpegPath, "ffmpeg", dca.FFmpegPath, "ffmpeg executable")
+	fs.StringVar(&f.FFprobePath, "ffprobe", dca.FFprobePath, "ffprobe executable")
+	return f
+}
+
+// options returns the EncodeOptions for the flags
+func (f *encodeFlags) options() *dca.EncodeOptions {
+	bitrate := f.Bitrate
+	if bitrate < 1 || bitrate > 512 {
+		bitrate = 64 // Set to Discord default
+	}
+
+	return &dca.EncodeOptions{
+		Volume:          f.Volume,
+		Gain:            f.Gain,
+		Channels:        f.Channels,
+		FrameRate:       f.FrameRate,
+		FrameDuration:   f.FrameDuration,
+		Bitrate:         bitrate,
+		Application:     dca.AudioApplication(f.Application),
+		CoverFormat:     f.CoverFormat,
+		VBR:             f.VBR,
+		VBRMode:         dca.VBRMode(f.VBRMode),
+		PacketLoss:      f.PacketLoss,
+		FEC:             f.FEC,
+		DTX:             f.DTX,
+		Comment:         f.Comment,
+		Threads:         f.Threads,
+		ProcessPriority: f.Priority,
+		CopyOpus:        f.CopyOpus,
+		Realtime:        f.Realtime,
+		AutoChannels:    f.AutoFormat,
+		AutoFrameRate:   f.AutoFormat,
+		FFmpegPath:      f.FFmpegPath,
+		FFprobePath:     f.FFprobePath,
+
+		Reconnect:         true,
+		ReconnectDelayMax: 2,
+	}
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/jonas747/dca"
+)
+
+// fileInfo is what info prints for a file
+type fileInfo struct {
+	File          string        `json:"file"`
+	FormatVersion int           `json:"format_version"` // 0 for raw dca without metadata
+	Metadata      *dca.Metadata `json:"metadata"`
+	Scan          *scanResult   `json:"scan,omitempty"`
+}
+
+// scanResult is the result of reading through the frames of a file
+type scanResult struct {
+	Frames   int           `json:"frames"`
+	Duration time.Duration `json:"-"`
+	Seconds  float64       `json:"duration"` // Duration in seconds, like in the metadata
+	Bytes    int64         `json:"bytes"`    // Size of the opus frames
+	Bitrate  float64       `json:"bitrate"`  // Average bitrate in kbit/s
+}
+
+// infoCommand prints the metadata of dca files
+func infoCommand(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dca info [flags] file.dca...")
+		fs.PrintDefaults()
+	}
+
+	table := fs.Bool("table", false, "print a table instead of json")
+	scan := fs.Bool("scan", false, "read through the frames for the duration, frame count and average bitrate")
+	cover := fs.Bool("cover", false, "include the cover art instead of only its size")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	failed := false
+	for i, path := range fs.Args() {
+		info, err := readInfo(path, *scan)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: failed reading: %v\n", path, err)
+			failed = true
+			continue
+		}
+
+		if !*cover && info.Metadata != nil && info.Metadata.SongInfo != nil && info.Metadata.SongInfo.Cover != nil {
+			size := base64.StdEncoding.DecodedLen(len(*info.Metadata.SongInfo.Cover))
+			summary := fmt.Sprintf("<%d bytes>", size)
+			info.Metadata.SongInfo.Cover = &summary
+		}
+
+		if *table {
+			if i > 0 {
+				fmt.Println()
+			}
+			printInfoTable(info)
+		} else {
+			out, _ := json.MarshalIndent(info, "", "  ")
+			fmt.Println(string(out))
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// readInfo reads the metadata of the file at path, and the frames if scan is set
+func readInfo(path string, scan bool) (*fileInfo, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	decoder := dca.NewDecoder(file)
+	err = decoder.ReadMetadata()
+	if err != nil && err != dca.ErrNotDCA {
+		return nil, err
+	}
+
+	info := &fileInfo{
+		File:          path,
+		FormatVersion: decoder.FormatVersion,
+		Metadata:      decoder.Metadata,
+	}
+	if !scan {
+		return info, nil
+	}
+
+	result := &scanResult{}
+	err = decoder.ForEachFrame(func(frame []byte) bool {
+		result.Frames++
+		result.Bytes += int64(len(frame))
+
+		// Go by the TOC in case the metadata is wrong
+		if packet, err := dca.ParsePacket(frame); err == nil {
+			result.Duration += packet.Duration()
+		} else {
+			result.Duration += decoder.FrameDuration()
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	result.Seconds = result.Duration.Seconds()
+	if result.Duration > 0 {
+		result.Bitrate = float64(result.Bytes*8) / result.Duration.Seconds() / 1000
+	}
+	info.Scan = result
+	return info, nil
+}
+
+// printInfoTable prints info as a table of fields, empty fields are left out
+func printInfoTable(info *fileInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	defer w.Flush()
+
+	row := func(name string, value interface{}) {
+		switch v := value.(type) {
+		case string:
+			if v == "" {
+				return
+			}
+		case int:
+			if v == 0 {
+				return
+			}
+		}
+		fmt.Fprintf(w, "%s\t%v\n", name, value)
+	}
+
+	row("File", info.File)
+	if info.FormatVersion == 0 {
+		row("Format", "raw (no metadata)")
+	} else {
+		row("Format", fmt.Sprintf("DCA%d", info.FormatVersion))
+	}
+
+	if m := info.Metadata; m != nil {
+		if m.Dca != nil && m.Dca.Tool != nil {
+			row("Tool", fmt.Sprintf("%s %s", m.Dca.Tool.Name, m.Dca.Tool.Version))
+		}
+		if m.Opus != nil {
+			row("Bitrate", m.Opus.Bitrate)
+			row("Sample rate", m.Opus.SampleRate)
+			row("Channels", m.Opus.Channels)
+			row("Frame size", m.Opus.FrameSize)
+			row("Application", m.Opus.Application)
+			row("VBR", fmt.Sprint(m.Opus.VBR))
+		}
+		if s := m.SongInfo; s != nil {
+			row("Title", s.Title)
+			row("Artist", s.Artist)
+			row("Album", s.Album)
+			row("Album artist", s.AlbumArtist)
+			row("Composer", s.Composer)
+			row("Genre", s.Genre)
+			row("Date", s.Date)
+			row("Track", s.Track)
+			row("Disc", s.Disc)
+			row("Comments", s.Comments)
+			if s.Cover != nil {
+				row("Cover", *s.Cover)
+			}
+		}
+		if o := m.Origin; o != nil {
+			row("Source", o.Source)
+			row("Source bitrate", o.Bitrate)
+			row("Source channels", o.Channels)
+			row("Source encoding", o.Encoding)
+			row("Source url", o.Url)
+		}
+		for _, chapter := range m.Chapters {
+			row("Chapter", fmt.Sprintf("%s - %s %s", chapter.StartTime(), chapter.EndTime(), chapter.Title))
+		}
+		if m.Extra != nil {
+			keys := make([]string, 0, len(*m.Extra))
+			for k := range *m.Extra {
+				keys = append(keys, k)
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				row("Extra "+k, string((*m.Extra)[k]))
+			}
+		}
+	}
+
+	if s := info.Scan; s != nil {
+		row("Frames", fmt.Sprint(s.Frames))
+		row("Duration", s.Duration.String())
+		row("Size", fmt.Sprintf("%d bytes", s.Bytes))
+		row("Average bitrate", fmt.Sprintf("%.1fkbits/s", s.Bitrate))
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+	"github.com/jonas747/dca"
+)
+
+// playCommand plays files in a discord voice channel, dca files as they are and everything else encoded
+func playCommand(args []string) {
+	fs := flag.NewFlagSet("play", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dca play -t token -g guild [-c channel] [flags] file...")
+		fs.PrintDefaults()
+	}
+
+	encodeFlags := addEncodeFlags(fs)
+	token := fs.String("t", os.Getenv("DCA_TOKEN"), "discord bot token, defaults to $DCA_TOKEN")
+	guildID := fs.String("g", "", "guild id")
+	channelID := fs.String("c", "", "voice channel id, defaults to the guild id")
+	repeat := fs.Bool("repeat", false, "repeat the files until interrupted")
+	fs.Parse(args)
+
+	if fs.NArg() < 1 || *token == "" || *guildID == "" {
+		fs.Usage()
+		os.Exit(1)
+	}
+	if *channelID == "" {
+		*channelID = *guildID
+	}
+
+	discord, err := discordgo.New("Bot " + strings.TrimPrefix(*token, "Bot "))
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed creating discord session:", err)
+		os.Exit(1)
+	}
+
+	err = discord.Open()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed connecting to discord:", err)
+		os.Exit(1)
+	}
+	defer discord.Close()
+
+	vc, err := discord.ChannelVoiceJoin(*guildID, *channelID, false, true)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed joining voice channel:", err)
+		os.Exit(1)
+	}
+	defer vc.Close()
+
+	options := encodeFlags.options()
+	tracks := make([]*dca.Track, 0, fs.NArg())
+	for _, path := range fs.Args() {
+		tracks = append(tracks, playTrack(path, options))
+	}
+
+	events := make(chan *dca.PlayerEvent)
+	player := dca.NewPlayer(dca.NewDiscordSender(vc), events)
+	if *repeat {
+		player.SetRepeat(dca.RepeatAll)
+	}
+	player.Enqueue(tracks...)
+
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+
+	failed := false
+	for {
+		select {
+		case <-interrupt:
+			player.Stop()
+			return
+		case event := <-events:
+			switch event.Type {
+			case dca.PlayerEventTrackStart:
+				fmt.Fprintln(os.Stderr, "Playing:", event.Track.Path)
+			case dca.PlayerEventTrackError:
+				fmt.Fprintf(os.Stderr, "Failed playing %s: %v\n", event.Track.Path, event.Err)
+				failed = true
+			case dca.PlayerEventQueueEnd:
+				// Let the last frames go out before leaving
+				time.Sleep(250 * time.Millisecond)
+				if failed {
+					os.Exit(1)
+				}
+				return
+			}
+		}
+	}
+}
+
+// playTrack returns a track for the file at path, dca files are loaded into memory and everything else is encoded
+func playTrack(path string, options *dca.EncodeOptions) *dca.Track {
+	if !strings.EqualFold(filepath.Ext(path), ".dca") {
+		return &dca.Track{Path: path, Options: options}
+	}
+
+	return &dca.Track{
+		Path: path,
+		Open: func() (dca.OpusReader, error) {
+			file, err := os.Open(path)
+			if err != nil {
+				return nil, err
+			}
+			defer file.Close()
+
+			return dca.LoadIntoMemory(dca.NewDecoder(file))
+		},
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/jonas747/dca"
+)
+
+// verifyCommand checks dca files for problems, exiting with status 1 if any are found
+func verifyCommand(args []string) {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: dca verify file.dca...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() < 1 {
+		fs.Usage()
+		os.Exit(1)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		if !verifyFile(path) {
+			failed = true
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// verifyFile prints the report for the file and returns true if it's fine
+func verifyFile(path string) bool {
+	file, err := os.Open(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed opening file:", err)
+		return false
+	}
+	defer file.Close()
+
+	report, err := dca.Verify(file)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: failed reading: %v\n", path, err)
+		return false
+	}
+
+	if report.OK() {
+		fmt.Printf("%s: ok (v%d, %d frames, %s)\n", path, report.FormatVersion, report.Frames, report.Duration)
+		return true
+	}
+
+	fmt.Printf("%s: %d problems (v%d, %d frames, %s)\n", path, len(report.Problems), report.FormatVersion, report.Frames, report.Duration)
+	for _, problem := range report.Problems {
+		fmt.Println("  -", problem)
+	}
+	return false
+}
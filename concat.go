@@ -0,0 +1,123 @@
+package dca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrIncompatibleSources = errors.New("Sources have incompatible opus parameters")
+)
+
+// Concat writes the frames of all the dca files in sources to w in order, as one dca v1 file.
+//
+// All the sources must have the same sample rate, channels and frame duration, sources without metadata
+// are assumed to match. The metadata is taken from the first source that has any, with the extra fields of
+// the other sources added if not already set. Chapters and v2 fields are dropped as they no longer apply.
+func Concat(w io.Writer, sources ...io.Reader) error {
+	decoders := make([]*Decoder, len(sources))
+	var first *Decoder
+	for i, source := range sources {
+		decoder := NewDecoder(source)
+		err := decoder.checkMetadata()
+		if err != nil {
+			return err
+		}
+		decoders[i] = decoder
+
+		if decoder.Metadata == nil {
+			continue
+		}
+
+		if first == nil {
+			first = decoder
+		} else if !compatibleOpus(first, decoder) {
+			return ErrIncompatibleSources
+		}
+	}
+
+	if first != nil {
+		err := writeMetadataHeader(w, FormatVersion, concatMetadata(first, decoders))
+		if err != nil {
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for _, decoder := range decoders {
+		for {
+			frame, err := decoder.OpusFrame()
+			if err != nil {
+				if err == io.EOF {
+					break
+				}
+				return err
+			}
+
+			buf.Reset()
+			binary.Write(&buf, binary.LittleEndian, int16(len(frame)))
+			buf.Write(frame)
+			_, err = w.Write(buf.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// compatibleOpus returns true if the frames of a and b can be played back in the same stream
+func compatibleOpus(a, b *Decoder) bool {
+	if a.FrameDuration() != b.FrameDuration() {
+		return false
+	}
+
+	if a.Metadata.Opus == nil || b.Metadata.Opus == nil {
+		return true
+	}
+
+	return a.Metadata.Opus.SampleRate == b.Metadata.Opus.SampleRate &&
+		a.Metadata.Opus.Channels == b.Metadata.Opus.Channels
+}
+
+// concatMetadata builds the metadata for the concatenated file
+func concatMetadata(first *Decoder, decoders []*Decoder) *Metadata {
+	metadata := *first.Metadata
+	metadata.Chapters = nil
+	metadata.SeekTable = nil
+
+	dcaMeta := DCAMetadata{}
+	if metadata.Dca != nil {
+		dcaMeta = *metadata.Dca
+	}
+	dcaMeta.Version = FormatVersion
+	dcaMeta.Frames = 0
+	dcaMeta.Duration = 0
+	dcaMeta.CRC = false
+	metadata.Dca = &dcaMeta
+
+	if metadata.SongInfo != nil {
+		songInfo := *metadata.SongInfo
+		songInfo.Duration = 0
+		metadata.SongInfo = &songInfo
+	}
+
+	extra := ExtraMetadata{}
+	for _, decoder := range decoders {
+		if decoder.Metadata == nil || decoder.Metadata.Extra == nil {
+			continue
+		}
+
+		for k, v := range *decoder.Metadata.Extra {
+			if _, ok := extra[k]; !ok {
+				extra[k] = v
+			}
+		}
+	}
+	metadata.Extra = &extra
+
+	return &metadata
+}
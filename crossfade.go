@@ -0,0 +1,74 @@
+package dca
+
+import (
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// mixCrossfade decodes the frames, fades from tail to head and encodes the result with bitrate (in bits per second).
+// head is padded with silence if it's shorter than tail. Assumes 48khz stereo audio, as used by discord.
+func mixCrossfade(tail, head [][]byte, frameDuration time.Duration, bitrate int) ([][]byte, error) {
+	const channels = 2
+	frameSize := int(frameDuration * 48000 / time.Second)
+
+	decoderTail, err := gopus.NewDecoder(48000, channels)
+	if err != nil {
+		return nil, err
+	}
+	decoderHead, err := gopus.NewDecoder(48000, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := gopus.NewEncoder(48000, channels, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(bitrate)
+
+	total := float64(len(tail) * frameSize)
+	mixed := make([][]byte, 0, len(tail))
+	pcm := make([]int16, frameSize*channels)
+	for i := range tail {
+		a, err := decoderTail.Decode(tail[i], frameSize, false)
+		if err != nil {
+			return nil, err
+		}
+
+		var b []int16
+		if i < len(head) {
+			b, err = decoderHead.Decode(head[i], frameSize, false)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for j := range pcm {
+			gain := float64(i*frameSize+j/channels) / total
+
+			var sample float64
+			if j < len(a) {
+				sample += float64(a[j]) * (1 - gain)
+			}
+			if j < len(b) {
+				sample += float64(b[j]) * gain
+			}
+
+			if sample > 32767 {
+				sample = 32767
+			} else if sample < -32768 {
+				sample = -32768
+			}
+			pcm[j] = int16(sample)
+		}
+
+		opus, err := encoder.Encode(pcm, frameSize, len(pcm)*2)
+		if err != nil {
+			return nil, err
+		}
+		mixed = append(mixed, opus)
+	}
+
+	return mixed, nil
+}
@@ -3,8 +3,10 @@ package dca
 import (
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
-	"log"
+	"io/ioutil"
+	"math"
 	"time"
 )
 
@@ -13,6 +15,9 @@ const (
 	// The current version of the DCA format
 	FormatVersion int8 = 1
 
+	// Version 2 of the DCA format, see WriteV2
+	FormatVersion2 int8 = 2
+
 	// The current version of the DCA program
 	LibraryVersion string = "0.0.5"
 
@@ -25,44 +30,86 @@ type OpusReader interface {
 	FrameDuration() time.Duration
 }
 
-var Logger *log.Logger
-
-// logln logs to assigned logger or standard logger
-func logln(s ...interface{}) {
-	if Logger != nil {
-		Logger.Println(s...)
-		return
-	}
-
-	log.Println(s...)
-}
-
-// logln logs to assigned logger or standard logger
-func logf(format string, a ...interface{}) {
-	if Logger != nil {
-		Logger.Printf(format, a...)
-		return
-	}
-	log.Printf(format, a...)
-}
-
 var (
-	ErrNegativeFrameSize = errors.New("Frame size is negative, possibly corrupted.")
+	// ErrCorrupted is wrapped by the errors returned for invalid frames and metadata, check with errors.Is
+	ErrCorrupted = errors.New("Invalid data, possibly corrupted")
+	// ErrTruncated is returned when the data ends in the middle of a frame or the metadata, it wraps io.ErrUnexpectedEOF
+	ErrTruncated = fmt.Errorf("%w: possibly truncated", io.ErrUnexpectedEOF)
+
+	ErrNegativeFrameSize = fmt.Errorf("%w: frame size is negative", ErrCorrupted)
+	ErrFrameTooLarge     = fmt.Errorf("%w: frame size is larger than MaxFrameSize", ErrCorrupted)
 )
 
+// MaxFrameSize is the largest frame size in bytes DecodeFrame and DecodeFrameInto accepts
+var MaxFrameSize = math.MaxInt16
+
 // DecodeFrame decodes a dca frame from an io.Reader and returns the raw opus audio ready to be sent to discord
 func DecodeFrame(r io.Reader) (frame []byte, err error) {
 	var size int16
 	err = binary.Read(r, binary.LittleEndian, &size)
 	if err != nil {
-		return
+		return nil, truncatedErr(err)
 	}
 
 	if size < 0 {
 		return nil, ErrNegativeFrameSize
 	}
 
+	if int(size) > MaxFrameSize {
+		return nil, ErrFrameTooLarge
+	}
+
 	frame = make([]byte, size)
-	err = binary.Read(r, binary.LittleEndian, &frame)
-	return
+	_, err = io.ReadFull(r, frame)
+	if err != nil {
+		return nil, truncatedErr(err, io.EOF)
+	}
+	return frame, nil
+}
+
+// truncatedErr turns io.ErrUnexpectedEOF and the other errors given into ErrTruncated
+func truncatedErr(err error, truncated ...error) error {
+	if err == io.ErrUnexpectedEOF {
+		return ErrTruncated
+	}
+	for _, t := range truncated {
+		if err == t {
+			return ErrTruncated
+		}
+	}
+	return err
+}
+
+// DecodeFrameInto is the same as DecodeFrame but reads the frame into buf instead of allocating a new one,
+// returning the number of bytes in the frame. If buf is too small the frame is skipped so the next call
+// reads the next frame, and io.ErrShortBuffer is returned with n set to the size needed.
+func DecodeFrameInto(r io.Reader, buf []byte) (n int, err error) {
+	var sizeBuf [2]byte
+	_, err = io.ReadFull(r, sizeBuf[:])
+	if err != nil {
+		return 0, truncatedErr(err)
+	}
+
+	size := int16(binary.LittleEndian.Uint16(sizeBuf[:]))
+	if size < 0 {
+		return 0, ErrNegativeFrameSize
+	}
+
+	if int(size) > MaxFrameSize {
+		return 0, ErrFrameTooLarge
+	}
+
+	if int(size) > len(buf) {
+		_, err = io.CopyN(ioutil.Discard, r, int64(size))
+		if err != nil {
+			return 0, truncatedErr(err, io.EOF)
+		}
+		return int(size), io.ErrShortBuffer
+	}
+
+	n, err = io.ReadFull(r, buf[:size])
+	if err != nil {
+		return 0, truncatedErr(err, io.EOF)
+	}
+	return n, nil
 }
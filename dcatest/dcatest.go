@@ -0,0 +1,203 @@
+// Package dcatest has helpers for testing code producing dca audio, comparing the output
+// to golden files with tolerances, since the exact output changes between ffmpeg versions.
+package dcatest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jonas747/dca"
+)
+
+// Update makes CheckGolden write the golden files instead of comparing with them, for example set by a -update flag
+var Update bool
+
+// Summary is the parts of encoded audio that are compared
+type Summary struct {
+	Frames   int           `json:"frames"`
+	Duration time.Duration `json:"duration"`
+	Bytes    int           `json:"bytes"` // Total size of the opus frames
+}
+
+// Summarize reads all the frames of r
+func Summarize(r dca.OpusReader) (*Summary, error) {
+	s := &Summary{}
+	for {
+		frame, err := r.OpusFrame()
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.Frames++
+		s.Duration += r.FrameDuration()
+		s.Bytes += len(frame)
+	}
+}
+
+// Tolerance is how much a Summary can differ from the expected one
+type Tolerance struct {
+	Frames   int           // Difference in the number of frames
+	Duration time.Duration // Difference in duration
+	Bytes    float64       // Difference in size as a fraction of the expected size (0.1 = 10%)
+}
+
+// DefaultTolerance covers the differences between ffmpeg and libopus versions
+var DefaultTolerance = Tolerance{
+	Frames:   3,
+	Duration: 60 * time.Millisecond,
+	Bytes:    0.1,
+}
+
+// Compare returns an error describing the differences if s isn't within tol of want
+func (s *Summary) Compare(want *Summary, tol Tolerance) error {
+	var diffs []string
+	if abs(s.Frames-want.Frames) > tol.Frames {
+		diffs = append(diffs, fmt.Sprintf("%d frames, expected %d±%d", s.Frames, want.Frames, tol.Frames))
+	}
+	if time.Duration(abs(int(s.Duration-want.Duration))) > tol.Duration {
+		diffs = append(diffs, fmt.Sprintf("duration %s, expected %s±%s", s.Duration, want.Duration, tol.Duration))
+	}
+	if math.Abs(float64(s.Bytes-want.Bytes)) > float64(want.Bytes)*tol.Bytes {
+		diffs = append(diffs, fmt.Sprintf("%d bytes, expected %d±%.0f%%", s.Bytes, want.Bytes, tol.Bytes*100))
+	}
+
+	if len(diffs) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(diffs, ", "))
+}
+
+// CompareFrames reads got and want to the end and compares them, see Summary.Compare
+func CompareFrames(got, want dca.OpusReader, tol Tolerance) error {
+	gotSummary, err := Summarize(got)
+	if err != nil {
+		return err
+	}
+
+	wantSummary, err := Summarize(want)
+	if err != nil {
+		return err
+	}
+
+	return gotSummary.Compare(wantSummary, tol)
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// ReadGolden reads the summary stored in the golden file at path
+func ReadGolden(path string) (*Summary, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var s *Summary
+	err = json.Unmarshal(data, &s)
+	return s, err
+}
+
+// WriteGolden stores s in the golden file at path, creating the directory if needed
+func WriteGolden(path string, s *Summary) error {
+	data, err := json.MarshalIndent(s, "", "\t")
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, append(data, '\n'), 0644)
+}
+
+// CheckGolden fails the test if got isn't within tol of the summary in the golden file at path.
+// If Update is set the golden file is written instead.
+func CheckGolden(t testing.TB, path string, got *Summary, tol Tolerance) {
+	t.Helper()
+
+	if Update {
+		err := WriteGolden(path, got)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := ReadGolden(path)
+	if err != nil {
+		t.Fatalf("Failed reading golden file (run with Update set to create it): %v", err)
+	}
+
+	err = got.Compare(want, tol)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+	}
+}
+
+// Backend encodes fixtures for golden tests, so the same tests can run with ffmpeg,
+// a fake ffmpeg (see dca.CommandRunner) or without ffmpeg at all
+type Backend interface {
+	Name() string
+	Encode(input string) (dca.OpusReader, error)
+}
+
+// FFmpegBackend encodes fixtures with dca.EncodeFile
+type FFmpegBackend struct {
+	Options *dca.EncodeOptions // nil for dca.StdEncodeOptions
+}
+
+// Name implements Backend
+func (b FFmpegBackend) Name() string {
+	return "ffmpeg"
+}
+
+// Encode implements Backend
+func (b FFmpegBackend) Encode(input string) (dca.OpusReader, error) {
+	options := b.Options
+	if options == nil {
+		options = dca.StdEncodeOptions
+	}
+	return dca.EncodeFile(input, options)
+}
+
+// RunGolden encodes input with backend and checks the result against the golden file at golden.
+// The test is skipped if the backend needs ffmpeg and it isn't installed.
+func RunGolden(t *testing.T, backend Backend, input, golden string, tol Tolerance) {
+	t.Helper()
+
+	r, err := backend.Encode(input)
+	if errors.Is(err, dca.ErrFFmpegNotFound) || errors.Is(err, dca.ErrFFprobeNotFound) {
+		t.Skipf("%s backend unavailable: %v", backend.Name(), err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session, ok := r.(*dca.EncodeSession); ok {
+		defer session.Cleanup()
+	}
+
+	got, err := Summarize(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	CheckGolden(t, golden, got, tol)
+}
@@ -2,9 +2,12 @@ package dca
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"strconv"
 	"time"
@@ -13,6 +16,25 @@ import (
 var (
 	ErrNotDCA        = errors.New("DCA Magic header not found, either not dca or raw dca frames")
 	ErrNotFirstFrame = errors.New("Metadata can only be found in the first frame")
+	ErrCantSeek      = errors.New("Can't seek backwards, the reader doesn't implement io.Seeker")
+
+	ErrMetadataTooLarge = fmt.Errorf("%w: metadata size is negative or larger than MaxMetadataSize", ErrCorrupted)
+	ErrBadMetadata      = fmt.Errorf("%w: metadata is not valid json", ErrCorrupted)
+)
+
+// MaxMetadataSize is the largest metadata in bytes the decoder accepts
+var MaxMetadataSize = 16 * 1024 * 1024
+
+const (
+	// Largest frame that is considered valid in tolerant mode, the max size of a single opus frame.
+	// Packets with multiple frames can be larger, but they're not produced by the encoder.
+	maxResyncFrameSize = 1275
+
+	// Number of frames in a row that has to look valid when resyncing without CRCs
+	resyncFrames = 4
+
+	// Size of the read buffer, large enough to peek at the frames checked when resyncing
+	decoderBufferSize = (maxResyncFrameSize + 2) * resyncFrames
 )
 
 type Decoder struct {
@@ -23,12 +45,44 @@ type Decoder struct {
 
 	// Set to true after the first frame has been read
 	firstFrameProcessed bool
+
+	// The underlying reader, if it implements io.Seeker
+	seeker io.ReadSeeker
+	// Position in the underlying reader
+	pos int64
+	// Number of frames read (or skipped)
+	frameNum int
+	// Offsets of the frames read so far, only tracked if seeker is set
+	frameOffsets []int64
+	// Total number of frames, -1 if not known yet
+	totalFrames int
+	// Position of the first frame in the underlying reader
+	dataStart int64
+	// Used for seeking if set
+	seekTable *SeekTable
+	// Wether frames are followed by a CRC (v2)
+	crc bool
+
+	// If true, damaged frames are skipped by OpusFrame instead of returning an error.
+	// When a frame size is invalid the decoder scans ahead for the next frame that looks valid.
+	Tolerant bool
+	// Number of damaged frames skipped in tolerant mode
+	SkippedFrames int
 }
 
 // NewDecoder returns a new dca decoder
 func NewDecoder(r io.Reader) *Decoder {
 	decoder := &Decoder{
-		r: bufio.NewReader(r),
+		r:           bufio.NewReaderSize(r, decoderBufferSize),
+		totalFrames: -1,
+	}
+
+	if seeker, ok := r.(io.ReadSeeker); ok {
+		pos, err := seeker.Seek(0, io.SeekCurrent)
+		if err == nil {
+			decoder.seeker = seeker
+			decoder.pos = pos
+		}
 	}
 
 	return decoder
@@ -65,52 +119,430 @@ func (d *Decoder) ReadMetadata() error {
 	var metaLen int32
 	err = binary.Read(d.r, binary.LittleEndian, &metaLen)
 	if err != nil {
-		return err
+		return truncatedErr(err, io.EOF)
+	}
+
+	if metaLen < 0 || int64(metaLen) > int64(MaxMetadataSize) {
+		return ErrMetadataTooLarge
 	}
 
-	// Read in the metadata itself
-	jsonBuf := make([]byte, metaLen)
-	err = binary.Read(d.r, binary.LittleEndian, &jsonBuf)
+	// Read in the metadata itself, growing the buffer as it's read
+	// so a bogus length in a short file doesn't allocate MaxMetadataSize
+	var jsonBuf bytes.Buffer
+	_, err = io.CopyN(&jsonBuf, d.r, int64(metaLen))
 	if err != nil {
-		return err
+		return truncatedErr(err, io.EOF)
 	}
+	d.pos += 8 + int64(metaLen)
+	d.dataStart = d.pos
 
 	// And unmarshal it
 	var metadata *Metadata
-	err = json.Unmarshal(jsonBuf, &metadata)
+	err = json.Unmarshal(jsonBuf.Bytes(), &metadata)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrBadMetadata, err)
+	}
 	d.Metadata = metadata
-	return err
+	if metadata == nil {
+		return nil
+	}
+
+	if metadata.SeekTable != nil && d.seekTable == nil {
+		d.SetSeekTable(metadata.SeekTable)
+	}
+
+	if d.FormatVersion >= 2 && metadata.Dca != nil {
+		d.crc = metadata.Dca.CRC
+		if metadata.Dca.Frames > 0 {
+			d.totalFrames = metadata.Dca.Frames
+		}
+	}
+	return nil
 }
 
 // OpusFrame returns the next audio frame
 // If this is the first frame it will also check for metadata in it
+//
+// Damaged frames returns an error wrapping ErrCorrupted, and frames cut short ErrTruncated.
+// In tolerant mode damaged frames are skipped instead.
 func (d *Decoder) OpusFrame() (frame []byte, err error) {
-	if !d.firstFrameProcessed {
-		// Check to see if this contains metadata and read the metadata if so
-		magic, err := d.r.Peek(3)
+	err = d.checkMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		frame, err = d.readFrame()
+		if err == nil || !d.Tolerant || !errors.Is(err, ErrCorrupted) {
+			return frame, err
+		}
+
+		d.SkippedFrames++
+		if err == ErrBadCRC {
+			// The size was fine, so we're already at the next frame
+			continue
+		}
+
+		err = d.resync()
 		if err != nil {
 			return nil, err
 		}
+	}
+}
+
+// readFrame reads the next frame, the size is peeked so the decoder is still at the frame if it's invalid
+func (d *Decoder) readFrame() ([]byte, error) {
+	sizeBuf, err := d.r.Peek(2)
+	if err != nil {
+		if err == io.EOF && len(sizeBuf) > 0 {
+			return nil, ErrTruncated
+		}
+		return nil, err
+	}
+
+	size := int16(binary.LittleEndian.Uint16(sizeBuf))
+	if size < 0 {
+		return nil, ErrNegativeFrameSize
+	}
+	if int(size) > MaxFrameSize || (d.Tolerant && int(size) > maxResyncFrameSize) {
+		return nil, ErrFrameTooLarge
+	}
+
+	d.markFrame()
+	d.r.Discard(2)
+
+	frame := make([]byte, size)
+	_, err = io.ReadFull(d.r, frame)
+	if err != nil {
+		return nil, truncatedErr(err, io.EOF)
+	}
+
+	if d.crc {
+		var sum uint32
+		err = binary.Read(d.r, binary.LittleEndian, &sum)
+		if err != nil {
+			return nil, truncatedErr(err, io.EOF)
+		}
+		d.pos += crc32.Size
+
+		if sum != crc32.ChecksumIEEE(frame) {
+			d.frameNum++
+			d.pos += 2 + int64(len(frame))
+			return nil, ErrBadCRC
+		}
+	}
+
+	d.frameNum++
+	d.pos += 2 + int64(len(frame))
+	return frame, nil
+}
+
+// resync skips ahead one byte at a time until the next bytes looks like a valid frame
+func (d *Decoder) resync() error {
+	for {
+		_, err := d.r.Discard(1)
+		if err != nil {
+			return err
+		}
+		d.pos++
+
+		if d.plausibleFrame() {
+			return nil
+		}
+	}
+}
+
+// plausibleFrame returns true if the next bytes looks like a valid frame,
+// which is checked using the CRC if available, otherwise by checking that the
+// frames following it also have valid sizes
+func (d *Decoder) plausibleFrame() bool {
+	extra := 0
+	if d.crc {
+		extra = crc32.Size
+	}
+
+	offset := 0
+	for i := 0; i < resyncFrames; i++ {
+		buf, err := d.r.Peek(offset + 2)
+		if err != nil {
+			// Reached the end, all the frames so far were valid
+			return i > 0 && err == io.EOF && len(buf) == offset
+		}
+
+		size := int(int16(binary.LittleEndian.Uint16(buf[offset:])))
+		if size <= 0 || size > maxResyncFrameSize {
+			return false
+		}
+
+		frameLen := 2 + size + extra
+		if d.crc {
+			frame, err := d.r.Peek(offset + frameLen)
+			if err != nil {
+				return false
+			}
+			frame = frame[offset:]
+			return binary.LittleEndian.Uint32(frame[2+size:]) == crc32.ChecksumIEEE(frame[2:2+size])
+		}
+
+		offset += frameLen
+	}
+
+	return true
+}
+
+// markFrame records the offset of the frame about to be read
+func (d *Decoder) markFrame() {
+	if d.seeker != nil && d.frameNum == len(d.frameOffsets) {
+		d.frameOffsets = append(d.frameOffsets, d.pos)
+	}
+}
+
+// skipFrame skips the next frame without reading it
+func (d *Decoder) skipFrame() error {
+	d.markFrame()
+
+	var size int16
+	err := binary.Read(d.r, binary.LittleEndian, &size)
+	if err != nil {
+		return truncatedErr(err)
+	}
+
+	if size < 0 {
+		return ErrNegativeFrameSize
+	}
+
+	skip := int(size)
+	if d.crc {
+		skip += crc32.Size
+	}
+
+	_, err = d.r.Discard(skip)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return err
+	}
+
+	d.frameNum++
+	d.pos += 2 + int64(skip)
+	return nil
+}
+
+// Seek moves to the frame at pos (rounded down to a whole frame).
+// Seeking forward skips the frames in between. If the underlying reader implements io.Seeker,
+// positions seen before are seeked to directly, otherwise seeking backwards returns ErrCantSeek.
+func (d *Decoder) Seek(pos time.Duration) error {
+	err := d.checkMetadata()
+	if err != nil {
+		return err
+	}
+
+	target := int(pos / d.FrameDuration())
+	if target < 0 {
+		target = 0
+	}
+
+	if d.seeker != nil && target < len(d.frameOffsets) {
+		return d.seekToFrame(target)
+	}
 
-		if string(magic) == "DCA" {
-			err = d.ReadMetadata()
+	// Jump to the closest frame in the seek table and skip from there
+	if d.seeker != nil && d.seekTable != nil {
+		tableFrame, offset, ok := d.seekTable.lookup(target)
+		if ok && (tableFrame > d.frameNum || target < d.frameNum) {
+			_, err = d.seeker.Seek(d.dataStart+offset, io.SeekStart)
 			if err != nil {
-				return nil, err
+				return err
 			}
+
+			d.r.Reset(d.seeker)
+			d.pos = d.dataStart + offset
+			d.frameNum = tableFrame
+		}
+	}
+
+	if target < d.frameNum {
+		return ErrCantSeek
+	}
+
+	for d.frameNum < target {
+		err = d.skipFrame()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// seekToFrame seeks the underlying reader to a frame that has been seen before
+func (d *Decoder) seekToFrame(n int) error {
+	if n == d.frameNum {
+		return nil
+	}
+
+	_, err := d.seeker.Seek(d.frameOffsets[n], io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	d.r.Reset(d.seeker)
+	d.pos = d.frameOffsets[n]
+	d.frameNum = n
+	return nil
+}
+
+// FrameCount returns the total number of frames, this requires scanning through the whole file
+// the first time it's called, after which the decoder is returned to where it was.
+// The underlying reader has to implement io.Seeker, ErrCantSeek is returned otherwise
+func (d *Decoder) FrameCount() (int, error) {
+	if d.totalFrames >= 0 {
+		return d.totalFrames, nil
+	}
+
+	if d.seeker == nil {
+		return 0, ErrCantSeek
+	}
+
+	err := d.checkMetadata()
+	if err != nil {
+		return 0, err
+	}
+
+	current := d.frameNum
+	for {
+		err = d.skipFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	d.totalFrames = d.frameNum
+
+	return d.totalFrames, d.seekToFrame(current)
+}
+
+// Duration returns the total duration, see FrameCount
+func (d *Decoder) Duration() (time.Duration, error) {
+	frames, err := d.FrameCount()
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(frames) * d.FrameDuration(), nil
+}
+
+// Position returns the position of the next frame
+func (d *Decoder) Position() time.Duration {
+	return time.Duration(d.frameNum) * d.FrameDuration()
+}
+
+// ReadFrame returns the next frame as stored in the file, prefixed with its length.
+// Like OpusFrame the metadata is read automatically and not returned as a frame
+func (d *Decoder) ReadFrame() (frame []byte, err error) {
+	opus, err := d.OpusFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	frame = make([]byte, len(opus)+2)
+	binary.LittleEndian.PutUint16(frame, uint16(len(opus)))
+	copy(frame[2:], opus)
+	return frame, nil
+}
+
+// checkMetadata reads the metadata if this is the first frame and it contains metadata
+func (d *Decoder) checkMetadata() error {
+	if d.firstFrameProcessed {
+		return nil
+	}
+
+	magic, err := d.r.Peek(3)
+	if err != nil {
+		if err == io.EOF && len(magic) > 0 {
+			return ErrTruncated
 		}
+		return err
+	}
+
+	if string(magic) == "DCA" {
+		return d.ReadMetadata()
 	}
 
-	frame, err = DecodeFrame(d.r)
-	return
+	// Raw dca, no metadata
+	d.firstFrameProcessed = true
+	d.dataStart = d.pos
+	return nil
+}
+
+// SetSeekTable sets the seek table to use when seeking, this is done automatically if the metadata contains one
+func (d *Decoder) SetSeekTable(table *SeekTable) {
+	d.seekTable = table
+	if table != nil && table.Frames > 0 {
+		d.totalFrames = table.Frames
+	}
 }
 
 // FrameDuration implements OpusReader, returnining the specified duration per frame
 func (d *Decoder) FrameDuration() time.Duration {
-	if d.Metadata == nil {
-		return 20
+	if d.Metadata == nil || d.Metadata.Opus == nil || d.Metadata.Opus.Channels == 0 {
+		return 20 * time.Millisecond
 	}
 
+	opus := d.Metadata.Opus
+
 	// I don't understand nick, why does it have to be like this nick, please nick, im not having a good time nick.
 	// 960B = pcm framesize of 20ms 1 channel audio
-	return time.Duration(((d.Metadata.Opus.FrameSize/d.Metadata.Opus.Channels)/960)*20) * time.Millisecond
+	dur := time.Duration(((opus.FrameSize/opus.Channels)/960)*20) * time.Millisecond
+
+	// The original dca tool stores the frame size per channel
+	bwmarrin := d.Metadata.Dca != nil && d.Metadata.Dca.Tool != nil && d.Metadata.Dca.Tool.Author == "bwmarrin"
+	if dur == 0 || bwmarrin {
+		sampleRate := opus.SampleRate
+		if sampleRate == 0 {
+			sampleRate = 48000
+		}
+		dur = time.Duration(opus.FrameSize) * time.Second / time.Duration(sampleRate)
+	}
+
+	if dur <= 0 {
+		return 20 * time.Millisecond
+	}
+	return dur
+}
+
+// Chapters returns the chapters stored in the metadata, if any
+func (d *Decoder) Chapters() []*ChapterMetadata {
+	if d.Metadata == nil {
+		return nil
+	}
+
+	return d.Metadata.Chapters
+}
+
+// Extra unmarshals the value stored under key in the extra metadata into v,
+// returns false if there's no such key
+func (d *Decoder) Extra(key string, v interface{}) (bool, error) {
+	if d.Metadata == nil || d.Metadata.Extra == nil {
+		return false, nil
+	}
+
+	return d.Metadata.Extra.Get(key, v)
+}
+
+// ExtraString returns the string stored under key in the extra metadata, or "" if there's none
+func (d *Decoder) ExtraString(key string) string {
+	var str string
+	d.Extra(key, &str)
+	return str
+}
+
+// ExtraInt returns the integer stored under key in the extra metadata, or 0 if there's none
+func (d *Decoder) ExtraInt(key string) int64 {
+	var i int64
+	d.Extra(key, &i)
+	return i
 }
@@ -1,9 +1,16 @@
 package dca
 
 import (
+	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
+	"path/filepath"
+	"runtime"
 	"testing"
+	"time"
 )
 
 func TestDecode(t *testing.T) {
@@ -35,3 +42,666 @@ func TestDecode(t *testing.T) {
 		t.Error("Incorrect number of frames")
 	}
 }
+
+func TestDecodeFrameInto(t *testing.T) {
+	data := []byte{3, 0, 1, 2, 3, 2, 0, 4, 5}
+	r := bytes.NewReader(data)
+	buf := make([]byte, 3)
+
+	n, err := DecodeFrameInto(r, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 3 || !bytes.Equal(buf[:n], []byte{1, 2, 3}) {
+		t.Errorf("Incorrect first frame (got %v)", buf[:n])
+	}
+
+	n, err = DecodeFrameInto(r, buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 || !bytes.Equal(buf[:n], []byte{4, 5}) {
+		t.Errorf("Incorrect second frame (got %v)", buf[:n])
+	}
+
+	_, err = DecodeFrameInto(r, buf)
+	if err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	// The frame that doesn't fit is skipped
+	r = bytes.NewReader(data)
+	n, err = DecodeFrameInto(r, buf[:2])
+	if err != io.ErrShortBuffer || n != 3 {
+		t.Errorf("Expected io.ErrShortBuffer and the size needed, got %d, %v", n, err)
+	}
+
+	n, err = DecodeFrameInto(r, buf[:2])
+	if err != nil || n != 2 || !bytes.Equal(buf[:n], []byte{4, 5}) {
+		t.Errorf("Incorrect frame after a short buffer (got %v): %v", buf[:n], err)
+	}
+}
+
+func TestDecoderSeek(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decoder := NewDecoder(file)
+
+	err = decoder.Seek(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := decoder.OpusFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = decoder.Seek(5 * time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoder.Position() != 5*time.Second {
+		t.Errorf("Incorrect position (got %s expected %s)", decoder.Position(), 5*time.Second)
+	}
+
+	// Backwards, should use the recorded offsets
+	err = decoder.Seek(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := decoder.OpusFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(first, again) {
+		t.Error("Frame after seeking back differs from the first read")
+	}
+}
+
+func TestDecoderFrameCount(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decoder := NewDecoder(file)
+	_, err = decoder.OpusFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := decoder.FrameCount()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 755 {
+		t.Errorf("Incorrect number of frames (got %d expected %d)", frames, 755)
+	}
+
+	// Should be back where we were
+	if decoder.Position() != decoder.FrameDuration() {
+		t.Errorf("Incorrect position after counting frames (got %s)", decoder.Position())
+	}
+}
+
+func TestSeekTable(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	table, err := BuildSeekTable(file, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if table.Frames != 755 {
+		t.Errorf("Incorrect number of frames (got %d expected %d)", table.Frames, 755)
+	}
+
+	// Read the frame at 10.1s by scanning
+	file.Seek(0, io.SeekStart)
+	decoder := NewDecoder(file)
+	for i := 0; i < 505; i++ {
+		decoder.OpusFrame()
+	}
+	expected, err := decoder.OpusFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// And using the seek table
+	file.Seek(0, io.SeekStart)
+	decoder = NewDecoder(file)
+	decoder.SetSeekTable(table)
+	err = decoder.Seek(10100 * time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := decoder.OpusFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(frame, expected) {
+		t.Error("Frame found using the seek table differs from the scanned one")
+	}
+}
+
+func TestWriteV2(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	err = WriteV2(&buf, file, V2Options{CRC: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkV2(t, buf.Bytes(), "v1 to v2+CRC")
+
+	// Converting a v2 file with CRCs again, the offsets must match the output, not the input
+	for _, crc := range []bool{false, true} {
+		var converted bytes.Buffer
+		err = WriteV2(&converted, bytes.NewReader(buf.Bytes()), V2Options{CRC: crc})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkV2(t, converted.Bytes(), fmt.Sprintf("v2+CRC to v2 (CRC %t)", crc))
+	}
+
+	// Corrupt the last frame
+	data := buf.Bytes()
+	data[len(data)-5] ^= 0xff
+	decoder := NewDecoder(bytes.NewReader(data))
+	for {
+		_, err = decoder.OpusFrame()
+		if err != nil {
+			break
+		}
+	}
+	if err != ErrBadCRC {
+		t.Errorf("Expected ErrBadCRC, got %v", err)
+	}
+}
+
+// checkV2 checks the frame count of a v2 file written from testaudio.dca, and that seeking in it works
+func checkV2(t *testing.T, data []byte, name string) {
+	decoder := NewDecoder(bytes.NewReader(data))
+	err := decoder.ReadMetadata()
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	if decoder.FormatVersion != 2 {
+		t.Errorf("%s: Incorrect format version (got %d expected %d)", name, decoder.FormatVersion, 2)
+	}
+
+	frames, err := decoder.FrameCount()
+	if err != nil || frames != 755 {
+		t.Errorf("%s: Incorrect number of frames (got %d, %v expected %d)", name, frames, err, 755)
+	}
+
+	err = decoder.Seek(10 * time.Second)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+
+	frameCounter := 0
+	for {
+		_, err := decoder.OpusFrame()
+		if err != nil {
+			if err != io.EOF {
+				t.Errorf("%s: %v", name, err)
+			}
+			break
+		}
+		frameCounter++
+	}
+	if frameCounter != 255 {
+		t.Errorf("%s: Incorrect number of frames after seeking (got %d expected %d)", name, frameCounter, 255)
+	}
+}
+
+func TestRewriteMetadata(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	err = RewriteMetadata(&buf, file, func(m *Metadata) error {
+		m.SongInfo.Title = "edited"
+		return m.Extra.Set("key", "value")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(&buf)
+	err = decoder.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoder.Metadata.SongInfo.Title != "edited" {
+		t.Errorf("Incorrect title (got %q expected %q)", decoder.Metadata.SongInfo.Title, "edited")
+	}
+	if v := decoder.ExtraString("key"); v != "value" {
+		t.Errorf("Incorrect extra value (got %q expected %q)", v, "value")
+	}
+
+	frameCounter := 0
+	for {
+		_, err := decoder.OpusFrame()
+		if err != nil {
+			if err != io.EOF {
+				t.Error(err)
+			}
+			break
+		}
+		frameCounter++
+	}
+	if frameCounter != 755 {
+		t.Errorf("Incorrect number of frames (got %d expected %d)", frameCounter, 755)
+	}
+}
+
+func TestEditMetadataFileMode(t *testing.T) {
+	data, err := ioutil.ReadFile("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "dca-edit")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "song.dca")
+	err = ioutil.WriteFile(path, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Not affected by the umask
+	os.Chmod(path, 0644)
+
+	err = EditMetadataFile(path, func(m *Metadata) error {
+		m.SongInfo.Title = "edited"
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0644 {
+		t.Errorf("Incorrect file mode after editing (got %v expected %v)", info.Mode().Perm(), os.FileMode(0644))
+	}
+}
+
+func TestConcat(t *testing.T) {
+	data, err := ioutil.ReadFile("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	err = Concat(&buf, bytes.NewReader(data), bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	frames, err := NewDecoder(bytes.NewReader(buf.Bytes())).FrameCount()
+	if err != nil || frames != 755*2 {
+		t.Errorf("Incorrect number of frames (got %d, %v expected %d)", frames, err, 755*2)
+	}
+}
+
+func TestNewAnyReader(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	reader, err := NewAnyReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder, ok := reader.(*Decoder)
+	if !ok {
+		t.Fatalf("Expected a *Decoder, got %T", reader)
+	}
+
+	err = decoder.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = NewAnyReader(bytes.NewReader([]byte{0, 0x80, 1, 2}))
+	if err != ErrUnknownFormat {
+		t.Errorf("Expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+func TestDecoderTolerant(t *testing.T) {
+	data, err := ioutil.ReadFile("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Find the offset of the 11th frame and corrupt its size
+	decoder := NewDecoder(bytes.NewReader(data))
+	for i := 0; i < 10; i++ {
+		_, err = decoder.OpusFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	off := decoder.pos
+	data[off], data[off+1] = 0xff, 0x7f
+
+	decoder = NewDecoder(bytes.NewReader(data))
+	decoder.Tolerant = true
+	frameCounter := 0
+	for {
+		_, err := decoder.OpusFrame()
+		if err != nil {
+			if err != io.EOF {
+				t.Error(err)
+			}
+			break
+		}
+		frameCounter++
+	}
+	if frameCounter != 754 || decoder.SkippedFrames != 1 {
+		t.Errorf("Incorrect number of frames (got %d, skipped %d expected %d, skipped 1)", frameCounter, decoder.SkippedFrames, 754)
+	}
+
+	// Truncated in the middle of a frame
+	decoder = NewDecoder(bytes.NewReader(data[:off+10]))
+	for {
+		_, err = decoder.OpusFrame()
+		if err != nil {
+			break
+		}
+	}
+	if err != ErrTruncated {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	report, err := Verify(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Frames != 755 {
+		t.Errorf("Unexpected report (got %d frames, problems %v expected %d frames, no problems)", report.Frames, report.Problems, 755)
+	}
+}
+
+func TestParsePacket(t *testing.T) {
+	// CELT fullband 20ms stereo, 1 frame
+	info, err := ParsePacket([]byte{31<<3 | 0x4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode != ModeCELT || info.Bandwidth != BandwidthFullband || info.Channels() != 2 || info.Duration() != 20*time.Millisecond {
+		t.Errorf("Unexpected packet info %+v", info)
+	}
+
+	// SILK wideband 60ms mono, 2 frames
+	info, err = ParsePacket([]byte{11<<3 | 0x1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode != ModeSILK || info.Bandwidth != BandwidthWideband || info.Channels() != 1 || info.Duration() != 120*time.Millisecond {
+		t.Errorf("Unexpected packet info %+v", info)
+	}
+
+	// Code 3 with too many frames
+	_, err = ParsePacket([]byte{31<<3 | 0x3, 10})
+	if err != ErrBadPacket {
+		t.Errorf("Expected ErrBadPacket, got %v", err)
+	}
+}
+
+func TestTimestampedReader(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decoder := NewDecoder(file)
+	err = decoder.Seek(time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader := NewTimestampedReader(decoder)
+	var last *TimestampedFrame
+	for {
+		frame, err := reader.Next()
+		if err != nil {
+			if err != io.EOF {
+				t.Error(err)
+			}
+			break
+		}
+		last = frame
+	}
+
+	if last == nil || last.Timestamp != 754*20*time.Millisecond {
+		t.Errorf("Incorrect timestamp of the last frame (got %v expected %v)", last, 754*20*time.Millisecond)
+	}
+}
+
+func TestMeasureLevel(t *testing.T) {
+	level := MeasureLevel([]int16{16384, -16384, 16384, -16384})
+	if level.RMS != 0.5 || level.Peak != 0.5 {
+		t.Errorf("Incorrect level, got %+v", level)
+	}
+
+	if db := MeasureLevel(make([]int16, 10)).PeakDB(); !math.IsInf(db, -1) {
+		t.Errorf("Expected -Inf dBFS for silence, got %v", db)
+	}
+}
+
+func TestTeeOpusReader(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	tee := TeeOpusReader(NewDecoder(file), &buf)
+	for {
+		_, err := tee.OpusFrame()
+		if err != nil {
+			break
+		}
+	}
+	if tee.Err() != nil || tee.Frames() != 755 {
+		t.Fatalf("Expected 755 frames written, got %d: %v", tee.Frames(), tee.Err())
+	}
+
+	count, err := NewDecoder(bytes.NewReader(buf.Bytes())).FrameCount()
+	if err != nil || count != 755 {
+		t.Errorf("Incorrect number of frames in the written dca (got %d expected %d): %v", count, 755, err)
+	}
+
+	// 40ms stereo frames without metadata, the frame duration has to survive the fallback metadata
+	buf.Reset()
+	tee = TeeOpusReader(&sliceReader{frames: [][]byte{{0x14, 0}, {0x14, 0}, {0x14, 0}}, duration: 40 * time.Millisecond}, &buf)
+	for {
+		_, err := tee.OpusFrame()
+		if err != nil {
+			break
+		}
+	}
+
+	decoder := NewDecoder(bytes.NewReader(buf.Bytes()))
+	duration, err := decoder.Duration()
+	if err != nil || decoder.FrameDuration() != 40*time.Millisecond || duration != 120*time.Millisecond {
+		t.Errorf("Incorrect frame duration (got %v, total %v expected 40ms, 120ms): %v", decoder.FrameDuration(), duration, err)
+	}
+}
+
+// sliceReader is an OpusReader without metadata returning frames
+type sliceReader struct {
+	frames   [][]byte
+	duration time.Duration
+}
+
+func (s *sliceReader) OpusFrame() ([]byte, error) {
+	if len(s.frames) == 0 {
+		return nil, io.EOF
+	}
+	frame := s.frames[0]
+	s.frames = s.frames[1:]
+	return frame, nil
+}
+
+func (s *sliceReader) FrameDuration() time.Duration {
+	return s.duration
+}
+
+func TestDecoderForEachFrame(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	decoder := NewDecoder(file)
+	frames := 0
+	err = decoder.ForEachFrame(func(frame []byte) bool {
+		frames++
+		return frames < 100
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 100 {
+		t.Errorf("Expected to stop after 100 frames, got %d", frames)
+	}
+
+	err = decoder.ForEachFrame(func(frame []byte) bool {
+		frames++
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if frames != 755 {
+		t.Errorf("Expected 755 frames, got %d", frames)
+	}
+}
+
+// fuzzSeed returns the start of testaudio.dca, the metadata and the first frames
+func fuzzSeed(f *testing.F) []byte {
+	data, err := ioutil.ReadFile("testaudio.dca")
+	if err != nil {
+		f.Fatal(err)
+	}
+	if len(data) > 4096 {
+		data = data[:4096]
+	}
+	return data
+}
+
+func FuzzReadMetadata(f *testing.F) {
+	seed := fuzzSeed(f)
+	f.Add(seed)
+	f.Add(seed[:64])
+	f.Add([]byte("DCA1\xff\xff\xff\xff"))
+	f.Add([]byte("DCA2\x02\x00\x00\x00{}"))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoder := NewDecoder(bytes.NewReader(data))
+		err := decoder.ReadMetadata()
+		if err != nil {
+			return
+		}
+
+		decoder.FrameDuration()
+		decoder.Chapters()
+		for i := 0; i < 100; i++ {
+			if _, err := decoder.OpusFrame(); err != nil {
+				break
+			}
+		}
+
+		// Seeking uses the seek table from the metadata if there is one
+		decoder.Seek(0)
+		decoder.Seek(time.Second)
+		decoder.Duration()
+	})
+}
+
+func FuzzDecodeFrame(f *testing.F) {
+	f.Add([]byte{3, 0, 1, 2, 3})
+	f.Add([]byte{0xff, 0xff, 1})
+	f.Add([]byte{1})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		frame, err := DecodeFrame(bytes.NewReader(data))
+		if err == nil && len(frame) > len(data)-2 {
+			t.Fatalf("Frame of %d bytes from %d bytes of input", len(frame), len(data))
+		}
+
+		buf := make([]byte, 16)
+		n, err := DecodeFrameInto(bytes.NewReader(data), buf)
+		if err == nil && n > len(buf) {
+			t.Fatalf("Read %d bytes into a buffer of %d", n, len(buf))
+		}
+	})
+}
+
+func FuzzDecoderTolerant(f *testing.F) {
+	seed := fuzzSeed(f)
+	f.Add(seed)
+	f.Add(seed[len(seed)/2:])
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		decoder := NewDecoder(bytes.NewReader(data))
+		decoder.Tolerant = true
+		for i := 0; i < 100; i++ {
+			if _, err := decoder.OpusFrame(); err != nil {
+				break
+			}
+		}
+
+		decoder.Seek(0)
+		decoder.FrameCount()
+
+		if source, err := NewFileSource(bytes.NewReader(data), int64(len(data))); err == nil {
+			reader := source.Reader()
+			for i := 0; i < source.Frames(); i++ {
+				reader.OpusFrame()
+			}
+		}
+	})
+}
@@ -0,0 +1,113 @@
+package dca
+
+import (
+	"strconv"
+	"strings"
+)
+
+// DownmixOptions controls how surround sources are downmixed to stereo, the levels are gains (1 = unchanged)
+type DownmixOptions struct {
+	CenterLevel   float64 // Level of the center channel(s) mixed into both sides, where the dialog usually is (ex 0.707 for -3dB)
+	SurroundLevel float64 // Level of the surround (back and side) channels mixed into their side
+	LFELevel      float64 // Level of the low frequency channel mixed into both sides, 0 drops it
+	Normalize     bool    // Scale the levels down so the sum can't clip, at the cost of a lower volume
+}
+
+// DefaultDownmix is the usual downmix with the center and surround channels at -3dB and no LFE
+var DefaultDownmix = &DownmixOptions{
+	CenterLevel:   0.707,
+	SurroundLevel: 0.707,
+}
+
+// Channels in ffmpeg's channel layouts, https://ffmpeg.org/ffmpeg-utils.html#Channel-Layout
+var channelLayouts = map[string][]string{
+	"2.1":        {"FL", "FR", "LFE"},
+	"3.0":        {"FL", "FR", "FC"},
+	"3.1":        {"FL", "FR", "FC", "LFE"},
+	"4.0":        {"FL", "FR", "FC", "BC"},
+	"4.1":        {"FL", "FR", "FC", "LFE", "BC"},
+	"quad":       {"FL", "FR", "BL", "BR"},
+	"quad(side)": {"FL", "FR", "SL", "SR"},
+	"5.0":        {"FL", "FR", "FC", "BL", "BR"},
+	"5.0(side)":  {"FL", "FR", "FC", "SL", "SR"},
+	"5.1":        {"FL", "FR", "FC", "LFE", "BL", "BR"},
+	"5.1(side)":  {"FL", "FR", "FC", "LFE", "SL", "SR"},
+	"6.0":        {"FL", "FR", "FC", "BC", "SL", "SR"},
+	"6.1":        {"FL", "FR", "FC", "LFE", "BC", "SL", "SR"},
+	"7.0":        {"FL", "FR", "FC", "BL", "BR", "SL", "SR"},
+	"7.1":        {"FL", "FR", "FC", "LFE", "BL", "BR", "SL", "SR"},
+	"7.1(wide)":  {"FL", "FR", "FC", "LFE", "BL", "BR", "FLC", "FRC"},
+}
+
+// panFilter returns the pan filter downmixing the channel layout to stereo, empty if the layout is unknown
+func (d *DownmixOptions) panFilter(layout string) string {
+	channels, ok := channelLayouts[layout]
+	if !ok {
+		return ""
+	}
+
+	// pan renormalizes the gains with < instead of =
+	op := "="
+	if d.Normalize {
+		op = "<"
+	}
+
+	left := d.sideMix(channels, "L")
+	right := d.sideMix(channels, "R")
+	return "pan=stereo|FL" + op + left + "|FR" + op + right
+}
+
+// sideMix returns the pan expression for one side, side is "L" or "R"
+func (d *DownmixOptions) sideMix(channels []string, side string) string {
+	var terms []string
+	add := func(gain float64, channel string) {
+		if gain <= 0 {
+			return
+		}
+		if gain == 1 {
+			terms = append(terms, channel)
+			return
+		}
+		terms = append(terms, strconv.FormatFloat(gain, 'f', -1, 64)+"*"+channel)
+	}
+
+	for _, c := range channels {
+		switch c {
+		case "F" + side, "F" + side + "C":
+			add(1, c)
+		case "FC", "BC":
+			add(d.CenterLevel, c)
+		case "B" + side, "S" + side:
+			add(d.SurroundLevel, c)
+		case "LFE":
+			add(d.LFELevel, c)
+		}
+	}
+
+	return strings.Join(terms, "+")
+}
+
+// downmixFilter returns the pan filter for the Downmix option, empty if it's not used
+// or the input isn't a surround file with a known layout
+func (e *EncodeSession) downmixFilter() string {
+	if e.options.Downmix == nil || e.options.Channels != 2 || e.filePath == "" {
+		return ""
+	}
+
+	data, err := e.probe()
+	if err != nil {
+		e.options.logger().Error("FFprobe error", "err", err)
+		return ""
+	}
+
+	streams := data.AudioStreams()
+	if e.options.AudioStreamIndex >= len(streams) {
+		return ""
+	}
+
+	stream := streams[e.options.AudioStreamIndex]
+	if stream.Channels <= 2 {
+		return ""
+	}
+	return e.options.Downmix.panFilter(stream.ChannelLayout)
+}
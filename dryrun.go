@@ -0,0 +1,80 @@
+package dca
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNoAudioStream = errors.New("No audio stream to encode in the input")
+)
+
+// DryRunResult is what an encode of an input would do, see DryRun
+type DryRunResult struct {
+	Duration time.Duration    // Duration of the input, 0 if unknown (live streams etc)
+	Probe    *FFprobeMetadata // The ffprobe output for the input
+	Stream   *FFprobeStream   // The audio stream that would be encoded
+	CopyOpus bool             // Wether the opus audio would be copied as is instead of re-encoded
+	FFmpeg   *FFmpegInfo      // Version of ffmpeg, nil if it couldn't be checked
+	Options  *EncodeOptions   // The options with AutoChannels and AutoFrameRate resolved
+	Args     []string         // The arguments ffmpeg would be run with (excluding the executable)
+}
+
+// DryRun checks that input (a file or url) can be encoded with options without encoding it,
+// by validating the options and running ffprobe on the input. Useful for checking user submitted urls
+// before starting an encode.
+//
+// ErrNoAudioStream is returned if the input has no audio stream at options.AudioStreamIndex,
+// and the ffprobe error if it can't be read.
+func DryRun(ctx context.Context, input string, options *EncodeOptions) (*DryRunResult, error) {
+	if options == nil {
+		options = StdEncodeOptions
+	}
+
+	err := options.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	result := &DryRunResult{}
+	result.FFmpeg, err = ffmpegVersion(options.runner(), options.ffmpegPath())
+	if err == ErrFFmpegNotFound {
+		return nil, err
+	} else if err == nil && !result.FFmpeg.Libopus {
+		return nil, ErrNoLibopus
+	}
+
+	result.Probe, err = probe(ctx, options.runner(), options.ffprobePath(), input, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	streams := result.Probe.AudioStreams()
+	if options.AudioStreamIndex >= len(streams) {
+		return nil, fmt.Errorf("%w: found %d audio streams, AudioStreamIndex is %d", ErrNoAudioStream, len(streams), options.AudioStreamIndex)
+	}
+	result.Stream = streams[options.AudioStreamIndex]
+	result.Duration = result.Probe.Format.ParsedDuration()
+
+	// A session that's never started, to resolve the options the same way as when encoding
+	session := newEncodeSession(options)
+	session.filePath = input
+	session.probeData = result.Probe
+	if options.AutoChannels || options.AutoFrameRate {
+		session.resolveAutoFormat()
+	}
+	if options.CopyOpus {
+		session.copyStream = session.opusCopyStream()
+	}
+
+	result.CopyOpus = session.copyStream != nil
+	result.Options = session.options
+	result.Args = session.options.ffmpegArgs(input, ffmpegArgsEnv{
+		copyOpus: result.CopyOpus,
+		downmix:  session.downmixFilter(),
+		ffmpeg:   result.FFmpeg,
+	})
+	return result, nil
+}
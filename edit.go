@@ -0,0 +1,92 @@
+package dca
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// RewriteMetadata copies the dca file in r to w, with the metadata changed by edit.
+// The audio frames are copied as is. If the file has no metadata, edit is given a new one.
+func RewriteMetadata(w io.Writer, r io.Reader, edit func(m *Metadata) error) error {
+	decoder := NewDecoder(r)
+	err := decoder.checkMetadata()
+	if err != nil {
+		return err
+	}
+
+	version := int8(decoder.FormatVersion)
+	metadata := decoder.Metadata
+	if metadata == nil {
+		version = FormatVersion
+		metadata = &Metadata{
+			Dca: &DCAMetadata{
+				Version: FormatVersion,
+			},
+			SongInfo: &SongMetadata{},
+			Origin:   &OriginMetadata{},
+			Extra:    &ExtraMetadata{},
+		}
+	}
+
+	if metadata.SongInfo == nil {
+		metadata.SongInfo = &SongMetadata{}
+	}
+	if metadata.Extra == nil {
+		metadata.Extra = &ExtraMetadata{}
+	}
+
+	err = edit(metadata)
+	if err != nil {
+		return err
+	}
+
+	err = writeMetadataHeader(w, version, metadata)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(w, decoder.r)
+	return err
+}
+
+// EditMetadataFile changes the metadata of the dca file at path using edit, see RewriteMetadata.
+// The new file is written next to it and then moved in place.
+func EditMetadataFile(path string, edit func(m *Metadata) error) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(out.Name())
+
+	err = RewriteMetadata(out, in, edit)
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	// TempFile creates it as 0600, keep the permissions of the original
+	info, err := in.Stat()
+	if err == nil {
+		err = out.Chmod(info.Mode().Perm())
+	}
+	if err != nil {
+		out.Close()
+		return err
+	}
+
+	err = out.Close()
+	if err != nil {
+		return err
+	}
+
+	in.Close()
+	return os.Rename(out.Name(), path)
+}
@@ -3,22 +3,23 @@ package dca
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/binary"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"image/jpeg"
-	"image/png"
+	"image"
 	"io"
+	"io/ioutil"
+	"math"
 	"os"
 	"os/exec"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-
-	"github.com/jonas747/ogg"
 )
 
 // AudioApplication is an application profile for opus encoding
@@ -30,32 +31,124 @@ var (
 	AudioApplicationLowDelay AudioApplication = "lowdelay" // Restrict to only the lowest delay modes.
 )
 
+// VBRMode is the bitrate mode for opus encoding
+type VBRMode string
+
+var (
+	VBRModeDefault     VBRMode = ""            // Use the VBR bool
+	VBRModeOff         VBRMode = "off"         // Hard constant bitrate
+	VBRModeOn          VBRMode = "on"          // Variable bitrate
+	VBRModeConstrained VBRMode = "constrained" // Variable bitrate that stays close to the target bitrate
+)
+
+var (
+	ErrBadFrame        = errors.New("Bad Frame")
+	ErrFFmpegNotFound  = errors.New("ffmpeg executable not found")
+	ErrFFprobeNotFound = errors.New("ffprobe executable not found")
+	ErrInvalidOptions  = errors.New("Invalid encode options")
+	ErrCleanupTimeout  = errors.New("Timed out waiting for ffmpeg to exit")
+	ErrNoFrameReady    = errors.New("No frame ready yet")
+)
+
+// ErrFFmpegExited is returned when ffmpeg exited with an error
+type ErrFFmpegExited struct {
+	Code   int    // Exit code of ffmpeg
+	Stderr string // The last lines ffmpeg printed to stderr
+}
+
+func (e *ErrFFmpegExited) Error() string {
+	return fmt.Sprintf("ffmpeg exited with code %d: %s", e.Code, e.Stderr)
+}
+
 var (
-	ErrBadFrame = errors.New("Bad Frame")
+	// FFmpegPath is the ffmpeg executable used when EncodeOptions.FFmpegPath is empty
+	FFmpegPath = "ffmpeg"
+	// FFprobePath is the ffprobe executable used when EncodeOptions.FFprobePath is empty
+	FFprobePath = "ffprobe"
+
+	// MaxStderrLines is how many of the last lines ffmpeg printed to stderr are kept
+	// and included in the error if ffmpeg fails
+	MaxStderrLines = 10
+
+	// CleanupTimeout is how long Cleanup waits for ffmpeg to exit before killing it again
+	CleanupTimeout = 5 * time.Second
 )
 
 // EncodeOptions is a set of options for encoding dca
 type EncodeOptions struct {
-	Volume           int              // change audio volume (256=normal)
+	Volume           int              // change audio volume (256=normal, 0 is also normal), ignored if Gain is set
+	Gain             float64          // Volume multiplier (1=normal) applied with the volume filter, 0 to use Volume
 	Channels         int              // audio channels
 	FrameRate        int              // audio sampling rate (ex 48000)
 	FrameDuration    int              // audio frame duration can be 20, 40, or 60 (ms)
 	Bitrate          int              // audio encoding bitrate in kb/s can be 8 - 128
 	PacketLoss       int              // expected packet loss percentage
+	FEC              bool             // Enable in-band forward error correction, with redundancy based on PacketLoss (ffmpeg only)
+	DTX              bool             // Enable discontinuous transmission, lowering the bitrate during silence (ffmpeg only)
 	RawOutput        bool             // Raw opus output (no metadata or magic bytes)
 	Application      AudioApplication // Audio application
-	CoverFormat      string           // Format the cover art will be encoded with (ex "jpeg)
+	CoverFormat      string           // Format the cover art will be encoded with, "jpeg" (default), "png", "webp" or "original" to keep it as is
+	CoverMaxSize     int              // Max width and height of the cover art, it's downscaled if larger, 0 for no limit
+	CoverMaxBytes    int              // Max size of the encoded cover art in bytes, it's downscaled until it fits or dropped, 0 for no limit
 	CompressionLevel int              // Compression level, higher is better qualiy but slower encoding (0 - 10)
-	BufferedFrames   int              // How big the frame buffer should be
-	VBR              bool             // Wether vbr is used or not (variable bitrate)
+	BufferedFrames   int              // How big the frame buffer should be, 0 for unbuffered
+	LowLatency       bool             // Minimize buffering and probing in ffmpeg, for live sources like microphones or tts
+	Realtime         bool             // Produce frames at playback speed (ffmpeg's -re) instead of as fast as possible
+	SpillToDisk      bool             // Store frames that don't fit in the frame buffer in a temporary file instead of waiting for them to be read
+	SpillDir         string           // Directory for the temporary files used by SpillToDisk and EncodeFileSegmented, defaults to os.TempDir()
+	VBR              bool             // Wether vbr is used or not (variable bitrate), ignored if VBRMode is set
+	VBRMode          VBRMode          // Bitrate mode, overrides VBR if set. Constrained is the same as on when encoding without ffmpeg
 	Threads          int              // Number of threads to use, 0 for auto
+	ResourceLimits   *ResourceLimits  // Limits applied to the ffmpeg process, nil for no limits
+	ProcessPriority  int              // Nice level of ffmpeg (-20 to 19, higher is lower priority), mapped to a priority class on windows. 0 to leave it as is
+	StopGracePeriod  time.Duration    // How long Stop gives ffmpeg to exit before killing it, 0 to kill it right away
+	Limiter          *Limiter         // Limits the number of sessions running at the same time, defaults to DefaultLimiter
+	Logger           Logger           // Logger for the diagnostics of the session, defaults to DefaultLogger
+	Metrics          Metrics          // Receives events of the session, defaults to DefaultMetrics
+	LogArgs          bool             // Log the ffmpeg command line at the info level instead of debug
+	CommandRunner    CommandRunner    // Creates the ffmpeg and ffprobe commands, defaults to DefaultCommandRunner
 	StartTime        int              // Start Time of the input stream in seconds
+	AudioStreamIndex int              // Index of the audio stream to encode (0 for the first audio stream)
+	CopyOpus         bool             // Copy opus audio (ex webm from youtube) as is instead of re-encoding it, if no filters or volume changes are used and FrameDuration is 20
+	AutoChannels     bool             // Use the number of channels in the input (mono stays mono) instead of Channels, for files only
+	AutoFrameRate    bool             // Use the sample rate of the input (rounded up to one opus supports) instead of FrameRate, for files only
+
+	// How surround (5.1, 7.1 etc) files are downmixed when Channels is 2, nil to let ffmpeg do it.
+	// Only used for files, the channel layout is found with ffprobe.
+	Downmix *DownmixOptions
 
 	// The ffmpeg audio filters to use, see https://ffmpeg.org/ffmpeg-filters.html#Audio-Filters for more info
 	// Leave empty to use no filters.
 	AudioFilter string
 
 	Comment string // Leave a comment in the metadata
+
+	// Song info and origin to store in the metadata, non-empty fields overrides what was found by ffprobe.
+	// Useful with EncodeMem, where ffprobe isn't used, or when you already know the title etc.
+	SongInfo *SongMetadata
+	Origin   *OriginMetadata
+
+	// Arbitrary data stored in the extra section of the metadata, values has to be encodable as json
+	Extra map[string]interface{}
+
+	// Format of the input, useful for raw pcm from EncodeMem (ex "s16le")
+	// Leave empty to let ffmpeg guess it.
+	InputFormat     string
+	InputSampleRate int // Sample rate of the input, 0 to let ffmpeg decide
+	InputChannels   int // Number of channels in the input, 0 to let ffmpeg decide
+
+	// Options for http(s) inputs
+	UserAgent         string            // User-Agent header sent to the server
+	Headers           map[string]string // Extra headers sent to the server
+	Reconnect         bool              // Reconnect if the connection drops
+	ReconnectDelayMax int               // Max delay in seconds between reconnect attempts, ffmpeg backs off up to this
+
+	// Extra arguments passed to ffmpeg, ExtraInputArgs are placed before the input (-i) and ExtraOutputArgs right before the output
+	ExtraInputArgs  []string
+	ExtraOutputArgs []string
+
+	FFmpegPath  string // Path to the ffmpeg executable, defaults to FFmpegPath
+	FFprobePath string // Path to the ffprobe executable, defaults to FFprobePath
 }
 
 func (e EncodeOptions) PCMFrameLen() int {
@@ -63,30 +156,175 @@ func (e EncodeOptions) PCMFrameLen() int {
 	return 960 * e.Channels * (e.FrameDuration / 20)
 }
 
+// ffmpegPath returns the ffmpeg executable to use
+func (e EncodeOptions) ffmpegPath() string {
+	if e.FFmpegPath != "" {
+		return e.FFmpegPath
+	}
+	return FFmpegPath
+}
+
+// ffprobePath returns the ffprobe executable to use
+func (e EncodeOptions) ffprobePath() string {
+	if e.FFprobePath != "" {
+		return e.FFprobePath
+	}
+	return FFprobePath
+}
+
+// limiter returns the limiter to use, or nil for no limit
+func (e EncodeOptions) limiter() *Limiter {
+	if e.Limiter != nil {
+		return e.Limiter
+	}
+	return DefaultLimiter
+}
+
+// httpInputArgs returns the ffmpeg input arguments for http(s) inputs
+func (e EncodeOptions) httpInputArgs() []string {
+	var args []string
+	if e.UserAgent != "" {
+		args = append(args, "-user_agent", e.UserAgent)
+	}
+
+	if len(e.Headers) > 0 {
+		keys := make([]string, 0, len(e.Headers))
+		for k := range e.Headers {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		// ffmpeg wants them all in one crlf separated string
+		var headers string
+		for _, k := range keys {
+			headers += k + ": " + e.Headers[k] + "\r\n"
+		}
+		args = append(args, "-headers", headers)
+	}
+
+	if e.Reconnect {
+		args = append(args,
+			"-reconnect", "1",
+			"-reconnect_at_eof", "1",
+			"-reconnect_streamed", "1",
+			"-reconnect_delay_max", strconv.Itoa(e.ReconnectDelayMax),
+		)
+	}
+
+	return args
+}
+
+// isHTTPInput returns true if the input is a http or https url
+func isHTTPInput(path string) bool {
+	lower := strings.ToLower(path)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// checkExecutables makes sure the executables needed for encoding are available
+func (e EncodeOptions) checkExecutables(fileInput bool) error {
+	info, err := ffmpegVersion(e.runner(), e.ffmpegPath())
+	if err == ErrFFmpegNotFound {
+		return err
+	} else if err != nil {
+		// Assume it's fine, it will fail on its own if not
+		e.logger().Warn("Failed checking the version of ffmpeg", "err", err)
+	} else if !info.Libopus {
+		return ErrNoLibopus
+	}
+
+	// ffprobe is only needed for the metadata of files
+	if fileInput && !e.RawOutput {
+		if _, err := e.runner().LookPath(e.ffprobePath()); err != nil {
+			return ErrFFprobeNotFound
+		}
+	}
+
+	return nil
+}
+
+// extraMetadata returns the Extra option as metadata
+func (e EncodeOptions) extraMetadata() *ExtraMetadata {
+	extra := ExtraMetadata{}
+	for k, v := range e.Extra {
+		err := extra.Set(k, v)
+		if err != nil {
+			e.logger().Error("Error encoding extra metadata", "key", k, "err", err)
+		}
+	}
+	return &extra
+}
+
 // Validate returns an error if the options are not correct
 func (opts *EncodeOptions) Validate() error {
+	if opts.Gain < 0 {
+		return fmt.Errorf("%w: Gain can't be negative", ErrInvalidOptions)
+	}
+
 	if opts.Volume < 0 || opts.Volume > 512 {
-		return errors.New("Out of bounds volume (0-512)")
+		return fmt.Errorf("%w: Out of bounds volume (0-512)", ErrInvalidOptions)
 	}
 
 	if opts.FrameDuration != 20 && opts.FrameDuration != 40 && opts.FrameDuration != 60 {
-		return errors.New("Invalid FrameDuration")
+		return fmt.Errorf("%w: Invalid FrameDuration", ErrInvalidOptions)
 	}
 
 	if opts.PacketLoss < 0 || opts.PacketLoss > 100 {
-		return errors.New("Invalid packet loss percentage")
+		return fmt.Errorf("%w: Invalid packet loss percentage", ErrInvalidOptions)
 	}
 
 	if opts.Application != AudioApplicationAudio && opts.Application != AudioApplicationVoip && opts.Application != AudioApplicationLowDelay {
-		return errors.New("Invalid audio application")
+		return fmt.Errorf("%w: Invalid audio application", ErrInvalidOptions)
+	}
+
+	switch opts.VBRMode {
+	case VBRModeDefault, VBRModeOff, VBRModeOn, VBRModeConstrained:
+	default:
+		return fmt.Errorf("%w: Invalid VBR mode", ErrInvalidOptions)
+	}
+
+	if opts.ProcessPriority < -20 || opts.ProcessPriority > 19 {
+		return fmt.Errorf("%w: ProcessPriority out of bounds (-20 - 19)", ErrInvalidOptions)
 	}
 
 	if opts.CompressionLevel < 0 || opts.CompressionLevel > 10 {
-		return errors.New("Compression level out of bounds (0-10)")
+		return fmt.Errorf("%w: Compression level out of bounds (0-10)", ErrInvalidOptions)
+	}
+
+	if opts.BufferedFrames < 0 {
+		return fmt.Errorf("%w: BufferedFrames can't be less than 0", ErrInvalidOptions)
+	}
+
+	for k, v := range opts.Extra {
+		if _, err := json.Marshal(v); err != nil {
+			return fmt.Errorf("%w: Extra[%q] can't be encoded as json: %v", ErrInvalidOptions, k, err)
+		}
+	}
+
+	if opts.CoverMaxSize < 0 || opts.CoverMaxBytes < 0 {
+		return fmt.Errorf("%w: Cover limits can't be less than 0", ErrInvalidOptions)
 	}
 
 	if opts.Threads < 0 {
-		return errors.New("Number of threads can't be less than 0")
+		return fmt.Errorf("%w: Number of threads can't be less than 0", ErrInvalidOptions)
+	}
+
+	if opts.AudioStreamIndex < 0 {
+		return fmt.Errorf("%w: Audio stream index can't be less than 0", ErrInvalidOptions)
+	}
+
+	if opts.ReconnectDelayMax < 0 {
+		return fmt.Errorf("%w: Reconnect delay can't be less than 0", ErrInvalidOptions)
+	}
+
+	if opts.InputSampleRate < 0 || opts.InputChannels < 0 {
+		return fmt.Errorf("%w: Input sample rate and channels can't be less than 0", ErrInvalidOptions)
+	}
+
+	// They're sent to ffmpeg as one crlf separated string, so a line break would inject another header
+	for k, v := range opts.Headers {
+		if k == "" || strings.ContainsAny(k, ":\r\n") || strings.ContainsAny(v, "\r\n") {
+			return fmt.Errorf("%w: Invalid header %q", ErrInvalidOptions, k)
+		}
 	}
 
 	return nil
@@ -94,17 +332,20 @@ func (opts *EncodeOptions) Validate() error {
 
 // StdEncodeOptions is the standard options for encoding
 var StdEncodeOptions = &EncodeOptions{
-	Volume:           256,
-	Channels:         2,
-	FrameRate:        48000,
-	FrameDuration:    20,
-	Bitrate:          64,
-	Application:      AudioApplicationAudio,
-	CompressionLevel: 10,
-	PacketLoss:       1,
-	BufferedFrames:   100, // At 20ms frames that's 2s
-	VBR:              true,
-	StartTime:        0,
+	Volume:            256,
+	Channels:          2,
+	FrameRate:         48000,
+	FrameDuration:     20,
+	Bitrate:           64,
+	Application:       AudioApplicationAudio,
+	CompressionLevel:  10,
+	PacketLoss:        1,
+	BufferedFrames:    100, // At 20ms frames that's 2s
+	VBR:               true,
+	StartTime:         0,
+	Reconnect:         true,
+	ReconnectDelayMax: 2,
+	StopGracePeriod:   2 * time.Second,
 }
 
 // EncodeStats is transcode stats reported by ffmpeg
@@ -113,6 +354,39 @@ type EncodeStats struct {
 	Duration time.Duration
 	Bitrate  float32
 	Speed    float32
+
+	OutputBytes    int64     // Total size of the opus frames produced so far
+	BitrateHistory []float32 // The last StatsHistoryLength bitrates, oldest first
+	SpeedHistory   []float32 // The last StatsHistoryLength speeds, oldest first
+
+	// The following are only available if the duration of the input is known
+	SourceDuration time.Duration // Duration of the input
+	Progress       float32       // Percentage of the input (from StartTime) encoded so far (0-100)
+	ETA            time.Duration // Estimated time left until the encode is done
+}
+
+// setProgress fills in the progress fields using the duration of the input,
+// ffmpeg counts the time from startTime so only the part after it is encoded
+func (s *EncodeStats) setProgress(sourceDuration, startTime time.Duration) {
+	if sourceDuration <= 0 {
+		return
+	}
+	s.SourceDuration = sourceDuration
+
+	toEncode := sourceDuration - startTime
+	if toEncode <= 0 {
+		s.Progress = 100
+		return
+	}
+
+	s.Progress = float32(s.Duration) / float32(toEncode) * 100
+	if s.Progress > 100 {
+		s.Progress = 100
+	}
+
+	if s.Speed > 0 && s.Duration < toEncode {
+		s.ETA = time.Duration(float32(toEncode-s.Duration) / s.Speed)
+	}
 }
 
 type Frame struct {
@@ -120,6 +394,19 @@ type Frame struct {
 	metaData bool
 }
 
+// framePool holds frames that have been read, to be reused for new ones
+var framePool = sync.Pool{
+	New: func() interface{} {
+		return &Frame{}
+	},
+}
+
+// putFrame returns a frame to the pool, the frame and its data can't be used after this
+func putFrame(f *Frame) {
+	f.data = f.data[:0]
+	framePool.Put(f)
+}
+
 type EncodeSession struct {
 	sync.Mutex
 	options    *EncodeOptions
@@ -129,13 +416,54 @@ type EncodeSession struct {
 	running      bool
 	started      time.Time
 	frameChannel chan *Frame
+	spool        *frameSpool
 	process      *os.Process
+	native       bool // Encoding with libopus directly instead of ffmpeg
+	stopped      bool // Set by Stop
 	lastStats    *EncodeStats
+	outputBytes  int64 // Size of the opus frames written so far
+	statsChan    chan *EncodeStats
+	done         chan struct{}
+	stop         chan struct{} // closed by Stop
+
+	// the last StatsHistoryLength bitrates and speeds
+	bitrateHistory []float32
+	speedHistory   []float32
+
+	// the metadata written in the metadata frame, nil if none
+	metadata           *Metadata
+	metadataReady      chan struct{}
+	metadataReadyClose sync.Once
+
+	// the arguments ffmpeg was started with
+	args []string
+
+	// cached ffprobe output
+	probeData *FFprobeMetadata
+	// the opus stream being copied as is because of CopyOpus, nil if encoding
+	copyStream *FFprobeStream
+
+	// duration of the input as reported by ffprobe, 0 if unknown
+	sourceDuration time.Duration
 
 	lastFrame int
 	err       error
 
 	ffmpegOutput string
+	// the last MaxStderrLines lines printed by ffmpeg
+	stderrTail []string
+
+	// frame returned to the caller of ReadFrameInto because their buffer was too small
+	pending *Frame
+	// serializes reading frames, so every frame is returned to a single caller
+	readMu sync.Mutex
+
+	// consumers of the frames if Subscribe has been called
+	subscribers    map[*Subscription]struct{}
+	subscribersMu  sync.Mutex
+	subscribeCond  *sync.Cond
+	subscribeStart sync.Once
+	subscribeEnded bool
 
 	// buffer that stores unread bytes (not full frames)
 	// used to implement io.Reader
@@ -149,11 +477,14 @@ func EncodeMem(r io.Reader, options *EncodeOptions) (session *EncodeSession, err
 		return
 	}
 
-	session = &EncodeSession{
-		options:      options,
-		pipeReader:   r,
-		frameChannel: make(chan *Frame, options.BufferedFrames),
+	err = options.checkExecutables(false)
+	if err != nil {
+		return
 	}
+
+	session = newEncodeSession(options)
+	session.pipeReader = r
+	registerSession(session)
 	go session.run()
 	return
 }
@@ -165,21 +496,58 @@ func EncodeFile(path string, options *EncodeOptions) (session *EncodeSession, er
 		return
 	}
 
-	session = &EncodeSession{
-		options:      options,
-		filePath:     path,
-		frameChannel: make(chan *Frame, options.BufferedFrames),
+	err = options.checkExecutables(true)
+	if err != nil {
+		return
 	}
+
+	session = newEncodeSession(options)
+	session.filePath = path
+	registerSession(session)
 	go session.run()
 	return
 }
 
+// newEncodeSession creates a session without starting it. Sessions that are started have to be registered
+// with registerSession, so only sessions that close their frame channel end up being cleaned up by Shutdown.
+func newEncodeSession(options *EncodeOptions) *EncodeSession {
+	session := &EncodeSession{
+		options:      options,
+		frameChannel: make(chan *Frame, options.BufferedFrames),
+		statsChan:    make(chan *EncodeStats, 1),
+		done:         make(chan struct{}),
+		stop:         make(chan struct{}),
+
+		metadataReady: make(chan struct{}),
+	}
+	return session
+}
+
 func (e *EncodeSession) run() {
 	// Reset running state
 	defer func() {
 		e.Lock()
 		e.running = false
 		e.Unlock()
+		close(e.statsChan)
+		e.setMetadataReady()
+		close(e.done)
+		unregisterSession(e)
+	}()
+
+	if limiter := e.options.limiter(); limiter != nil {
+		if !limiter.acquire(e.stop) {
+			// Stopped while waiting in queue
+			close(e.frameChannel)
+			return
+		}
+		defer limiter.release()
+	}
+
+	metrics := e.options.metrics()
+	metrics.EncodeStarted()
+	defer func() {
+		metrics.EncodeFinished(e.Error(), e.Stats())
 	}()
 
 	e.Lock()
@@ -194,45 +562,29 @@ func (e *EncodeSession) run() {
 		e.options = StdEncodeOptions
 	}
 
-	vbrStr := "on"
-	if !e.options.VBR {
-		vbrStr = "off"
-	}
-
-	// Launch ffmpeg with a variety of different fruits and goodies mixed togheter
-	args := []string{
-		"-stats",
-		"-i", inFile,
-		"-reconnect", "1",
-		"-reconnect_at_eof", "1",
-		"-reconnect_streamed", "1",
-		"-reconnect_delay_max", "2",
-		"-map", "0:a",
-		"-acodec", "libopus",
-		"-f", "ogg",
-		"-vbr", vbrStr,
-		"-compression_level", strconv.Itoa(e.options.CompressionLevel),
-		"-vol", strconv.Itoa(e.options.Volume),
-		"-ar", strconv.Itoa(e.options.FrameRate),
-		"-ac", strconv.Itoa(e.options.Channels),
-		"-b:a", strconv.Itoa(e.options.Bitrate * 1000),
-		"-application", string(e.options.Application),
-		"-frame_duration", strconv.Itoa(e.options.FrameDuration),
-		"-packet_loss", strconv.Itoa(e.options.PacketLoss),
-		"-threads", strconv.Itoa(e.options.Threads),
-		"-ss", strconv.Itoa(e.options.StartTime),
+	if e.options.AutoChannels || e.options.AutoFrameRate {
+		e.resolveAutoFormat()
 	}
 
-	if e.options.AudioFilter != "" {
-		// Lit af
-		args = append(args, "-af", e.options.AudioFilter)
+	if e.options.CopyOpus {
+		e.copyStream = e.opusCopyStream()
 	}
 
-	args = append(args, "pipe:1")
+	args := e.options.ffmpegArgs(inFile, ffmpegArgsEnv{
+		copyOpus: e.copyStream != nil,
+		downmix:  e.downmixFilter(),
+		ffmpeg:   e.ffmpegInfo(),
+	})
+	e.args = args
 
-	ffmpeg := exec.Command("ffmpeg", args...)
+	ffmpeg := e.options.runner().CommandContext(context.Background(), e.options.ffmpegPath(), args...)
+	prepareCommand(ffmpeg, e.options.ProcessPriority)
 
-	// logln(ffmpeg.Args)
+	if e.options.LogArgs {
+		e.options.logger().Info("Starting ffmpeg", "args", strings.Join(ffmpeg.Args, " "))
+	} else {
+		e.options.logger().Debug("Starting ffmpeg", "args", strings.Join(ffmpeg.Args, " "))
+	}
 
 	if e.pipeReader != nil {
 		ffmpeg.Stdin = e.pipeReader
@@ -240,29 +592,45 @@ func (e *EncodeSession) run() {
 
 	stdout, err := ffmpeg.StdoutPipe()
 	if err != nil {
+		e.err = err
 		e.Unlock()
-		logln("StdoutPipe Error:", err)
+		e.options.logger().Error("StdoutPipe error", "err", err)
 		close(e.frameChannel)
 		return
 	}
 
 	stderr, err := ffmpeg.StderrPipe()
 	if err != nil {
+		e.err = err
+		e.Unlock()
+		e.options.logger().Error("StderrPipe error", "err", err)
+		close(e.frameChannel)
+		return
+	}
+
+	err = e.setupSpool()
+	if err != nil {
+		e.err = err
 		e.Unlock()
-		logln("StderrPipe Error:", err)
+		e.options.logger().Error("Spool error", "err", err)
 		close(e.frameChannel)
 		return
 	}
 
+	var metaFrame *Frame
 	if !e.options.RawOutput {
-		e.writeMetadataFrame()
+		metaFrame = e.metadataFrame()
+	} else if e.pipeReader == nil {
+		go e.probeSourceDuration()
 	}
+	e.setMetadataReady()
 
 	// Starts the ffmpeg command
 	err = ffmpeg.Start()
 	if err != nil {
+		e.err = err
 		e.Unlock()
-		logln("RunStart Error:", err)
+		e.options.logger().Error("Failed starting ffmpeg", "err", err)
 		close(e.frameChannel)
 		return
 	}
@@ -270,135 +638,373 @@ func (e *EncodeSession) run() {
 	e.started = time.Now()
 
 	e.process = ffmpeg.Process
+	if e.options.ProcessPriority != 0 {
+		err = setProcessPriority(e.process, e.options.ProcessPriority)
+		if err != nil {
+			e.options.logger().Warn("Failed setting the priority of ffmpeg", "err", err)
+		}
+	}
+	if e.options.ResourceLimits != nil {
+		err = applyResourceLimits(e.process, e.options.ResourceLimits)
+		if err != nil {
+			// Don't let it run without the limits
+			e.err = fmt.Errorf("Failed applying resource limits to ffmpeg: %w", err)
+			e.options.logger().Error("Failed applying resource limits to ffmpeg", "err", err)
+			e.process.Kill()
+		}
+	}
+	if e.stopped {
+		// Stopped before we got to start it
+		e.process.Kill()
+	}
 	e.Unlock()
 
 	var wg sync.WaitGroup
 	wg.Add(1)
 	go e.readStderr(stderr, &wg)
 
-	defer close(e.frameChannel)
+	defer e.closeFrameChannel()
+	if metaFrame != nil {
+		e.frameChannel <- metaFrame
+	}
+
 	e.readStdout(stdout)
 	wg.Wait()
 	err = ffmpeg.Wait()
 	if err != nil {
-		if err.Error() != "signal: killed" {
-			e.Lock()
-			e.err = err
-			e.Unlock()
+		e.Lock()
+		// Errors caused by stopping it are expected, and if it was killed because of an error that's the one to keep
+		if !e.stopped && e.err == nil {
+			exitErr := &ErrFFmpegExited{Code: -1, Stderr: strings.Join(e.stderrTail, "\n")}
+			if ee, ok := err.(*exec.ExitError); ok {
+				exitErr.Code = ee.ExitCode()
+			}
+			e.err = exitErr
 		}
+		e.Unlock()
 	}
 }
 
-func (e *EncodeSession) writeMetadataFrame() {
-	// Setup the metadata
-	metadata := Metadata{
-		Dca: &DCAMetadata{
-			Version: FormatVersion,
-			Tool: &DCAToolMetadata{
-				Name:    "dca",
-				Version: LibraryVersion,
-				Url:     GitHubRepositoryURL,
-				Author:  "jonas747",
-			},
-		},
-		Opus: &OpusMetadata{
-			Bitrate:     e.options.Bitrate * 1000,
-			SampleRate:  e.options.FrameRate,
-			Application: string(e.options.Application),
-			FrameSize:   e.options.PCMFrameLen(),
-			Channels:    e.options.Channels,
-			VBR:         e.options.VBR,
-		},
-		SongInfo: &SongMetadata{},
-		Origin:   &OriginMetadata{},
-		Extra:    &ExtraMetadata{},
+// probe runs ffprobe on the input file
+func (e *EncodeSession) probe() (*FFprobeMetadata, error) {
+	if e.probeData != nil {
+		return e.probeData, nil
 	}
-	var cmdBuf bytes.Buffer
-	// get ffprobe data
-	if e.pipeReader == nil {
-		ffprobe := exec.Command("ffprobe", "-v", "quiet", "-print_format", "json", "-show_format", e.filePath)
-		ffprobe.Stdout = &cmdBuf
 
-		err := ffprobe.Start()
-		if err != nil {
-			logln("RunStart Error:", err)
-			return
+	data, err := probeFile(e.filePath, e.options)
+	if err != nil {
+		return nil, err
+	}
+	e.probeData = data
+	return data, nil
+}
+
+// opusCopyStream returns the audio stream to encode if it can be copied as is, nil otherwise
+func (e *EncodeSession) opusCopyStream() *FFprobeStream {
+	opts := e.options
+	// The frame duration of the source can't be changed without encoding, and dca assumes 20ms for opus sources
+	if e.filePath == "" || opts.AudioFilter != "" || opts.gain() != 1 || opts.FrameRate != 48000 || opts.FrameDuration != 20 {
+		return nil
+	}
+
+	data, err := e.probe()
+	if err != nil {
+		e.options.logger().Error("FFprobe error", "err", err)
+		return nil
+	}
+
+	streams := data.AudioStreams()
+	if opts.AudioStreamIndex >= len(streams) {
+		return nil
+	}
+
+	stream := streams[opts.AudioStreamIndex]
+	if stream.CodecName != "opus" || stream.Channels != opts.Channels || stream.ParsedSampleRate() != 48000 {
+		return nil
+	}
+	return stream
+}
+
+// resolveAutoFormat sets the channels and sample rate from the input for AutoChannels and AutoFrameRate,
+// keeping the configured ones if the input isn't a file or ffprobe failed
+func (e *EncodeSession) resolveAutoFormat() {
+	if e.filePath == "" {
+		return
+	}
+
+	data, err := e.probe()
+	if err != nil {
+		e.options.logger().Error("FFprobe error", "err", err)
+		return
+	}
+
+	streams := data.AudioStreams()
+	if e.options.AudioStreamIndex >= len(streams) {
+		return
+	}
+	stream := streams[e.options.AudioStreamIndex]
+
+	// Don't change the options passed in, they may be shared with other sessions
+	options := *e.options
+	if options.AutoChannels && (stream.Channels == 1 || stream.Channels == 2) {
+		options.Channels = stream.Channels
+	}
+
+	if options.AutoFrameRate {
+		if rate := stream.ParsedSampleRate(); rate > 0 {
+			options.FrameRate = 48000
+			for _, opusRate := range []int{8000, 12000, 16000, 24000} {
+				if rate <= opusRate {
+					options.FrameRate = opusRate
+					break
+				}
+			}
 		}
+	}
+
+	e.options = &options
+}
+
+// probeFile runs ffprobe on path
+func probeFile(path string, options *EncodeOptions) (*FFprobeMetadata, error) {
+	return probe(context.Background(), options.runner(), options.ffprobePath(), path, nil)
+}
+
+// probeSourceDuration retrieves the duration of the input file,
+// used for progress reporting when no metadata frame is written
+func (e *EncodeSession) probeSourceDuration() {
+	ffprobeData, err := e.probe()
+	if err != nil {
+		e.options.logger().Error("FFprobe error", "err", err)
+		return
+	}
+
+	e.Lock()
+	e.sourceDuration = ffprobeData.Format.ParsedDuration()
+	e.Unlock()
+}
+
+// setupSpool creates the spool for SpillToDisk if enabled
+func (e *EncodeSession) setupSpool() error {
+	if !e.options.SpillToDisk {
+		return nil
+	}
 
-		err = ffprobe.Wait()
+	spool, err := newFrameSpool(e.options.SpillDir, e.frameChannel, e.options.logger())
+	if err != nil {
+		return err
+	}
+	e.spool = spool
+	return nil
+}
+
+// closeFrameChannel closes the frame channel once all spooled frames (if any) are sent on it
+func (e *EncodeSession) closeFrameChannel() {
+	if e.spool != nil {
+		err := e.spool.close()
 		if err != nil {
-			logln("FFprobe Error:", err)
-			return
+			e.Lock()
+			if e.err == nil {
+				e.err = err
+			}
+			e.Unlock()
 		}
-		var ffprobeData *FFprobeMetadata
-		err = json.Unmarshal(cmdBuf.Bytes(), &ffprobeData)
-		if err != nil {
-			logln("Erorr unmarshaling the FFprobe JSON:", err)
-			return
+	}
+
+	close(e.frameChannel)
+}
+
+// cover extracts the cover art of the input and encodes it to be stored in the metadata,
+// returns nil if there's no cover art or it couldn't be made to fit within CoverMaxBytes
+func (e *EncodeSession) cover() *string {
+	maxSize := e.options.CoverMaxSize
+	for {
+		data, err := e.extractCover(maxSize)
+		if err != nil || len(data) == 0 {
+			// silently drop it, no image
+			return nil
 		}
 
-		if ffprobeData.Format == nil {
-			ffprobeData.Format = &FFprobeFormat{}
+		coverImage := base64.StdEncoding.EncodeToString(data)
+		if e.options.CoverMaxBytes <= 0 || len(coverImage) <= e.options.CoverMaxBytes {
+			return &coverImage
 		}
 
-		if ffprobeData.Format.Tags == nil {
-			ffprobeData.Format.Tags = &FFprobeTags{}
+		// Too big, try again at half the size
+		if maxSize == 0 {
+			maxSize = 1024
+			if config, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+				maxSize = config.Width
+				if config.Height > maxSize {
+					maxSize = config.Height
+				}
+			}
+		}
+
+		maxSize /= 2
+		if maxSize < 16 {
+			e.options.logger().Warn("Dropping cover art, can't make it fit within CoverMaxBytes")
+			return nil
 		}
+	}
+}
+
+// extractCover extracts the attached picture of the input in CoverFormat, downscaled to fit within maxSize if not 0
+func (e *EncodeSession) extractCover(maxSize int) ([]byte, error) {
+	args := []string{"-loglevel", "0", "-i", e.filePath, "-map", "0:v:0", "-frames:v", "1", "-an"}
+	if maxSize > 0 {
+		size := strconv.Itoa(maxSize)
+		args = append(args, "-vf", "scale='min("+size+",iw)':'min("+size+",ih)':force_original_aspect_ratio=decrease")
+	}
+
+	switch e.options.CoverFormat {
+	case "png":
+		args = append(args, "-c:v", "png")
+	case "webp":
+		args = append(args, "-c:v", "libwebp")
+	case "original":
+		if maxSize == 0 {
+			// Copy the attached picture as is
+			args = append(args, "-c:v", "copy")
+			break
+		}
+		// Has to be re-encoded to be scaled
+		fallthrough
+	default:
+		args = append(args, "-c:v", "mjpeg")
+	}
+	args = append(args, "-f", "image2pipe", "pipe:1")
+
+	var cmdBuf bytes.Buffer
+	cover := e.options.runner().CommandContext(context.Background(), e.options.ffmpegPath(), args...)
+	cover.Stdout = &cmdBuf
+
+	err := cover.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return cmdBuf.Bytes(), nil
+}
+
+// dcaMetadata returns the dca section of the metadata for files written by this package
+func dcaMetadata() *DCAMetadata {
+	return &DCAMetadata{
+		Version: FormatVersion,
+		Tool: &DCAToolMetadata{
+			Name:    "dca",
+			Version: LibraryVersion,
+			Url:     GitHubRepositoryURL,
+			Author:  "jonas747",
+		},
+	}
+}
+
+// gain returns the volume multiplier, from Gain or Volume if it's not set.
+// If neither is set it's 1, so options created without Volume aren't silent.
+func (e EncodeOptions) gain() float64 {
+	if e.Gain > 0 {
+		return e.Gain
+	}
+	if e.Volume == 0 {
+		return 1
+	}
+	return float64(e.Volume) / 256
+}
+
+// audioFilter returns the filters to pass to ffmpeg, AudioFilter with the volume filter in front if the volume is changed.
+// The volume filter is used instead of the deprecated -vol option, which newer versions of ffmpeg ignores.
+func (e EncodeOptions) audioFilter() string {
+	gain := e.gain()
+	if gain == 1 {
+		return e.AudioFilter
+	}
+
+	volume := "volume=" + strconv.FormatFloat(gain, 'f', -1, 64)
+	if e.AudioFilter == "" {
+		return volume
+	}
+	return volume + "," + e.AudioFilter
+}
+
+// vbrMode returns the bitrate mode, taking VBR into account if VBRMode isn't set
+func (e EncodeOptions) vbrMode() VBRMode {
+	if e.VBRMode != VBRModeDefault {
+		return e.VBRMode
+	}
 
-		bitrateInt, err := strconv.Atoi(ffprobeData.Format.Bitrate)
+	if e.VBR {
+		return VBRModeOn
+	}
+	return VBRModeOff
+}
+
+// opusMetadata returns the opus section of the metadata for frames encoded with these options
+func (e EncodeOptions) opusMetadata() *OpusMetadata {
+	return &OpusMetadata{
+		Bitrate:     e.Bitrate * 1000,
+		SampleRate:  e.FrameRate,
+		Application: string(e.Application),
+		FrameSize:   e.PCMFrameLen(),
+		Channels:    e.Channels,
+		VBR:         e.vbrMode() != VBRModeOff,
+	}
+}
+
+// metadataFrame creates the metadata frame, returns nil if it failed
+func (e *EncodeSession) metadataFrame() *Frame {
+	// Setup the metadata
+	metadata := Metadata{
+		Dca:      dcaMetadata(),
+		Opus:     e.options.opusMetadata(),
+		SongInfo: &SongMetadata{},
+		Origin:   &OriginMetadata{},
+		Extra:    e.options.extraMetadata(),
+	}
+	// get ffprobe data
+	if e.pipeReader == nil {
+		ffprobeData, err := e.probe()
 		if err != nil {
-			logln("Could not convert bitrate to int:", err)
-			return
+			e.options.logger().Error("FFprobe error", "err", err)
+			return nil
 		}
 
+		e.sourceDuration = ffprobeData.Format.ParsedDuration()
+
+		tags := ffprobeData.Format.Tags
 		metadata.SongInfo = &SongMetadata{
-			Title:    ffprobeData.Format.Tags.Title,
-			Artist:   ffprobeData.Format.Tags.Artist,
-			Album:    ffprobeData.Format.Tags.Album,
-			Genre:    ffprobeData.Format.Tags.Genre,
+			Title:    tags.Title,
+			Artist:   tags.Artist,
+			Album:    tags.Album,
+			Genre:    tags.Genre,
 			Comments: e.options.Comment,
+
+			AlbumArtist: tags.AlbumArtist,
+			Composer:    tags.Composer,
+			Track:       parseTagNumber(tags.Track),
+			Disc:        parseTagNumber(tags.Disc),
+			Date:        tags.Date,
+			Duration:    e.sourceDuration.Seconds(),
 		}
 
+		metadata.Chapters = ffprobeData.chapterMetadata()
+
 		metadata.Origin = &OriginMetadata{
 			Source:   "file",
-			Bitrate:  bitrateInt,
+			Bitrate:  ffprobeData.Format.ParsedBitrate(),
 			Channels: e.options.Channels,
 			Encoding: ffprobeData.Format.FormatLongName,
 		}
 
-		cmdBuf.Reset()
-
 		// get cover art
-		cover := exec.Command("ffmpeg", "-loglevel", "0", "-i", e.filePath, "-f", "singlejpeg", "pipe:1")
-		cover.Stdout = &cmdBuf
-
-		err = cover.Start()
-		if err != nil {
-			logln("RunStart Error:", err)
-			return
-		}
-		var pngBuf bytes.Buffer
-		err = cover.Wait()
-		if err == nil {
-			buf := bytes.NewBufferString(cmdBuf.String())
-			var coverImage string
-			if e.options.CoverFormat == "png" {
-				img, err := jpeg.Decode(buf)
-				if err == nil { // silently drop it, no image
-					err = png.Encode(&pngBuf, img)
-					if err == nil {
-						coverImage = base64.StdEncoding.EncodeToString(pngBuf.Bytes())
-					}
-				}
-			} else {
-				coverImage = base64.StdEncoding.EncodeToString(cmdBuf.Bytes())
-			}
+		metadata.SongInfo.Cover = e.cover()
 
-			metadata.SongInfo.Cover = &coverImage
+		if e.copyStream != nil {
+			// The opus settings are whatever the source was encoded with, copying is only done with 20ms frames
+			metadata.Opus.Bitrate = e.copyStream.ParsedBitrate()
+			metadata.Opus.FrameSize = 960 * e.options.Channels
+			metadata.Opus.Application = ""
 		}
-
-		cmdBuf.Reset()
-		pngBuf.Reset()
 	} else {
 		metadata.Origin = &OriginMetadata{
 			Source:   "pipe",
@@ -406,11 +1012,20 @@ func (e *EncodeSession) writeMetadataFrame() {
 		}
 	}
 
+	if e.options.SongInfo != nil {
+		metadata.SongInfo.merge(e.options.SongInfo)
+	}
+	if e.options.Origin != nil {
+		metadata.Origin.merge(e.options.Origin)
+	}
+
+	e.metadata = &metadata
+
 	// Write the magic header
 	jsonData, err := json.Marshal(metadata)
 	if err != nil {
-		logln("JSon error:", err)
-		return
+		e.options.logger().Error("Error encoding metadata", "err", err)
+		return nil
 	}
 	var buf bytes.Buffer
 	buf.Write([]byte(fmt.Sprintf("DCA%d", FormatVersion)))
@@ -419,12 +1034,12 @@ func (e *EncodeSession) writeMetadataFrame() {
 	jsonLen := int32(len(jsonData))
 	err = binary.Write(&buf, binary.LittleEndian, &jsonLen)
 	if err != nil {
-		logln("Couldn't write json len:", err)
-		return
+		e.options.logger().Error("Error writing metadata length", "err", err)
+		return nil
 	}
 
 	buf.Write(jsonData)
-	e.frameChannel <- &Frame{buf.Bytes(), true}
+	return &Frame{buf.Bytes(), true}
 }
 
 func (e *EncodeSession) readStderr(stderr io.ReadCloser, wg *sync.WaitGroup) {
@@ -436,7 +1051,7 @@ func (e *EncodeSession) readStderr(stderr io.ReadCloser, wg *sync.WaitGroup) {
 		r, _, err := bufReader.ReadRune()
 		if err != nil {
 			if err != io.EOF {
-				logln("Error Reading stderr:", err)
+				e.options.logger().Error("Error reading ffmpeg stderr", "err", err)
 			}
 			break
 		}
@@ -453,6 +1068,10 @@ func (e *EncodeSession) readStderr(stderr io.ReadCloser, wg *sync.WaitGroup) {
 			// Message
 			e.Lock()
 			e.ffmpegOutput += outBuf.String() + "\n"
+			e.stderrTail = append(e.stderrTail, outBuf.String())
+			if len(e.stderrTail) > MaxStderrLines {
+				e.stderrTail = e.stderrTail[len(e.stderrTail)-MaxStderrLines:]
+			}
 			e.Unlock()
 			outBuf.Reset()
 		default:
@@ -462,129 +1081,281 @@ func (e *EncodeSession) readStderr(stderr io.ReadCloser, wg *sync.WaitGroup) {
 }
 
 func (e *EncodeSession) handleStderrLine(line string) {
-	if strings.Index(line, "size=") != 0 {
-		return // Not stats info
-	}
-
-	var size int
-
-	var timeH int
-	var timeM int
-	var timeS float32
-
-	var bitrate float32
-	var speed float32
+	e.Lock()
+	prev := e.lastStats
+	e.Unlock()
 
-	_, err := fmt.Sscanf(line, "size=%dkB time=%d:%d:%f bitrate=%fkbits/s speed=%fx", &size, &timeH, &timeM, &timeS, &bitrate, &speed)
-	if err != nil {
-		logln("Error parsing ffmpeg stats:", err)
+	stats, ok := parseFFmpegStats(line, prev)
+	if !ok {
+		return // Not stats info
 	}
 
-	dur := time.Duration(timeH) * time.Hour
-	dur += time.Duration(timeM) * time.Minute
-	dur += time.Duration(timeS) * time.Second
-
-	stats := &EncodeStats{
-		Size:     size,
-		Duration: dur,
-		Bitrate:  bitrate,
-		Speed:    speed,
-	}
+	e.updateStats(stats)
+}
 
+// updateStats sets the latest stats and sends them to the stats channel
+func (e *EncodeSession) updateStats(stats *EncodeStats) {
 	e.Lock()
+	stats.setProgress(e.sourceDuration, time.Duration(e.options.StartTime)*time.Second)
+	stats.OutputBytes = e.outputBytes
+	e.bitrateHistory = appendHistory(e.bitrateHistory, stats.Bitrate)
+	e.speedHistory = appendHistory(e.speedHistory, stats.Speed)
+	stats.BitrateHistory = e.bitrateHistory
+	stats.SpeedHistory = e.speedHistory
 	e.lastStats = stats
 	e.Unlock()
+
+	// Only keep the latest stats in the channel
+	select {
+	case <-e.statsChan:
+	default:
+	}
+	update := *stats
+	e.statsChan <- &update
 }
 
 func (e *EncodeSession) readStdout(stdout io.ReadCloser) {
-	decoder := ogg.NewPacketDecoder(ogg.NewDecoder(stdout))
+	reader, err := NewOggOpusReader(stdout)
+	if err != nil {
+		if err != io.EOF {
+			e.options.logger().Error("Error reading ffmpeg stdout", "err", err)
+		}
+		// Don't leave ffmpeg blocked on writing
+		io.Copy(ioutil.Discard, stdout)
+		return
+	}
 
-	// the first 2 packets are ogg opus metadata
-	skipPackets := 2
 	for {
 		// Retrieve a packet
-		packet, _, err := decoder.Decode()
-		if skipPackets > 0 {
-			skipPackets--
-			continue
-		}
+		packet, err := reader.OpusFrame()
 		if err != nil {
 			if err != io.EOF {
-				logln("Error reading ffmpeg stdout:", err)
+				e.options.logger().Error("Error reading ffmpeg stdout", "err", err)
 			}
 			break
 		}
 
 		err = e.writeOpusFrame(packet)
 		if err != nil {
-			logln("Error writing opus frame:", err)
+			e.options.logger().Error("Error writing opus frame", "err", err)
 			break
 		}
 	}
 }
 
 func (e *EncodeSession) writeOpusFrame(opusFrame []byte) error {
-	var dcaBuf bytes.Buffer
-
-	err := binary.Write(&dcaBuf, binary.LittleEndian, int16(len(opusFrame)))
-	if err != nil {
-		return err
+	if len(opusFrame) > math.MaxInt16 {
+		return ErrBadFrame
 	}
 
-	_, err = dcaBuf.Write(opusFrame)
-	if err != nil {
-		return err
-	}
+	f := framePool.Get().(*Frame)
+	f.metaData = false
+	f.data = append(f.data[:0], 0, 0)
+	binary.LittleEndian.PutUint16(f.data, uint16(len(opusFrame)))
+	f.data = append(f.data, opusFrame...)
 
-	e.frameChannel <- &Frame{dcaBuf.Bytes(), false}
+	if e.spool != nil {
+		err := e.spool.push(f)
+		if err != nil {
+			return err
+		}
+	} else {
+		e.frameChannel <- f
+	}
 
 	e.Lock()
 	e.lastFrame++
+	e.outputBytes += int64(len(opusFrame))
 	e.Unlock()
 
+	e.options.metrics().FrameEncoded()
 	return nil
 }
 
-// Stop stops the encoding session
+// restartAt starts a new session encoding the same file with the start time set to pos,
+// returns ErrSourceNotSeekable for sessions encoding from a reader
+func (e *EncodeSession) restartAt(pos time.Duration) (*EncodeSession, error) {
+	return e.restartWith(pos, e.options)
+}
+
+// restartWith is the same as restartAt, but with different options
+func (e *EncodeSession) restartWith(pos time.Duration, options *EncodeOptions) (*EncodeSession, error) {
+	if e.filePath == "" || e.native {
+		return nil, ErrSourceNotSeekable
+	}
+
+	newOptions := *options
+	newOptions.StartTime = int(pos / time.Second)
+	return EncodeFile(e.filePath, &newOptions)
+}
+
+// Stop stops the encoding session, ffmpeg is asked to exit (SIGTERM, or CTRL_BREAK on windows)
+// and killed if it hasn't done so within StopGracePeriod
 func (e *EncodeSession) Stop() error {
 	e.Lock()
 	defer e.Unlock()
+
+	// In case ffmpeg hasn't been started yet, it's killed right after it is
+	e.setStopped()
+	if e.running && e.native {
+		return nil
+	}
+
 	if !e.running || e.process == nil {
 		return errors.New("Not running")
 	}
 
-	err := e.process.Kill()
-	return err
+	if e.options.StopGracePeriod <= 0 {
+		return e.process.Kill()
+	}
+
+	err := terminateProcess(e.process)
+	if err != nil {
+		return e.process.Kill()
+	}
+
+	process := e.process
+	go func() {
+		select {
+		case <-e.done:
+		case <-time.After(e.options.StopGracePeriod):
+			process.Kill()
+		}
+	}()
+
+	return nil
 }
 
-// ReadFrame blocks until a frame is read or there are no more frames
+// ReadFrame blocks until a frame is read or there are no more frames,
+// if the encoding failed the error is returned in place of io.EOF
 // Note: If rawoutput is not set, the first frame will be a metadata frame
+//
+// The read methods (ReadFrame, OpusFrame, Read etc) are safe to call from multiple goroutines,
+// calls are serialized and every frame is returned to only one of them, in the order they got to read.
+// To give multiple consumers all the frames use Subscribe instead.
 func (e *EncodeSession) ReadFrame() (frame []byte, err error) {
-	f := <-e.frameChannel
+	e.readMu.Lock()
+	defer e.readMu.Unlock()
+
+	f := e.nextFrame()
 	if f == nil {
-		return nil, io.EOF
+		return nil, e.endErr()
 	}
 
 	return f.data, nil
 }
 
-// OpusFrame implements OpusReader, returning the next opus frame
-func (e *EncodeSession) OpusFrame() (frame []byte, err error) {
-	f := <-e.frameChannel
+// ReadFrameContext is the same as ReadFrame but gives up when ctx is done, returning ctx.Err()
+func (e *EncodeSession) ReadFrameContext(ctx context.Context) (frame []byte, err error) {
+	e.readMu.Lock()
+	defer e.readMu.Unlock()
+
+	f, ok := e.pendingFrame()
+	if !ok {
+		select {
+		case f = <-e.frameChannel:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
 	if f == nil {
-		return nil, io.EOF
+		return nil, e.endErr()
 	}
+	return f.data, nil
+}
+
+// TryReadFrame is the same as ReadFrame but doesn't wait, ErrNoFrameReady is returned if the next frame hasn't been encoded yet
+func (e *EncodeSession) TryReadFrame() (frame []byte, err error) {
+	e.readMu.Lock()
+	defer e.readMu.Unlock()
 
-	if f.metaData {
-		// Return the next one then...
-		return e.OpusFrame()
+	f, ok := e.pendingFrame()
+	if !ok {
+		select {
+		case f = <-e.frameChannel:
+		default:
+			return nil, ErrNoFrameReady
+		}
 	}
 
-	if len(f.data) < 2 {
-		return nil, ErrBadFrame
+	if f == nil {
+		return nil, e.endErr()
+	}
+	return f.data, nil
+}
+
+// ReadFrameInto is the same as ReadFrame but copies the frame into buf instead of allocating a new one,
+// if buf is too small io.ErrShortBuffer is returned and the frame is kept for the next read
+func (e *EncodeSession) ReadFrameInto(buf []byte) (n int, err error) {
+	e.readMu.Lock()
+	defer e.readMu.Unlock()
+
+	f := e.nextFrame()
+	if f == nil {
+		return 0, e.endErr()
+	}
+
+	if len(buf) < len(f.data) {
+		e.pending = f
+		return 0, io.ErrShortBuffer
+	}
+
+	n = copy(buf, f.data)
+	putFrame(f)
+	return n, nil
+}
+
+// nextFrame returns the next frame, or nil if there are no more frames. e.readMu has to be locked
+func (e *EncodeSession) nextFrame() *Frame {
+	if f, ok := e.pendingFrame(); ok {
+		return f
+	}
+
+	return <-e.frameChannel
+}
+
+// pendingFrame returns the frame kept by ReadFrameInto if any, e.readMu has to be locked
+func (e *EncodeSession) pendingFrame() (*Frame, bool) {
+	if e.pending == nil {
+		return nil, false
+	}
+
+	f := e.pending
+	e.pending = nil
+	return f, true
+}
+
+// OpusFrame implements OpusReader, returning the next opus frame
+func (e *EncodeSession) OpusFrame() (frame []byte, err error) {
+	e.readMu.Lock()
+	defer e.readMu.Unlock()
+
+	for {
+		f := e.nextFrame()
+		if f == nil {
+			return nil, e.endErr()
+		}
+
+		if f.metaData {
+			// Return the next one then...
+			continue
+		}
+
+		if len(f.data) < 2 {
+			return nil, ErrBadFrame
+		}
+
+		return f.data[2:], nil
 	}
+}
 
-	return f.data[2:], nil
+// endErr returns the error to return once there are no more frames
+func (e *EncodeSession) endErr() error {
+	if err := e.Error(); err != nil {
+		return err
+	}
+	return io.EOF
 }
 
 // Running returns true if running
@@ -604,50 +1375,160 @@ func (e *EncodeSession) Stats() *EncodeStats {
 	if e.lastStats != nil {
 		*s = *e.lastStats
 	}
+	s.OutputBytes = e.outputBytes
 	e.Unlock()
 
 	return s
 }
 
+// FFmpegArgs returns the arguments ffmpeg was started with (excluding the executable),
+// nil if it hasn't been started yet or the session isn't using ffmpeg. See also BuildArgs
+func (e *EncodeSession) FFmpegArgs() []string {
+	e.Lock()
+	defer e.Unlock()
+
+	if e.args == nil {
+		return nil
+	}
+	return append([]string(nil), e.args...)
+}
+
+// SourceDuration returns the duration of the input as reported by ffprobe, 0 if unknown
+func (e *EncodeSession) SourceDuration() time.Duration {
+	e.Lock()
+	defer e.Unlock()
+	return e.sourceDuration
+}
+
+// StatsUpdates returns a channel that receives the latest stats every time ffmpeg reports them,
+// if they're not read before the next update the older ones are dropped.
+// The channel is closed when the encoding finishes
+func (e *EncodeSession) StatsUpdates() <-chan *EncodeStats {
+	return e.statsChan
+}
+
+// setMetadataReady marks the metadata as ready (or not available)
+func (e *EncodeSession) setMetadataReady() {
+	e.metadataReadyClose.Do(func() {
+		close(e.metadataReady)
+	})
+}
+
+// MetadataReady returns a channel that's closed when Metadata is ready
+func (e *EncodeSession) MetadataReady() <-chan struct{} {
+	return e.metadataReady
+}
+
+// Metadata blocks until the metadata has been retrieved (by ffprobe) and returns it,
+// nil is returned if there's no metadata, which is the case with RawOutput or if ffprobe failed
+func (e *EncodeSession) Metadata() *Metadata {
+	<-e.metadataReady
+	e.Lock()
+	defer e.Unlock()
+	return e.metadata
+}
+
+// Done returns a channel that's closed when the encoding has finished
+func (e *EncodeSession) Done() <-chan struct{} {
+	return e.done
+}
+
+// Wait blocks until the encoding has finished and returns the error (if any), same as Error()
+// Note: frames still has to be read for the encoding to finish
+func (e *EncodeSession) Wait() error {
+	<-e.done
+	return e.Error()
+}
+
 // Options returns the options used
 func (e *EncodeSession) Options() *EncodeOptions {
 	return e.options
 }
 
+// setStopped marks the session as stopped, e has to be locked
+func (e *EncodeSession) setStopped() {
+	if !e.stopped {
+		e.stopped = true
+		close(e.stop)
+	}
+}
+
+// kill kills ffmpeg right away, or stops native encoding
+func (e *EncodeSession) kill() {
+	e.Lock()
+	e.setStopped()
+	if e.process != nil {
+		e.process.Kill()
+	}
+	e.Unlock()
+}
+
 // Truncate is deprecated, use Cleanup instead
 // this will be removed in a future version
-func (e *EncodeSession) Truncate() {
-	e.Cleanup()
+func (e *EncodeSession) Truncate() error {
+	return e.Cleanup()
 }
 
 // Cleanup cleans up the encoding session, throwring away all unread frames and stopping ffmpeg
 // ensuring that no ffmpeg processes starts piling up on your system
 // You should always call this after it's done
-func (e *EncodeSession) Cleanup() {
+//
+// It blocks until ffmpeg has exited, killing it again if that hasn't happened within CleanupTimeout,
+// and returns any error that occured during encoding, or ErrCleanupTimeout if ffmpeg still didn't exit.
+func (e *EncodeSession) Cleanup() error {
 	e.Stop()
 
-	for _ = range e.frameChannel {
-		// empty till closed
-		// Cats can be right-pawed or left-pawed.
+	// Throw away the frames so ffmpeg isn't blocked writing them, the channel is closed once it has exited
+	go func() {
+		for f := range e.frameChannel {
+			// empty till closed
+			// Cats can be right-pawed or left-pawed.
+			putFrame(f)
+		}
+	}()
+
+	select {
+	case <-e.done:
+		return e.Error()
+	case <-time.After(CleanupTimeout):
+	}
+
+	e.kill()
+
+	select {
+	case <-e.done:
+		return e.Error()
+	case <-time.After(CleanupTimeout):
+		return ErrCleanupTimeout
 	}
 }
 
 // Read implements io.Reader,
 // n == len(p) if err == nil, otherwise n contains the number bytes read before an error occured
 func (e *EncodeSession) Read(p []byte) (n int, err error) {
+	e.readMu.Lock()
+	defer e.readMu.Unlock()
+
 	if e.buf.Len() >= len(p) {
 		return e.buf.Read(p)
 	}
 
+	var frameErr error
 	for e.buf.Len() < len(p) {
-		f, err := e.ReadFrame()
-		if err != nil {
+		f := e.nextFrame()
+		if f == nil {
+			frameErr = e.endErr()
 			break
 		}
-		e.buf.Write(f)
+		e.buf.Write(f.data)
+		putFrame(f)
 	}
 
-	return e.buf.Read(p)
+	n, err = e.buf.Read(p)
+	if n == 0 && frameErr != nil {
+		err = frameErr
+	}
+	return
 }
 
 // FrameDuration implements OpusReader, retruning the duratio of each frame
@@ -655,7 +1536,9 @@ func (e *EncodeSession) FrameDuration() time.Duration {
 	return time.Duration(e.options.FrameDuration) * time.Millisecond
 }
 
-// Error returns any error that occured during the encoding process
+// Error returns any error that occured during the encoding process,
+// if ffmpeg exited with an error this includes the last lines it printed.
+// nil means it finished cleanly (or was stopped)
 func (e *EncodeSession) Error() error {
 	e.Lock()
 	defer e.Unlock()
@@ -669,3 +1552,12 @@ func (e *EncodeSession) FFMPEGMessages() string {
 	e.Unlock()
 	return output
 }
+
+// FFMPEGLastMessages returns the last MaxStderrLines lines printed by ffmpeg to stderr
+func (e *EncodeSession) FFMPEGLastMessages() []string {
+	e.Lock()
+	lines := make([]string, len(e.stderrTail))
+	copy(lines, e.stderrTail)
+	e.Unlock()
+	return lines
+}
@@ -1,27 +1,389 @@
 package dca
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strings"
+	"syscall"
 	"testing"
+	"time"
 )
 
-func TestEncode(t *testing.T) {
-	session, err := EncodeFile("testaudio.ogg", StdEncodeOptions)
+func TestParseFFmpegVersion(t *testing.T) {
+	cases := []struct {
+		output       string
+		major, minor int
+		libopus      bool
+	}{
+		{"ffmpeg version 4.4.2-0ubuntu0.22.04.1 Copyright (c) 2000-2021\nconfiguration: --enable-libopus --enable-libmp3lame", 4, 4, true},
+		{"ffmpeg version n6.0 Copyright (c) 2000-2023\nconfiguration: --enable-gpl", 6, 0, false},
+		{"ffmpeg version 3.4-1ubuntu1 Copyright", 3, 4, false},
+		{"ffmpeg version N-109421-g9adf02247c Copyright\nconfiguration: --enable-libopus", 0, 0, true},
+	}
+
+	for _, c := range cases {
+		info := parseFFmpegVersion(c.output)
+		if info.Major != c.major || info.Minor != c.minor || info.Libopus != c.libopus {
+			t.Errorf("Incorrect info for %q, got %+v", c.output, info)
+		}
+	}
+
+	if !(&FFmpegInfo{Major: 4, Minor: 4}).AtLeast(4, 3) || (&FFmpegInfo{Major: 4, Minor: 2}).AtLeast(4, 3) {
+		t.Error("Incorrect version comparison")
+	}
+}
+
+func TestDownmixPanFilter(t *testing.T) {
+	filter := DefaultDownmix.panFilter("5.1")
+	expected := "pan=stereo|FL=FL+0.707*FC+0.707*BL|FR=FR+0.707*FC+0.707*BR"
+	if filter != expected {
+		t.Errorf("Incorrect filter, got %q expected %q", filter, expected)
+	}
+
+	if filter := DefaultDownmix.panFilter("unknown"); filter != "" {
+		t.Errorf("Expected no filter for an unknown layout, got %q", filter)
+	}
+}
+
+func TestParseFFmpegStats(t *testing.T) {
+	prev := &EncodeStats{Size: 100, Duration: 5 * time.Second, Bitrate: 128, Speed: 10}
+	cases := []struct {
+		line     string
+		ok       bool
+		expected EncodeStats
+	}{
+		{"size=     237kB time=00:00:15.10 bitrate= 128.6kbits/s speed=50.2x", true, EncodeStats{Size: 237, Duration: 15100 * time.Millisecond, Bitrate: 128.6, Speed: 50.2}},
+		{"size=     237KiB time=00:01:00.00 bitrate= 128.6kbits/s speed=50.2x elapsed=0:00:01.20", true, EncodeStats{Size: 237, Duration: time.Minute, Bitrate: 128.6, Speed: 50.2}},
+		{"size=N/A time=N/A bitrate=N/A speed=N/A", true, *prev},
+		{"size=       0kB time=-577014:32:22.77 bitrate=  -0.0kbits/s speed=N/A", true, EncodeStats{Size: 0, Duration: 5 * time.Second, Bitrate: 0, Speed: 10}},
+		{"Input #0, mp3, from 'song.mp3':", false, EncodeStats{}},
+	}
+
+	for _, c := range cases {
+		stats, ok := parseFFmpegStats(c.line, prev)
+		if ok != c.ok {
+			t.Errorf("Incorrect ok for %q, got %v", c.line, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if stats.Size != c.expected.Size || stats.Duration != c.expected.Duration || stats.Bitrate != c.expected.Bitrate || stats.Speed != c.expected.Speed {
+			t.Errorf("Incorrect stats for %q, got %+v", c.line, stats)
+		}
+	}
+}
+
+func TestAudioFilterVolume(t *testing.T) {
+	cases := []struct {
+		options  EncodeOptions
+		expected string
+	}{
+		{EncodeOptions{}, ""},
+		{EncodeOptions{Volume: 256}, ""},
+		{EncodeOptions{Volume: 128}, "volume=0.5"},
+		{EncodeOptions{Volume: 128, Gain: 2}, "volume=2"},
+	}
+
+	for _, c := range cases {
+		if filter := c.options.audioFilter(); filter != c.expected {
+			t.Errorf("Incorrect filter for volume %d and gain %v, got %q expected %q", c.options.Volume, c.options.Gain, filter, c.expected)
+		}
+	}
+}
+
+func TestStatsProgress(t *testing.T) {
+	stats := &EncodeStats{Duration: 30 * time.Second, Speed: 10}
+	stats.setProgress(100*time.Second, 40*time.Second)
+	if stats.Progress != 50 || stats.ETA != 3*time.Second {
+		t.Errorf("Incorrect progress from StartTime (got %v%%, ETA %v expected 50%%, 3s)", stats.Progress, stats.ETA)
+	}
+
+	stats = &EncodeStats{Duration: 30 * time.Second, Speed: 10}
+	stats.setProgress(100*time.Second, 200*time.Second)
+	if stats.Progress != 100 || stats.ETA != 0 {
+		t.Errorf("Incorrect progress when starting past the end (got %v%%, ETA %v)", stats.Progress, stats.ETA)
+	}
+}
+
+func TestSubscribe(t *testing.T) {
+	session := newEncodeSession(StdEncodeOptions)
+
+	a, b := session.Subscribe(), session.Subscribe()
+
+	go func() {
+		session.frameChannel <- &Frame{data: []byte{0, 0}, metaData: true}
+		for i := 0; i < 10; i++ {
+			session.frameChannel <- &Frame{data: []byte{1, 0, byte(i)}}
+		}
+		close(session.frameChannel)
+		close(session.done)
+	}()
+
+	for _, s := range []*Subscription{a, b} {
+		for i := 0; i < 10; i++ {
+			frame, err := s.OpusFrame()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(frame) != 1 || frame[0] != byte(i) {
+				t.Fatalf("Expected frame %d, got %v", i, frame)
+			}
+		}
+
+		if _, err := s.OpusFrame(); err != io.EOF {
+			t.Errorf("Expected io.EOF, got %v", err)
+		}
+	}
+}
+
+func TestBuildArgs(t *testing.T) {
+	options := *StdEncodeOptions
+	options.Gain = 0.5
+	options.FEC = true
+
+	args, err := BuildArgs("song.mp3", &options)
 	if err != nil {
-		t.Fatal("Failed creating encoding session", err)
+		t.Fatal(err)
+	}
+
+	joined := strings.Join(args, " ")
+	for _, expected := range []string{"-i song.mp3", "-b:a 64000", "-fec 1", "-af volume=0.5", "pipe:1"} {
+		if !strings.Contains(joined, expected) {
+			t.Errorf("Expected %q in the arguments: %s", expected, joined)
+		}
+	}
+
+	options.FrameDuration = 25
+	if _, err = BuildArgs("song.mp3", &options); err == nil {
+		t.Error("Expected invalid options to return an error")
+	}
+
+	options.FrameDuration = 20
+	for _, headers := range []map[string]string{
+		{"User-Agent": "dca\r\nCookie: injected"},
+		{"X-Evil\nCookie": "injected"},
+		{"Cookie: a": "b"},
+	} {
+		options.Headers = headers
+		if _, err = BuildArgs("https://example.com/song.mp3", &options); !errors.Is(err, ErrInvalidOptions) {
+			t.Errorf("Expected ErrInvalidOptions for headers %q, got %v", headers, err)
+		}
 	}
+}
+
+// fakeRunner runs this test binary as ffmpeg and ffprobe, see TestFakeFFmpeg
+type fakeRunner struct{}
 
-	numFrames := 0
+func (fakeRunner) LookPath(file string) (string, error) {
+	return file, nil
+}
+
+func (fakeRunner) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, os.Args[0], append([]string{"-test.run=^TestFakeFFmpeg$", "--", name}, args...)...)
+	cmd.Env = append(os.Environ(), "DCA_FAKE_FFMPEG=1")
+	return cmd
+}
+
+// TestFakeFFmpeg isn't a real test, it's the fake ffmpeg and ffprobe started by fakeRunner.
+// Inputs named broken.* fail, everything else is encoded to testaudio.dca.
+func TestFakeFFmpeg(t *testing.T) {
+	if os.Getenv("DCA_FAKE_FFMPEG") != "1" {
+		return
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	name, args := args[1], args[2:]
+	joined := strings.Join(args, " ")
+
+	switch {
+	case name == "ffprobe" && strings.Contains(joined, ".webm"):
+		fmt.Print(`{"format":{"duration":"15.100000"},"streams":[{"codec_type":"audio","codec_name":"opus","channels":2,"sample_rate":"48000"}]}`)
+	case name == "ffprobe" && strings.Contains(joined, "mono."):
+		fmt.Print(`{"format":{"duration":"15.100000"},"streams":[{"codec_type":"audio","codec_name":"mp3","channels":1,"sample_rate":"44100"}]}`)
+	case name == "ffprobe":
+		fmt.Print(`{"format":{"duration":"15.100000"},"streams":[{"codec_type":"audio","codec_name":"mp3","channels":2,"sample_rate":"44100"}]}`)
+	case strings.Contains(joined, "-version"):
+		fmt.Println("ffmpeg version 6.0 Copyright (c) 2000-2023 the FFmpeg developers")
+		fmt.Println("configuration: --enable-gpl --enable-libopus")
+	case strings.Contains(joined, "-i hang."):
+		// Ignores being asked to exit, has to be killed
+		signal.Ignore(os.Interrupt, syscall.SIGTERM)
+		time.Sleep(time.Minute)
+	case strings.Contains(joined, "-i broken."):
+		fmt.Fprint(os.Stderr, "broken.mp3: Invalid data found when processing input\n")
+		os.Exit(1)
+	default:
+		file, err := os.Open("testaudio.dca")
+		if err != nil {
+			os.Exit(2)
+		}
+		fmt.Fprint(os.Stderr, "size=     237kB time=00:00:15.10 bitrate= 128.6kbits/s speed=50.2x\r")
+		WriteOggOpus(os.Stdout, file)
+		fmt.Fprint(os.Stderr, "\n")
+	}
+	os.Exit(0)
+}
+
+func TestEncodeFakeFFmpeg(t *testing.T) {
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+
+	session, err := EncodeFile("song.mp3", &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Cleanup()
+
+	metadata, err := session.ReadFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(metadata[:4]) != "DCA1" {
+		t.Errorf("Expected a metadata frame first, got %q", metadata[:4])
+	}
+
+	frames := 0
 	for {
-		_, err := session.ReadFrame()
+		_, err := session.OpusFrame()
 		if err != nil {
+			if err != io.EOF {
+				t.Fatal(err)
+			}
 			break
 		}
-		numFrames++
+		frames++
+	}
+	if frames != 755 {
+		t.Errorf("Incorrect number of frames (got %d expected %d)", frames, 755)
+	}
+
+	stats := session.Stats()
+	if stats.Size != 237 || stats.Speed != 50.2 || stats.Duration != 15100*time.Millisecond {
+		t.Errorf("Incorrectly parsed stats: %+v", stats)
+	}
+	if stats.OutputBytes != 241600 || len(stats.SpeedHistory) == 0 {
+		t.Errorf("Incorrect extended stats: %+v", stats)
+	}
+
+	if args := strings.Join(session.FFmpegArgs(), " "); !strings.Contains(args, "-i song.mp3") {
+		t.Errorf("Expected the input in the arguments: %s", args)
+	}
+}
+
+func TestEncodeFakeFFmpegError(t *testing.T) {
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+	options.RawOutput = true
+
+	session, err := EncodeFile("broken.mp3", &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = session.OpusFrame()
+	exited, ok := err.(*ErrFFmpegExited)
+	if !ok {
+		t.Fatalf("Expected ErrFFmpegExited, got %v", err)
+	}
+	if exited.Code != 1 || !strings.Contains(exited.Stderr, "Invalid data found") {
+		t.Errorf("Unexpected error: %v", exited)
+	}
+}
+
+func TestCleanupKillsHungFFmpeg(t *testing.T) {
+	defer func(timeout time.Duration) { CleanupTimeout = timeout }(CleanupTimeout)
+	CleanupTimeout = 200 * time.Millisecond
+
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+	options.RawOutput = true
+	options.StopGracePeriod = time.Minute
+
+	session, err := EncodeFile("hang.mp3", &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Wait for it to start, so Stop only asks it to exit
+	for i := 0; i < 100 && !session.Running(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	started := time.Now()
+	session.Cleanup()
+	if elapsed := time.Since(started); elapsed > 10*CleanupTimeout {
+		t.Errorf("Cleanup took %v, expected ffmpeg to be killed after CleanupTimeout", elapsed)
+	}
+
+	select {
+	case <-session.done:
+	default:
+		t.Error("Expected the session to be done after Cleanup")
+	}
+}
+
+func TestCopyOpusFrameDuration(t *testing.T) {
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+	options.CopyOpus = true
+
+	for _, frameDuration := range []int{20, 40} {
+		options.FrameDuration = frameDuration
+		result, err := DryRun(context.Background(), "song.webm", &options)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The copied packets are whatever the source has, dca assumes 20ms
+		if result.CopyOpus != (frameDuration == 20) {
+			t.Errorf("Incorrect copy mode for %dms frames, got %t", frameDuration, result.CopyOpus)
+		}
+	}
+}
+
+func TestEncodeFileSegmented(t *testing.T) {
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+	options.AutoChannels = true
+
+	var buf bytes.Buffer
+	err := EncodeFileSegmented(&buf, "mono.mp3", &options, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(&buf)
+	err = decoder.ReadMetadata()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if channels := decoder.Metadata.Opus.Channels; channels != 1 {
+		t.Errorf("Expected the metadata to have the resolved number of channels, got %d", channels)
+	}
+
+	// The fake ffmpeg ignores -ss and -t, so every segment is the whole file
+	frames := 0
+	err = decoder.ForEachFrame(func(frame []byte) bool {
+		frames++
+		return true
+	})
+	if err != nil || frames != 755*2 {
+		t.Errorf("Incorrect number of frames (got %d expected %d): %v", frames, 755*2, err)
 	}
 
-	// Predermined, probably gonna change the testing method somehow
-	if numFrames != 756 {
-		t.Errorf("Incorrect number of frames (got %d expected %d)", numFrames, 756)
-		t.Fail()
+	err = EncodeFileSegmented(&buf, "broken.mp3", &options, 2)
+	if _, ok := err.(*ErrFFmpegExited); !ok {
+		t.Errorf("Expected ErrFFmpegExited, got %v", err)
 	}
 }
@@ -0,0 +1,4 @@
+package dca
+
+// FakeRunner runs a fake ffmpeg, for the tests in dca_test
+var FakeRunner CommandRunner = fakeRunner{}
@@ -0,0 +1,98 @@
+package dca
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsHistoryLength is the number of past bitrate and speed values kept in EncodeStats
+var StatsHistoryLength = 60
+
+// statsFieldRegex matches the "key=value" fields of ffmpeg stats lines, values can be padded with spaces after the =
+var statsFieldRegex = regexp.MustCompile(`([a-z_]+)=\s*(\S+)`)
+
+// parseFFmpegStats parses a stats line printed by ffmpeg
+// (ex "size=     237kB time=00:00:15.10 bitrate= 128.6kbits/s speed=50.2x"),
+// fields that are missing, N/A or can't be parsed are kept as they are in prev.
+// ok is false if the line doesn't contain any stats.
+func parseFFmpegStats(line string, prev *EncodeStats) (stats *EncodeStats, ok bool) {
+	stats = &EncodeStats{}
+	if prev != nil {
+		stats.Size = prev.Size
+		stats.Duration = prev.Duration
+		stats.Bitrate = prev.Bitrate
+		stats.Speed = prev.Speed
+	}
+
+	for _, match := range statsFieldRegex.FindAllStringSubmatch(line, -1) {
+		key, value := match[1], match[2]
+		switch key {
+		case "size", "time", "bitrate", "speed":
+			ok = true
+		default:
+			continue
+		}
+
+		if value == "N/A" {
+			continue
+		}
+
+		switch key {
+		case "size":
+			// kB in older versions, KiB in newer
+			if size, err := strconv.Atoi(strings.TrimRight(value, "kKiB")); err == nil {
+				stats.Size = size
+			}
+		case "time":
+			if dur, ok := parseStatsTime(value); ok {
+				stats.Duration = dur
+			}
+		case "bitrate":
+			if bitrate, err := strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 32); err == nil {
+				stats.Bitrate = float32(bitrate)
+			}
+		case "speed":
+			if speed, err := strconv.ParseFloat(strings.TrimSuffix(value, "x"), 32); err == nil {
+				stats.Speed = float32(speed)
+			}
+		}
+	}
+
+	return stats, ok
+}
+
+// parseStatsTime parses a time in the HH:MM:SS.ss format, negative times (printed before the first packet) are not ok
+func parseStatsTime(value string) (time.Duration, bool) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 3 {
+		return 0, false
+	}
+
+	h, err := strconv.Atoi(parts[0])
+	if err != nil || h < 0 {
+		return 0, false
+	}
+	m, err := strconv.Atoi(parts[1])
+	if err != nil || m < 0 {
+		return 0, false
+	}
+	s, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil || s < 0 {
+		return 0, false
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)), true
+}
+
+// appendHistory returns a new slice with v added to the end of history, keeping at most StatsHistoryLength values
+func appendHistory(history []float32, v float32) []float32 {
+	h := make([]float32, 0, len(history)+1)
+	h = append(h, history...)
+	h = append(h, v)
+	if len(h) > StatsHistoryLength {
+		h = h[len(h)-StatsHistoryLength:]
+	}
+	return h
+}
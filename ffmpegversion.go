@@ -0,0 +1,131 @@
+package dca
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var (
+	ErrNoLibopus = errors.New("ffmpeg was built without libopus (--enable-libopus), it can't encode opus")
+)
+
+// FFmpegInfo is the version and capabilities of an ffmpeg executable, found by running ffmpeg -version
+type FFmpegInfo struct {
+	Version string // The version as reported by ffmpeg, ex "4.4.2-0ubuntu0.22.04.1" or "N-109421-g9adf02247c" for git builds
+	Major   int    // Major version, 0 if unknown (git builds)
+	Minor   int    // Minor version
+	Libopus bool   // Wether it was built with libopus
+}
+
+// AtLeast returns true if the version is at least major.minor, or unknown (git builds are assumed to be recent)
+func (f *FFmpegInfo) AtLeast(major, minor int) bool {
+	if f.Major == 0 {
+		return true
+	}
+	return f.Major > major || (f.Major == major && f.Minor >= minor)
+}
+
+var (
+	ffmpegInfoCache   = make(map[string]*FFmpegInfo)
+	ffmpegInfoCacheMu sync.Mutex
+)
+
+// FFmpegVersion runs ffmpegPath -version and returns the version and wether it has libopus.
+// The result is cached for every path.
+func FFmpegVersion(ffmpegPath string) (*FFmpegInfo, error) {
+	return ffmpegVersion(runner(), ffmpegPath)
+}
+
+// ffmpegVersion is FFmpegVersion with the runner to use, only the results of ExecRunner are cached
+func ffmpegVersion(runner CommandRunner, ffmpegPath string) (*FFmpegInfo, error) {
+	_, cache := runner.(ExecRunner)
+
+	ffmpegInfoCacheMu.Lock()
+	defer ffmpegInfoCacheMu.Unlock()
+
+	if info, ok := ffmpegInfoCache[ffmpegPath]; ok && cache {
+		return info, nil
+	}
+
+	if _, err := runner.LookPath(ffmpegPath); err != nil {
+		return nil, ErrFFmpegNotFound
+	}
+
+	var out bytes.Buffer
+	cmd := runner.CommandContext(context.Background(), ffmpegPath, "-version")
+	cmd.Stdout = &out
+	err := cmd.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	info := parseFFmpegVersion(out.String())
+	if cache {
+		ffmpegInfoCache[ffmpegPath] = info
+	}
+	return info, nil
+}
+
+// parseFFmpegVersion parses the output of ffmpeg -version
+func parseFFmpegVersion(output string) *FFmpegInfo {
+	info := &FFmpegInfo{
+		Libopus: strings.Contains(output, "--enable-libopus"),
+	}
+
+	const prefix = "ffmpeg version "
+	i := strings.Index(output, prefix)
+	if i == -1 {
+		return info
+	}
+
+	fields := strings.Fields(output[i+len(prefix):])
+	if len(fields) == 0 {
+		return info
+	}
+	info.Version = fields[0]
+
+	// Release builds from ffmpeg's git are prefixed with n (ex n6.0)
+	parts := strings.SplitN(strings.TrimPrefix(info.Version, "n"), ".", 3)
+	if len(parts) < 2 {
+		return info
+	}
+
+	major, ok := leadingInt(parts[0])
+	if !ok {
+		return info
+	}
+	// The minor version can be followed by distro suffixes (ex "4.4-1ubuntu1")
+	minor, _ := leadingInt(parts[1])
+
+	info.Major = major
+	info.Minor = minor
+	return info
+}
+
+// leadingInt parses the digits at the start of s
+func leadingInt(s string) (int, bool) {
+	i := 0
+	for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+		i++
+	}
+
+	n, err := strconv.Atoi(s[:i])
+	return n, err == nil
+}
+
+// ffmpegInfo returns the cached info of the ffmpeg used by the session, nil if unknown
+func (e *EncodeSession) ffmpegInfo() *FFmpegInfo {
+	ffmpegInfoCacheMu.Lock()
+	defer ffmpegInfoCacheMu.Unlock()
+	return ffmpegInfoCache[e.options.ffmpegPath()]
+}
+
+// ffmpegAtLeast returns true if the ffmpeg used by the session is at least major.minor, or unknown
+func (e *EncodeSession) ffmpegAtLeast(major, minor int) bool {
+	info := e.ffmpegInfo()
+	return info == nil || info.AtLeast(major, minor)
+}
@@ -0,0 +1,188 @@
+package dca
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"os"
+	"time"
+)
+
+// FileSource is a dca file indexed by frame and read with io.ReaderAt, so the same file can be played
+// by any number of streams at the same time with their own position (see Reader), without loading it into memory.
+// Only the offsets of the frames are kept in memory, the audio is read from the file as it's played.
+type FileSource struct {
+	r    io.ReaderAt
+	file *os.File // Set if opened with OpenFileSource
+
+	metadata      *Metadata
+	frameDuration time.Duration
+	crc           bool
+
+	// Offset of every frame (the size in front of it) and the end of the last one
+	offsets []int64
+}
+
+// OpenFileSource opens and indexes the dca file at path, Close closes it
+func OpenFileSource(path string) (*FileSource, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	source, err := NewFileSource(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	source.file = file
+	return source, nil
+}
+
+// NewFileSource indexes the dca data of size bytes in r, this requires reading through the frame headers once.
+// r is read from concurrently by the readers of the source.
+func NewFileSource(r io.ReaderAt, size int64) (*FileSource, error) {
+	decoder := NewDecoder(io.NewSectionReader(r, 0, size))
+	err := decoder.checkMetadata()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	for err == nil {
+		err = decoder.skipFrame()
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+
+	// skipFrame marks the frame before finding out there isn't one
+	offsets := append(decoder.frameOffsets[:decoder.frameNum], decoder.pos)
+
+	return &FileSource{
+		r:             r,
+		metadata:      decoder.Metadata,
+		frameDuration: decoder.FrameDuration(),
+		crc:           decoder.crc,
+		offsets:       offsets,
+	}, nil
+}
+
+// Reader returns a new reader of the source starting at the beginning.
+// Use one for every stream playing the file at the same time.
+func (f *FileSource) Reader() *FileSourceReader {
+	return &FileSourceReader{source: f}
+}
+
+// Frames returns the number of frames
+func (f *FileSource) Frames() int {
+	return len(f.offsets) - 1
+}
+
+// Duration returns the total duration
+func (f *FileSource) Duration() (time.Duration, error) {
+	return time.Duration(f.Frames()) * f.frameDuration, nil
+}
+
+// FrameDuration returns the duration of every frame
+func (f *FileSource) FrameDuration() time.Duration {
+	return f.frameDuration
+}
+
+// Metadata returns the metadata of the file, nil if it doesn't have any
+func (f *FileSource) Metadata() *Metadata {
+	return f.metadata
+}
+
+// Close closes the file if the source was opened with OpenFileSource, the readers can't be used after this
+func (f *FileSource) Close() error {
+	if f.file == nil {
+		return nil
+	}
+	return f.file.Close()
+}
+
+// readFrame reads frame n, buf is reused if it's large enough
+func (f *FileSource) readFrame(n int, buf []byte) ([]byte, []byte, error) {
+	start, end := f.offsets[n], f.offsets[n+1]
+	if cap(buf) < int(end-start) {
+		buf = make([]byte, end-start)
+	}
+	buf = buf[:end-start]
+
+	_, err := f.r.ReadAt(buf, start)
+	if err != nil {
+		return nil, buf, truncatedErr(err, io.EOF)
+	}
+
+	size := int(binary.LittleEndian.Uint16(buf))
+	frame := buf[2 : 2+size]
+	if f.crc && binary.LittleEndian.Uint32(buf[2+size:]) != crc32.ChecksumIEEE(frame) {
+		return nil, buf, ErrBadCRC
+	}
+
+	// Frames are handed out to the caller, so they can't share the buffer
+	return append([]byte(nil), frame...), buf, nil
+}
+
+// FileSourceReader is an OpusReader reading the frames of a FileSource, with its own position
+type FileSourceReader struct {
+	source *FileSource
+	buf    []byte
+	pos    int
+}
+
+// OpusFrame implements OpusReader
+func (r *FileSourceReader) OpusFrame() (frame []byte, err error) {
+	if r.pos >= r.source.Frames() {
+		return nil, io.EOF
+	}
+
+	frame, r.buf, err = r.source.readFrame(r.pos, r.buf)
+	r.pos++
+	return frame, err
+}
+
+// FrameDuration implements OpusReader
+func (r *FileSourceReader) FrameDuration() time.Duration {
+	return r.source.frameDuration
+}
+
+// Seek moves to the frame at pos (rounded down to a whole frame)
+func (r *FileSourceReader) Seek(pos time.Duration) error {
+	frame := int(pos / r.source.frameDuration)
+	if frame < 0 {
+		frame = 0
+	} else if frame > r.source.Frames() {
+		frame = r.source.Frames()
+	}
+
+	r.pos = frame
+	return nil
+}
+
+// Rewind moves back to the start
+func (r *FileSourceReader) Rewind() {
+	r.pos = 0
+}
+
+// Position returns the current position
+func (r *FileSourceReader) Position() time.Duration {
+	return time.Duration(r.pos) * r.source.frameDuration
+}
+
+// Duration returns the total duration
+func (r *FileSourceReader) Duration() (time.Duration, error) {
+	return r.source.Duration()
+}
+
+// Metadata returns the metadata of the file, nil if it doesn't have any
+func (r *FileSourceReader) Metadata() *Metadata {
+	return r.source.metadata
+}
@@ -0,0 +1,42 @@
+package dca
+
+import (
+	"io"
+)
+
+// ForEachFrame calls fn with every opus frame until the end of the audio or fn returns false,
+// the metadata is skipped. io.EOF isn't returned, other errors reading are.
+//
+// ffmpeg is stopped and the session cleaned up when done, including when stopping early,
+// so Cleanup doesn't need to be called.
+func (e *EncodeSession) ForEachFrame(fn func(frame []byte) bool) error {
+	err := forEachFrame(e, fn)
+	cleanupErr := e.Cleanup()
+	if err != nil {
+		return err
+	}
+	return cleanupErr
+}
+
+// ForEachFrame calls fn with every opus frame until the end of the file or fn returns false.
+// io.EOF isn't returned, other errors reading are.
+func (d *Decoder) ForEachFrame(fn func(frame []byte) bool) error {
+	return forEachFrame(d, fn)
+}
+
+// forEachFrame calls fn with the frames of source until io.EOF or fn returns false
+func forEachFrame(source OpusReader, fn func(frame []byte) bool) error {
+	for {
+		frame, err := source.OpusFrame()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if !fn(frame) {
+			return nil
+		}
+	}
+}
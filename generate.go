@@ -0,0 +1,98 @@
+package dca
+
+import (
+	"io"
+	"math"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// GeneratedSource is an OpusReader of generated audio, see SilenceSource and ToneSource
+type GeneratedSource struct {
+	frames   int // Total frames, 0 for no end
+	frameNum int
+
+	generate func(frameNum int) ([]byte, error)
+}
+
+// SilenceSource returns a source of duration (rounded up to whole 20ms frames) of silence,
+// a duration of 0 or less never ends. No encoding is involved, every frame is SilenceFrame.
+func SilenceSource(duration time.Duration) *GeneratedSource {
+	return newGeneratedSource(duration, func(int) ([]byte, error) {
+		return SilenceFrame, nil
+	})
+}
+
+// ToneSource returns a source of duration (rounded up to whole 20ms frames) of a sine wave at freq hz, at -6dBFS.
+// A duration of 0 or less never ends. The frames are encoded with libopus in 48khz stereo.
+func ToneSource(freq float64, duration time.Duration) *GeneratedSource {
+	var encoder *gopus.Encoder
+	pcm := make([]int16, mixerFrameSize*mixerChannels)
+
+	return newGeneratedSource(duration, func(frameNum int) ([]byte, error) {
+		if encoder == nil {
+			var err error
+			encoder, err = gopus.NewEncoder(mixerSampleRate, mixerChannels, gopus.Audio)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		start := frameNum * mixerFrameSize
+		for i := 0; i < mixerFrameSize; i++ {
+			t := float64(start+i) / mixerSampleRate
+			sample := int16(math.Sin(2*math.Pi*freq*t) * 16384)
+			pcm[i*2] = sample
+			pcm[i*2+1] = sample
+		}
+
+		return encoder.Encode(pcm, mixerFrameSize, len(pcm)*2)
+	})
+}
+
+func newGeneratedSource(duration time.Duration, generate func(frameNum int) ([]byte, error)) *GeneratedSource {
+	frames := 0
+	if duration > 0 {
+		frames = int((duration + mixerFrameDuration - 1) / mixerFrameDuration)
+	}
+
+	return &GeneratedSource{
+		frames:   frames,
+		generate: generate,
+	}
+}
+
+// OpusFrame implements OpusReader
+func (g *GeneratedSource) OpusFrame() ([]byte, error) {
+	if g.frames > 0 && g.frameNum >= g.frames {
+		return nil, io.EOF
+	}
+
+	frame, err := g.generate(g.frameNum)
+	if err != nil {
+		return nil, err
+	}
+
+	g.frameNum++
+	return frame, nil
+}
+
+// FrameDuration implements OpusReader
+func (g *GeneratedSource) FrameDuration() time.Duration {
+	return mixerFrameDuration
+}
+
+// Seek moves to pos (rounded down to a whole frame), see StreamingSession.Seek
+func (g *GeneratedSource) Seek(pos time.Duration) error {
+	g.frameNum = int(pos / mixerFrameDuration)
+	if g.frameNum < 0 {
+		g.frameNum = 0
+	}
+	return nil
+}
+
+// Duration returns the total duration, 0 if it never ends
+func (g *GeneratedSource) Duration() (time.Duration, error) {
+	return time.Duration(g.frames) * mixerFrameDuration, nil
+}
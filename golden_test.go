@@ -0,0 +1,42 @@
+package dca_test
+
+import (
+	"flag"
+	"testing"
+
+	"github.com/jonas747/dca"
+	"github.com/jonas747/dca/dcatest"
+)
+
+func init() {
+	flag.BoolVar(&dcatest.Update, "update", false, "update the golden files")
+}
+
+func TestEncodeGolden(t *testing.T) {
+	// The bitrate testaudio.dca was encoded with
+	options := *dca.StdEncodeOptions
+	options.Bitrate = 128
+
+	fake := options
+	fake.CommandRunner = dca.FakeRunner
+
+	backends := []dcatest.Backend{
+		dcatest.FFmpegBackend{Options: &options},
+		fakeBackend{dcatest.FFmpegBackend{Options: &fake}},
+	}
+
+	for _, backend := range backends {
+		t.Run(backend.Name(), func(t *testing.T) {
+			dcatest.RunGolden(t, backend, "testaudio.ogg", "testdata/golden/testaudio.json", dcatest.DefaultTolerance)
+		})
+	}
+}
+
+// fakeBackend is the ffmpeg backend running a fake ffmpeg
+type fakeBackend struct {
+	dcatest.FFmpegBackend
+}
+
+func (fakeBackend) Name() string {
+	return "fake"
+}
@@ -0,0 +1,45 @@
+package dca
+
+// Limiter limits how many encode sessions can run at the same time,
+// sessions over the limit are queued and start as soon as a slot frees up
+type Limiter struct {
+	slots chan struct{}
+}
+
+// DefaultLimiter is used by sessions that doesn't have EncodeOptions.Limiter set, nil for no limit
+var DefaultLimiter *Limiter
+
+// NewLimiter returns a new limiter allowing max sessions to run at the same time
+func NewLimiter(max int) *Limiter {
+	if max < 1 {
+		max = 1
+	}
+
+	return &Limiter{
+		slots: make(chan struct{}, max),
+	}
+}
+
+// acquire blocks until a slot is available, returns false if stop was closed before that
+func (l *Limiter) acquire(stop <-chan struct{}) bool {
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-stop:
+		return false
+	}
+}
+
+func (l *Limiter) release() {
+	<-l.slots
+}
+
+// Running returns the number of sessions currently running under this limiter
+func (l *Limiter) Running() int {
+	return len(l.slots)
+}
+
+// Max returns the max number of sessions that can run at the same time
+func (l *Limiter) Max() int {
+	return cap(l.slots)
+}
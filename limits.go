@@ -0,0 +1,18 @@
+package dca
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrLimitsNotSupported = errors.New("Resource limits aren't supported on this platform")
+)
+
+// ResourceLimits are limits applied to the ffmpeg process, so a broken or malicious input can't take down the host.
+// They're applied with prlimit on linux and a job object on windows, other platforms aren't supported.
+// The limits are applied right after ffmpeg is started, if that fails ffmpeg is killed.
+type ResourceLimits struct {
+	MaxMemory  uint64        // Max memory in bytes (address space on linux, committed memory on windows), 0 for no limit
+	MaxCPUTime time.Duration // Max cpu time used, ffmpeg is killed when it's exceeded, 0 for no limit
+}
@@ -0,0 +1,41 @@
+//go:build linux
+// +build linux
+
+package dca
+
+import (
+	"os"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// applyResourceLimits sets the rlimits of the process with prlimit
+func applyResourceLimits(p *os.Process, limits *ResourceLimits) error {
+	if limits.MaxMemory > 0 {
+		err := prlimit(p.Pid, syscall.RLIMIT_AS, limits.MaxMemory)
+		if err != nil {
+			return err
+		}
+	}
+
+	if limits.MaxCPUTime > 0 {
+		// In whole seconds, rounded up
+		seconds := uint64((limits.MaxCPUTime + time.Second - 1) / time.Second)
+		err := prlimit(p.Pid, syscall.RLIMIT_CPU, seconds)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func prlimit(pid int, resource int, limit uint64) error {
+	rlimit := syscall.Rlimit{Cur: limit, Max: limit}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
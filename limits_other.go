@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package dca
+
+import (
+	"os"
+)
+
+// applyResourceLimits isn't supported on this platform
+func applyResourceLimits(p *os.Process, limits *ResourceLimits) error {
+	return ErrLimitsNotSupported
+}
@@ -0,0 +1,83 @@
+//go:build windows
+// +build windows
+
+package dca
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	procCreateJobObjectW         = syscall.NewLazyDLL("kernel32.dll").NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = syscall.NewLazyDLL("kernel32.dll").NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = syscall.NewLazyDLL("kernel32.dll").NewProc("AssignProcessToJobObject")
+)
+
+const (
+	jobObjectExtendedLimitInformationClass = 9
+
+	jobObjectLimitProcessTime   = 0x00000002
+	jobObjectLimitProcessMemory = 0x00000100
+
+	processSetQuota  = 0x0100
+	processTerminate = 0x0001
+)
+
+// JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+type jobObjectExtendedLimitInformation struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+
+	IoInfo [6]uint64
+
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// applyResourceLimits puts the process in a job object with the limits
+func applyResourceLimits(p *os.Process, limits *ResourceLimits) error {
+	job, _, err := procCreateJobObjectW.Call(0, 0)
+	if job == 0 {
+		return err
+	}
+	// The job stays around as long as the process is in it
+	defer syscall.CloseHandle(syscall.Handle(job))
+
+	var info jobObjectExtendedLimitInformation
+	if limits.MaxMemory > 0 {
+		info.LimitFlags |= jobObjectLimitProcessMemory
+		info.ProcessMemoryLimit = uintptr(limits.MaxMemory)
+	}
+	if limits.MaxCPUTime > 0 {
+		info.LimitFlags |= jobObjectLimitProcessTime
+		info.PerProcessUserTimeLimit = int64(limits.MaxCPUTime / 100) // In 100ns units
+	}
+
+	r, _, err := procSetInformationJobObject.Call(job, jobObjectExtendedLimitInformationClass, uintptr(unsafe.Pointer(&info)), unsafe.Sizeof(info))
+	if r == 0 {
+		return err
+	}
+
+	process, err := syscall.OpenProcess(processSetQuota|processTerminate, false, uint32(p.Pid))
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(process)
+
+	r, _, err = procAssignProcessToJobObject.Call(job, uintptr(process))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
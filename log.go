@@ -0,0 +1,106 @@
+package dca
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Logger is used for the diagnostics of encodes, streams etc. The arguments after the message are key value pairs,
+// the same as log/slog, so a *slog.Logger can be used as is (with With to add context, for example the guild).
+type Logger interface {
+	Debug(msg string, args ...interface{})
+	Info(msg string, args ...interface{})
+	Warn(msg string, args ...interface{})
+	Error(msg string, args ...interface{})
+}
+
+// LogLevel is the severity of a log message
+type LogLevel int
+
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogDebug:
+		return "DEBUG"
+	case LogInfo:
+		return "INFO"
+	case LogWarn:
+		return "WARN"
+	case LogError:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LogLevel(%d)", int(l))
+}
+
+// DefaultLogger is used when no logger is set in the options, nil to discard everything
+var DefaultLogger Logger = NewStdLogger(nil, LogInfo)
+
+// NewStdLogger returns a Logger writing messages of at least minLevel to l (the standard logger if nil),
+// formatted as "LEVEL msg key=value ..."
+func NewStdLogger(l *log.Logger, minLevel LogLevel) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &stdLogger{l: l, minLevel: minLevel}
+}
+
+type stdLogger struct {
+	l        *log.Logger
+	minLevel LogLevel
+}
+
+func (s *stdLogger) Debug(msg string, args ...interface{}) { s.log(LogDebug, msg, args) }
+func (s *stdLogger) Info(msg string, args ...interface{})  { s.log(LogInfo, msg, args) }
+func (s *stdLogger) Warn(msg string, args ...interface{})  { s.log(LogWarn, msg, args) }
+func (s *stdLogger) Error(msg string, args ...interface{}) { s.log(LogError, msg, args) }
+
+func (s *stdLogger) log(level LogLevel, msg string, args []interface{}) {
+	if level < s.minLevel {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 == len(args) {
+			fmt.Fprintf(&b, " %v", args[i])
+			break
+		}
+		fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+	}
+
+	s.l.Output(3, b.String())
+}
+
+// nopLogger discards everything
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, args ...interface{}) {}
+func (nopLogger) Info(msg string, args ...interface{})  {}
+func (nopLogger) Warn(msg string, args ...interface{})  {}
+func (nopLogger) Error(msg string, args ...interface{}) {}
+
+// logger returns DefaultLogger, or one discarding everything if it's nil
+func logger() Logger {
+	if DefaultLogger == nil {
+		return nopLogger{}
+	}
+	return DefaultLogger
+}
+
+// logger returns the logger to use for sessions with the options
+func (e EncodeOptions) logger() Logger {
+	if e.Logger != nil {
+		return e.Logger
+	}
+	return logger()
+}
@@ -0,0 +1,102 @@
+package dca
+
+import (
+	"io"
+	"time"
+)
+
+// MemoryTrack is a track loaded into memory, it implements OpusReader with seeking.
+// The frames can be shared by any number of streams with their own position, see Reader.
+type MemoryTrack struct {
+	frames        [][]byte
+	frameDuration time.Duration
+	metadata      *Metadata
+
+	pos int
+}
+
+// LoadIntoMemory reads all the frames of source into memory. The metadata of source is kept if it has any.
+func LoadIntoMemory(source OpusReader) (*MemoryTrack, error) {
+	var frames [][]byte
+	for {
+		frame, err := source.OpusFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return &MemoryTrack{
+		frames:        frames,
+		frameDuration: source.FrameDuration(),
+		metadata:      sourceMetadata(source),
+	}, nil
+}
+
+// Reader returns a new reader of the track starting at the beginning, sharing the frames but with its own position.
+// Use one for every stream playing the track at the same time.
+func (m *MemoryTrack) Reader() *MemoryTrack {
+	return &MemoryTrack{
+		frames:        m.frames,
+		frameDuration: m.frameDuration,
+		metadata:      m.metadata,
+	}
+}
+
+// OpusFrame implements OpusReader. The frame is shared with other readers of the track and must not be modified.
+func (m *MemoryTrack) OpusFrame() ([]byte, error) {
+	if m.pos >= len(m.frames) {
+		return nil, io.EOF
+	}
+
+	frame := m.frames[m.pos]
+	m.pos++
+	return frame, nil
+}
+
+// FrameDuration implements OpusReader
+func (m *MemoryTrack) FrameDuration() time.Duration {
+	return m.frameDuration
+}
+
+// Seek moves to the frame at pos (rounded down to a whole frame)
+func (m *MemoryTrack) Seek(pos time.Duration) error {
+	frame := int(pos / m.frameDuration)
+	if frame < 0 {
+		frame = 0
+	} else if frame > len(m.frames) {
+		frame = len(m.frames)
+	}
+
+	m.pos = frame
+	return nil
+}
+
+// Rewind moves back to the start
+func (m *MemoryTrack) Rewind() {
+	m.pos = 0
+}
+
+// Position returns the current position
+func (m *MemoryTrack) Position() time.Duration {
+	return time.Duration(m.pos) * m.frameDuration
+}
+
+// Duration returns the total duration
+func (m *MemoryTrack) Duration() (time.Duration, error) {
+	return time.Duration(len(m.frames)) * m.frameDuration, nil
+}
+
+// Frames returns the number of frames
+func (m *MemoryTrack) Frames() int {
+	return len(m.frames)
+}
+
+// Metadata returns the metadata of the source the track was loaded from, nil if it didn't have any
+func (m *MemoryTrack) Metadata() *Metadata {
+	return m.metadata
+}
@@ -0,0 +1,51 @@
+package dca
+
+import (
+	"math"
+)
+
+// Level is the loudness of a frame, relative to full scale (0-1)
+type Level struct {
+	RMS  float64
+	Peak float64
+}
+
+// RMSDB returns the rms level in dBFS, -Inf for digital silence
+func (l Level) RMSDB() float64 {
+	return 20 * math.Log10(l.RMS)
+}
+
+// PeakDB returns the peak level in dBFS, -Inf for digital silence
+func (l Level) PeakDB() float64 {
+	return 20 * math.Log10(l.Peak)
+}
+
+// MeasureLevel returns the rms and peak level of pcm
+func MeasureLevel(pcm []int16) Level {
+	if len(pcm) == 0 {
+		return Level{}
+	}
+
+	var sum float64
+	var peak float64
+	for _, sample := range pcm {
+		s := math.Abs(float64(sample)) / 32768
+		sum += s * s
+		if s > peak {
+			peak = s
+		}
+	}
+
+	return Level{
+		RMS:  math.Sqrt(sum / float64(len(pcm))),
+		Peak: peak,
+	}
+}
+
+// NewMeter returns an OpusReader that passes the frames of source through as is,
+// while decoding them and calling report with the level of every frame, see NewPCMTap
+func NewMeter(source OpusReader, report func(level Level)) (*PCMTap, error) {
+	return NewPCMTap(source, func(pcm []int16) {
+		report(MeasureLevel(pcm))
+	})
+}
@@ -0,0 +1,136 @@
+package dca
+
+import (
+	"expvar"
+	"time"
+)
+
+// Metrics receives events from encode sessions and streams, to be exported to prometheus, expvar etc.
+// The methods are called from the goroutines doing the work, so they have to be safe for concurrent use and return quickly.
+// Embed NopMetrics to only implement some of them.
+type Metrics interface {
+	// EncodeStarted is called when an encode session starts encoding (after waiting for its Limiter)
+	EncodeStarted()
+	// EncodeFinished is called when an encode session ends, err is nil if it finished cleanly or was stopped.
+	// stats are the last stats of the session, stats.Speed is the overall encode speed.
+	EncodeFinished(err error, stats *EncodeStats)
+	// FrameEncoded is called for every opus frame produced by an encode session
+	FrameEncoded()
+
+	// StreamStarted is called when a StreamingSession starts
+	StreamStarted()
+	// StreamFinished is called when a StreamingSession ends, err is nil if it reached the end of the source
+	StreamFinished(err error)
+	// FrameSent is called for every frame sent by a StreamingSession, with how long sending it blocked
+	FrameSent(sendTime time.Duration)
+	// SendTimeout is called when a StreamingSession times out sending a frame
+	SendTimeout()
+}
+
+// DefaultMetrics receives the events of streams, and encodes with no Metrics set in the options. nil for none
+var DefaultMetrics Metrics
+
+// NopMetrics implements Metrics by ignoring everything
+type NopMetrics struct{}
+
+func (NopMetrics) EncodeStarted()                               {}
+func (NopMetrics) EncodeFinished(err error, stats *EncodeStats) {}
+func (NopMetrics) FrameEncoded()                                {}
+func (NopMetrics) StreamStarted()                               {}
+func (NopMetrics) StreamFinished(err error)                     {}
+func (NopMetrics) FrameSent(sendTime time.Duration)             {}
+func (NopMetrics) SendTimeout()                                 {}
+
+// metrics returns DefaultMetrics, or NopMetrics if it's nil
+func metrics() Metrics {
+	if DefaultMetrics == nil {
+		return NopMetrics{}
+	}
+	return DefaultMetrics
+}
+
+// metrics returns the metrics to use for sessions with the options
+func (e EncodeOptions) metrics() Metrics {
+	if e.Metrics != nil {
+		return e.Metrics
+	}
+	return metrics()
+}
+
+// Upper bounds of the encode speed buckets of ExpvarMetrics
+var expvarSpeedBuckets = []struct {
+	max  float32
+	name string
+}{
+	{1, "encode_speed_le_1x"},
+	{2, "encode_speed_le_2x"},
+	{5, "encode_speed_le_5x"},
+	{10, "encode_speed_le_10x"},
+	{50, "encode_speed_le_50x"},
+}
+
+// ExpvarMetrics implements Metrics by counting the events in an expvar.Map
+type ExpvarMetrics struct {
+	m *expvar.Map
+}
+
+// NewExpvarMetrics publishes a map named name with expvar and returns Metrics counting in it.
+// Like expvar.Publish it panics if the name is already in use.
+//
+// The counters are encodes_started, encodes_failed, encodes_finished, frames_encoded, streams_started,
+// streams_failed, streams_finished, frames_sent, send_time_ns and send_timeouts.
+// The speed of finished encodes is counted in buckets (encode_speed_le_1x etc, and encode_speed_gt_50x).
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	return &ExpvarMetrics{m: expvar.NewMap(name)}
+}
+
+// Map returns the map the metrics are counted in
+func (e *ExpvarMetrics) Map() *expvar.Map {
+	return e.m
+}
+
+func (e *ExpvarMetrics) EncodeStarted() {
+	e.m.Add("encodes_started", 1)
+}
+
+func (e *ExpvarMetrics) EncodeFinished(err error, stats *EncodeStats) {
+	e.m.Add("encodes_finished", 1)
+	if err != nil {
+		e.m.Add("encodes_failed", 1)
+	}
+
+	if stats == nil || stats.Speed <= 0 {
+		return
+	}
+	for _, bucket := range expvarSpeedBuckets {
+		if stats.Speed <= bucket.max {
+			e.m.Add(bucket.name, 1)
+			return
+		}
+	}
+	e.m.Add("encode_speed_gt_50x", 1)
+}
+
+func (e *ExpvarMetrics) FrameEncoded() {
+	e.m.Add("frames_encoded", 1)
+}
+
+func (e *ExpvarMetrics) StreamStarted() {
+	e.m.Add("streams_started", 1)
+}
+
+func (e *ExpvarMetrics) StreamFinished(err error) {
+	e.m.Add("streams_finished", 1)
+	if err != nil {
+		e.m.Add("streams_failed", 1)
+	}
+}
+
+func (e *ExpvarMetrics) FrameSent(sendTime time.Duration) {
+	e.m.Add("frames_sent", 1)
+	e.m.Add("send_time_ns", int64(sendTime))
+}
+
+func (e *ExpvarMetrics) SendTimeout() {
+	e.m.Add("send_timeouts", 1)
+}
@@ -0,0 +1,330 @@
+package dca
+
+import (
+	"io"
+	"math"
+	"sync"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+const (
+	mixerChannels      = 2
+	mixerSampleRate    = 48000
+	mixerFrameDuration = 20 * time.Millisecond
+	mixerFrameSize     = int(mixerFrameDuration * mixerSampleRate / time.Second)
+
+	// Largest opus frame in samples per channel (120ms)
+	maxOpusFrameSize = 5760
+)
+
+// DuckRamp is how long it takes a Mixer to duck the other sources when an overlay starts, and to restore them after
+var DuckRamp = 100 * time.Millisecond
+
+// Mixer mixes several OpusReader sources into a single opus stream, for example a soundboard over music.
+// The sources are decoded to pcm, summed with their gain and encoded again, it implements OpusReader
+// so it can be streamed like any other source. Assumes 48khz stereo audio, as used by discord.
+//
+// Sources are removed when they end, after which OpusFrame returns io.EOF if there are none left,
+// unless SetKeepAlive is enabled.
+type Mixer struct {
+	sync.Mutex
+
+	inputs    []*MixerInput
+	encoder   *gopus.Encoder
+	keepAlive bool
+	pcm       []int16
+
+	// Current gain applied to the sources that aren't overlays, 1 when not ducking
+	duckLevel float64
+}
+
+// MixerInput is a source added to a Mixer
+type MixerInput struct {
+	mixer   *Mixer
+	source  OpusReader
+	decoder *gopus.Decoder
+	pending []int16 // Decoded samples not mixed yet
+
+	// Protected by the mixer lock
+	gain    float64
+	overlay bool    // Overlays duck the other sources while playing
+	duck    float64 // Gain applied to the other sources while the overlay is playing
+	err     error
+	done    chan struct{}
+}
+
+// NewMixer returns a mixer encoding the mixed audio with bitrate (in kb/s)
+func NewMixer(bitrate int) (*Mixer, error) {
+	encoder, err := gopus.NewEncoder(mixerSampleRate, mixerChannels, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(bitrate * 1000)
+
+	return &Mixer{
+		encoder:   encoder,
+		pcm:       make([]int16, mixerFrameSize*mixerChannels),
+		duckLevel: 1,
+	}, nil
+}
+
+// Add adds source to the mix with gain (1 for the original volume), it's cleaned up by the caller when done
+func (m *Mixer) Add(source OpusReader, gain float64) (*MixerInput, error) {
+	return m.add(source, gain, false, 1)
+}
+
+// Overlay plays clip over the other sources, ducking them by duckDB decibels until it ends.
+// The gain of the other sources is ramped down and back up over DuckRamp.
+// When several overlays are playing at once the other sources are ducked by the largest amount.
+func (m *Mixer) Overlay(clip OpusReader, duckDB float64) (*MixerInput, error) {
+	return m.add(clip, 1, true, math.Pow(10, -math.Abs(duckDB)/20))
+}
+
+func (m *Mixer) add(source OpusReader, gain float64, overlay bool, duck float64) (*MixerInput, error) {
+	decoder, err := gopus.NewDecoder(mixerSampleRate, mixerChannels)
+	if err != nil {
+		return nil, err
+	}
+
+	input := &MixerInput{
+		mixer:   m,
+		source:  source,
+		decoder: decoder,
+		gain:    gain,
+		overlay: overlay,
+		duck:    duck,
+		done:    make(chan struct{}),
+	}
+
+	m.Lock()
+	m.inputs = append(m.inputs, input)
+	m.Unlock()
+	return input, nil
+}
+
+// duckTarget returns the gain the sources that aren't overlays should be at, m has to be locked
+func (m *Mixer) duckTarget() float64 {
+	target := 1.0
+	for _, input := range m.inputs {
+		if input.overlay && input.duck < target {
+			target = input.duck
+		}
+	}
+	return target
+}
+
+// Inputs returns the number of sources currently being mixed
+func (m *Mixer) Inputs() int {
+	m.Lock()
+	defer m.Unlock()
+	return len(m.inputs)
+}
+
+// SetKeepAlive sets wether silence is returned instead of io.EOF when there are no sources,
+// for a mixer that sources are added to over time
+func (m *Mixer) SetKeepAlive(keepAlive bool) {
+	m.Lock()
+	m.keepAlive = keepAlive
+	m.Unlock()
+}
+
+// OpusFrame implements OpusReader, mixing the next frame of all the sources
+func (m *Mixer) OpusFrame() ([]byte, error) {
+	m.Lock()
+	inputs := make([]*MixerInput, len(m.inputs))
+	copy(inputs, m.inputs)
+	gains := make([]float64, len(inputs))
+	for i, input := range inputs {
+		gains[i] = input.gain
+	}
+	keepAlive := m.keepAlive
+
+	// Move the duck level towards the target, ramping it within the frame
+	duckFrom := m.duckLevel
+	duckTo := m.duckTarget()
+	if DuckRamp > mixerFrameDuration {
+		step := float64(mixerFrameDuration) / float64(DuckRamp)
+		if duckTo < duckFrom-step {
+			duckTo = duckFrom - step
+		} else if duckTo > duckFrom+step {
+			duckTo = duckFrom + step
+		}
+	}
+	m.duckLevel = duckTo
+	m.Unlock()
+
+	if len(inputs) == 0 {
+		if keepAlive {
+			return SilenceFrame, nil
+		}
+		return nil, io.EOF
+	}
+
+	mixed := make([]float64, len(m.pcm))
+	for i, input := range inputs {
+		err := input.fill(len(mixed))
+		if err != nil {
+			input.finish(err)
+		}
+
+		n := len(mixed)
+		if len(input.pending) < n {
+			n = len(input.pending)
+		}
+		for j := 0; j < n; j++ {
+			gain := gains[i]
+			if !input.overlay {
+				gain *= duckFrom + (duckTo-duckFrom)*float64(j)/float64(len(mixed))
+			}
+			mixed[j] += float64(input.pending[j]) * gain
+		}
+		input.pending = input.pending[n:]
+	}
+
+	for i, sample := range mixed {
+		if sample > 32767 {
+			sample = 32767
+		} else if sample < -32768 {
+			sample = -32768
+		}
+		m.pcm[i] = int16(sample)
+	}
+
+	return m.encoder.Encode(m.pcm, mixerFrameSize, len(m.pcm)*2)
+}
+
+// FrameDuration implements OpusReader
+func (m *Mixer) FrameDuration() time.Duration {
+	return mixerFrameDuration
+}
+
+// fill decodes frames from the source until there's at least n samples pending
+func (in *MixerInput) fill(n int) error {
+	for len(in.pending) < n {
+		frame, err := in.source.OpusFrame()
+		if err != nil {
+			return err
+		}
+
+		pcm, err := in.decoder.Decode(frame, maxOpusFrameSize, false)
+		if err != nil {
+			return err
+		}
+		in.pending = append(in.pending, pcm...)
+	}
+
+	return nil
+}
+
+// finish removes the input from the mixer, err is io.EOF if the source ended
+func (in *MixerInput) finish(err error) {
+	m := in.mixer
+	m.Lock()
+	defer m.Unlock()
+
+	for i, input := range m.inputs {
+		if input == in {
+			m.inputs = append(m.inputs[:i], m.inputs[i+1:]...)
+			break
+		}
+	}
+
+	select {
+	case <-in.done:
+		return
+	default:
+	}
+
+	if err != io.EOF {
+		in.err = err
+		logger().Warn("Mixer source failed, removing it", "err", err)
+	}
+	close(in.done)
+}
+
+// SetGain sets the gain of the source, 1 for the original volume
+func (in *MixerInput) SetGain(gain float64) {
+	in.mixer.Lock()
+	in.gain = gain
+	in.mixer.Unlock()
+}
+
+// Gain returns the gain of the source
+func (in *MixerInput) Gain() float64 {
+	in.mixer.Lock()
+	defer in.mixer.Unlock()
+	return in.gain
+}
+
+// Remove removes the source from the mix
+func (in *MixerInput) Remove() {
+	in.finish(io.EOF)
+}
+
+// Done returns a channel that's closed when the source is removed, either because it ended or with Remove
+func (in *MixerInput) Done() <-chan struct{} {
+	return in.done
+}
+
+// Err returns the error that caused the source to be removed, nil if it ended normally or hasn't ended yet
+func (in *MixerInput) Err() error {
+	in.mixer.Lock()
+	defer in.mixer.Unlock()
+	return in.err
+}
+
+// MixTo mixes sources (with a gain of 1) and writes the result to w as dca, until they've all ended.
+// For example to mix the files of a Recorder into one.
+func MixTo(w io.Writer, bitrate int, sources ...OpusReader) error {
+	mixer, err := NewMixer(bitrate)
+	if err != nil {
+		return err
+	}
+
+	inputs := make([]*MixerInput, len(sources))
+	for i, source := range sources {
+		inputs[i], err = mixer.Add(source, 1)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer, err := NewWriter(w, &Metadata{
+		Dca: dcaMetadata(),
+		Opus: &OpusMetadata{
+			Bitrate:    bitrate * 1000,
+			SampleRate: mixerSampleRate,
+			FrameSize:  mixerFrameSize * mixerChannels,
+			Channels:   mixerChannels,
+		},
+		SongInfo: &SongMetadata{},
+		Origin:   &OriginMetadata{},
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		frame, err := mixer.OpusFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		err = writer.WriteFrame(frame)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, input := range inputs {
+		if err := input.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,205 @@
+package dca
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// EncodePCM encodes raw pcm (signed 16-bit little endian) using libopus directly, no ffmpeg involved.
+// The pcm needs to be in the FrameRate and Channels specified in options,
+// options that require ffmpeg (filters, input options etc) are ignored.
+func EncodePCM(r io.Reader, options *EncodeOptions) (session *EncodeSession, err error) {
+	err = options.Validate()
+	if err != nil {
+		return
+	}
+
+	err = options.validateNative()
+	if err != nil {
+		return
+	}
+
+	session = newEncodeSession(options)
+	session.pipeReader = r
+	session.native = true
+	registerSession(session)
+	go session.runNative()
+	return
+}
+
+// validateNative returns an error if the options can't be used with libopus directly
+func (opts *EncodeOptions) validateNative() error {
+	switch opts.FrameRate {
+	case 8000, 12000, 16000, 24000, 48000:
+	default:
+		return fmt.Errorf("%w: FrameRate has to be one of 8000, 12000, 16000, 24000 or 48000", ErrInvalidOptions)
+	}
+
+	if opts.Channels != 1 && opts.Channels != 2 {
+		return fmt.Errorf("%w: Channels has to be 1 or 2", ErrInvalidOptions)
+	}
+
+	return nil
+}
+
+// opusApplication returns the gopus equivalent of the audio application
+func (a AudioApplication) opusApplication() gopus.Application {
+	switch a {
+	case AudioApplicationVoip:
+		return gopus.Voip
+	case AudioApplicationLowDelay:
+		return gopus.RestrictedLowDelay
+	}
+	return gopus.Audio
+}
+
+func (e *EncodeSession) runNative() {
+	// Reset running state
+	defer func() {
+		e.Lock()
+		e.running = false
+		e.Unlock()
+		close(e.statsChan)
+		e.setMetadataReady()
+		close(e.done)
+		unregisterSession(e)
+	}()
+
+	if limiter := e.options.limiter(); limiter != nil {
+		if !limiter.acquire(e.stop) {
+			close(e.frameChannel)
+			return
+		}
+		defer limiter.release()
+	}
+
+	metrics := e.options.metrics()
+	metrics.EncodeStarted()
+	defer func() {
+		metrics.EncodeFinished(e.Error(), e.Stats())
+	}()
+
+	e.Lock()
+	e.running = true
+	e.started = time.Now()
+	err := e.setupSpool()
+	if err != nil {
+		e.err = err
+		e.Unlock()
+		e.options.logger().Error("Spool error", "err", err)
+		close(e.frameChannel)
+		return
+	}
+	defer e.closeFrameChannel()
+
+	var metaFrame *Frame
+	if !e.options.RawOutput {
+		metaFrame = e.metadataFrame()
+	}
+	e.setMetadataReady()
+	e.Unlock()
+
+	if metaFrame != nil {
+		e.frameChannel <- metaFrame
+	}
+
+	encoder, err := gopus.NewEncoder(e.options.FrameRate, e.options.Channels, e.options.Application.opusApplication())
+	if err != nil {
+		e.Lock()
+		e.err = err
+		e.Unlock()
+		e.options.logger().Error("Error creating opus encoder", "err", err)
+		return
+	}
+	encoder.SetBitrate(e.options.Bitrate * 1000)
+	encoder.SetVbr(e.options.vbrMode() != VBRModeOff)
+
+	// Samples per channel in a frame
+	frameSize := e.options.FrameRate / 1000 * e.options.FrameDuration
+	pcmBuf := make([]byte, frameSize*e.options.Channels*2)
+	pcm := make([]int16, frameSize*e.options.Channels)
+	gain := e.options.gain()
+
+	var totalBytes int
+	var encoded time.Duration
+	for {
+		e.Lock()
+		stopped := e.stopped
+		e.Unlock()
+		if stopped {
+			return
+		}
+
+		n, err := io.ReadFull(e.pipeReader, pcmBuf)
+		if n == 0 {
+			if err != nil && err != io.EOF {
+				e.Lock()
+				e.err = err
+				e.Unlock()
+			}
+			return
+		}
+
+		// Pad the last frame with silence
+		for i := n; i < len(pcmBuf); i++ {
+			pcmBuf[i] = 0
+		}
+
+		for i := range pcm {
+			sample := int32(float64(int16(binary.LittleEndian.Uint16(pcmBuf[i*2:]))) * gain)
+			if sample > 32767 {
+				sample = 32767
+			} else if sample < -32768 {
+				sample = -32768
+			}
+			pcm[i] = int16(sample)
+		}
+
+		opus, encodeErr := encoder.Encode(pcm, frameSize, len(pcmBuf))
+		if encodeErr != nil {
+			e.Lock()
+			e.err = encodeErr
+			e.Unlock()
+			e.options.logger().Error("Error encoding opus", "err", encodeErr)
+			return
+		}
+
+		if e.options.Realtime {
+			// Don't get ahead of playback
+			select {
+			case <-time.After(time.Until(e.started.Add(encoded))):
+			case <-e.stop:
+				return
+			}
+		}
+
+		writeErr := e.writeOpusFrame(opus)
+		if writeErr != nil {
+			e.options.logger().Error("Error writing opus frame", "err", writeErr)
+			return
+		}
+
+		totalBytes += len(opus)
+		encoded += time.Duration(e.options.FrameDuration) * time.Millisecond
+		e.updateStats(&EncodeStats{
+			Size:     totalBytes / 1000,
+			Duration: encoded,
+			Bitrate:  float32(totalBytes*8) / 1000 / float32(encoded.Seconds()),
+			Speed:    float32(encoded) / float32(time.Since(e.started)),
+		})
+
+		if err != nil {
+			// Partial frame, nothing more to read
+			if err != io.ErrUnexpectedEOF {
+				e.Lock()
+				e.err = err
+				e.Unlock()
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,244 @@
+package dca
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/jonas747/ogg"
+)
+
+var (
+	ErrNotOggOpus = errors.New("Not an Ogg Opus stream")
+)
+
+// OggOpusReader reads the opus packets from an Ogg Opus stream (.opus or .ogg files),
+// it implements OpusReader so it can be streamed directly.
+type OggOpusReader struct {
+	packets *ogg.PacketDecoder
+
+	channels int
+	tags     map[string]string // Keys are upper case
+
+	// the first packet, read ahead to find the frame duration
+	first         []byte
+	frameDuration time.Duration
+}
+
+// NewOggOpusReader returns a reader for the Ogg Opus stream in r, reading the OpusHead and OpusTags headers
+// and the first packet. ErrNotOggOpus is returned if it's not an Ogg Opus stream.
+func NewOggOpusReader(r io.Reader) (*OggOpusReader, error) {
+	reader := &OggOpusReader{
+		packets:       ogg.NewPacketDecoder(ogg.NewDecoder(r)),
+		tags:          make(map[string]string),
+		frameDuration: 20 * time.Millisecond,
+	}
+
+	head, _, err := reader.packets.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if len(head) < 19 || string(head[:8]) != "OpusHead" {
+		return nil, ErrNotOggOpus
+	}
+	reader.channels = int(head[9])
+
+	tags, _, err := reader.packets.Decode()
+	if err != nil {
+		return nil, err
+	}
+	if len(tags) < 8 || string(tags[:8]) != "OpusTags" {
+		return nil, ErrNotOggOpus
+	}
+	reader.parseTags(tags[8:])
+
+	first, _, err := reader.packets.Decode()
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if len(first) > 0 {
+		reader.first = first
+		if info, err := ParsePacket(first); err == nil {
+			reader.frameDuration = info.Duration()
+		}
+	}
+
+	return reader, nil
+}
+
+// parseTags parses the vorbis comments in the OpusTags header, stopping at the first malformed one
+func (o *OggOpusReader) parseTags(data []byte) {
+	readString := func() (string, bool) {
+		if len(data) < 4 {
+			return "", false
+		}
+		l := binary.LittleEndian.Uint32(data)
+		if uint64(l) > uint64(len(data)-4) {
+			return "", false
+		}
+		s := string(data[4 : 4+l])
+		data = data[4+l:]
+		return s, true
+	}
+
+	// Vendor string
+	if _, ok := readString(); !ok || len(data) < 4 {
+		return
+	}
+
+	count := binary.LittleEndian.Uint32(data)
+	data = data[4:]
+	for i := uint32(0); i < count; i++ {
+		comment, ok := readString()
+		if !ok {
+			return
+		}
+
+		split := strings.SplitN(comment, "=", 2)
+		if len(split) == 2 {
+			o.tags[strings.ToUpper(split[0])] = split[1]
+		}
+	}
+}
+
+// OpusFrame implements OpusReader, returning the next opus packet
+func (o *OggOpusReader) OpusFrame() ([]byte, error) {
+	if o.first != nil {
+		packet := o.first
+		o.first = nil
+		return packet, nil
+	}
+
+	packet, _, err := o.packets.Decode()
+	return packet, err
+}
+
+// FrameDuration implements OpusReader, returning the duration of the first frame.
+// Opus streams may change frame duration, but in practice they don't.
+func (o *OggOpusReader) FrameDuration() time.Duration {
+	return o.frameDuration
+}
+
+// Metadata creates dca metadata from the OpusHead and OpusTags headers
+func (o *OggOpusReader) Metadata() *Metadata {
+	channels := o.channels
+	if channels == 0 {
+		channels = 2
+	}
+
+	metadata := &Metadata{
+		Dca: dcaMetadata(),
+		Opus: &OpusMetadata{
+			SampleRate: 48000,
+			// The opus packets are always 48khz
+			FrameSize: int(o.frameDuration*48000/time.Second) * channels,
+			Channels:  channels,
+		},
+		SongInfo: &SongMetadata{
+			Title:       o.tags["TITLE"],
+			Artist:      o.tags["ARTIST"],
+			Album:       o.tags["ALBUM"],
+			Genre:       o.tags["GENRE"],
+			Comments:    o.tags["COMMENT"],
+			AlbumArtist: o.tags["ALBUMARTIST"],
+			Composer:    o.tags["COMPOSER"],
+			Track:       parseTagNumber(o.tags["TRACKNUMBER"]),
+			Disc:        parseTagNumber(o.tags["DISCNUMBER"]),
+			Date:        o.tags["DATE"],
+		},
+		Origin: &OriginMetadata{
+			Source:   "file",
+			Channels: channels,
+			Encoding: "opus",
+		},
+		Extra: &ExtraMetadata{},
+	}
+
+	if metadata.SongInfo.Comments == "" {
+		metadata.SongInfo.Comments = o.tags["DESCRIPTION"]
+	}
+
+	if picture, ok := o.tags["METADATA_BLOCK_PICTURE"]; ok {
+		cover := pictureData(picture)
+		if cover != "" {
+			metadata.SongInfo.Cover = &cover
+		}
+	}
+
+	return metadata
+}
+
+// pictureData returns the base64 encoded image in a base64 encoded FLAC picture block,
+// or an empty string if it's invalid
+func pictureData(block string) string {
+	data, err := base64.StdEncoding.DecodeString(block)
+	if err != nil {
+		return ""
+	}
+
+	r := bytes.NewReader(data)
+	var pictureType, l uint32
+
+	// Type, mime and description
+	binary.Read(r, binary.BigEndian, &pictureType)
+	for i := 0; i < 2; i++ {
+		err = binary.Read(r, binary.BigEndian, &l)
+		if err != nil || int64(l) > int64(r.Len()) {
+			return ""
+		}
+		r.Seek(int64(l), io.SeekCurrent)
+	}
+
+	// Width, height, depth and colors
+	r.Seek(16, io.SeekCurrent)
+
+	err = binary.Read(r, binary.BigEndian, &l)
+	if err != nil || int64(l) > int64(r.Len()) || l == 0 {
+		return ""
+	}
+
+	picture := make([]byte, l)
+	r.Read(picture)
+	return base64.StdEncoding.EncodeToString(picture)
+}
+
+// RemuxOgg converts the Ogg Opus (.opus or .ogg) file in r into a dca file written to w without re-encoding,
+// the metadata is created from the OpusHead and OpusTags headers.
+func RemuxOgg(r io.Reader, w io.Writer) error {
+	reader, err := NewOggOpusReader(r)
+	if err != nil {
+		return err
+	}
+
+	err = writeMetadataHeader(w, FormatVersion, reader.Metadata())
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	for {
+		packet, err := reader.OpusFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if len(packet) > MaxFrameSize {
+			return ErrBadFrame
+		}
+
+		buf.Reset()
+		binary.Write(&buf, binary.LittleEndian, int16(len(packet)))
+		buf.Write(packet)
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package dca
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"image"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jonas747/ogg"
+)
+
+const (
+	// Samples libopus (and by that ffmpeg) adds to the start of the stream, which the
+	// OpusHead tells players to skip. dca doesn't store it so we assume the libopus default.
+	oggOpusPreSkip = 312
+
+	// Max number of lacing values on a page is 255, leave some room for large packets
+	oggMaxPageSegments = 200
+)
+
+// WriteOggOpus converts the dca file in r into an Ogg Opus file written to w,
+// which can be played by most media players and browsers.
+//
+// The OpusHead is generated from the opus metadata, and the OpusTags from the song info,
+// including the cover art if present.
+func WriteOggOpus(w io.Writer, r io.Reader) error {
+	decoder := NewDecoder(r)
+	err := decoder.checkMetadata()
+	if err != nil {
+		return err
+	}
+
+	encoder := ogg.NewEncoder(rand.Uint32(), w)
+	err = encoder.EncodeBOS(0, [][]byte{opusHead(decoder.Metadata)})
+	if err != nil {
+		return err
+	}
+
+	err = encoder.Encode(0, [][]byte{opusTags(decoder.Metadata)})
+	if err != nil {
+		return err
+	}
+
+	// Granule positions are always in 48khz samples
+	frameSamples := int64(decoder.FrameDuration() * 48000 / time.Second)
+	granule := int64(oggOpusPreSkip)
+
+	var packets [][]byte
+	segments := 0
+	for {
+		frame, err := decoder.OpusFrame()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			return err
+		}
+
+		frameSegments := len(frame)/255 + 1
+		if segments+frameSegments > oggMaxPageSegments && len(packets) > 0 {
+			err = encoder.Encode(granule, packets)
+			if err != nil {
+				return err
+			}
+			packets = nil
+			segments = 0
+		}
+
+		packets = append(packets, frame)
+		segments += frameSegments
+		granule += frameSamples
+	}
+
+	return encoder.EncodeEOS(granule, packets)
+}
+
+// opusHead creates the identification header, see https://tools.ietf.org/html/rfc7845#section-5.1
+func opusHead(metadata *Metadata) []byte {
+	channels := 2
+	sampleRate := 48000
+	if metadata != nil && metadata.Opus != nil {
+		if metadata.Opus.Channels != 0 {
+			channels = metadata.Opus.Channels
+		}
+		if metadata.Opus.SampleRate != 0 {
+			sampleRate = metadata.Opus.SampleRate
+		}
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("OpusHead")
+	buf.WriteByte(1) // Version
+	buf.WriteByte(byte(channels))
+	binary.Write(&buf, binary.LittleEndian, uint16(oggOpusPreSkip))
+	binary.Write(&buf, binary.LittleEndian, uint32(sampleRate))
+	binary.Write(&buf, binary.LittleEndian, int16(0)) // Output gain
+	buf.WriteByte(0)                                  // Channel mapping family, mono or stereo
+	return buf.Bytes()
+}
+
+// opusTags creates the comment header, see https://tools.ietf.org/html/rfc7845#section-5.2
+func opusTags(metadata *Metadata) []byte {
+	var comments []string
+	add := func(key, value string) {
+		if value != "" {
+			comments = append(comments, key+"="+value)
+		}
+	}
+
+	if metadata != nil && metadata.SongInfo != nil {
+		info := metadata.SongInfo
+		add("TITLE", info.Title)
+		add("ARTIST", info.Artist)
+		add("ALBUM", info.Album)
+		add("GENRE", info.Genre)
+		add("COMMENT", info.Comments)
+		add("ALBUMARTIST", info.AlbumArtist)
+		add("COMPOSER", info.Composer)
+		add("DATE", info.Date)
+		if info.Track != 0 {
+			add("TRACKNUMBER", strconv.Itoa(info.Track))
+		}
+		if info.Disc != 0 {
+			add("DISCNUMBER", strconv.Itoa(info.Disc))
+		}
+		if info.Cover != nil {
+			add("METADATA_BLOCK_PICTURE", pictureBlock(*info.Cover))
+		}
+	}
+
+	vendor := "dca " + LibraryVersion
+
+	var buf bytes.Buffer
+	buf.WriteString("OpusTags")
+	binary.Write(&buf, binary.LittleEndian, uint32(len(vendor)))
+	buf.WriteString(vendor)
+	binary.Write(&buf, binary.LittleEndian, uint32(len(comments)))
+	for _, comment := range comments {
+		binary.Write(&buf, binary.LittleEndian, uint32(len(comment)))
+		buf.WriteString(comment)
+	}
+	return buf.Bytes()
+}
+
+// pictureBlock creates a base64 encoded FLAC picture block from the base64 encoded cover art,
+// returns an empty string if the cover art is invalid
+func pictureBlock(cover string) string {
+	data, err := base64.StdEncoding.DecodeString(cover)
+	if err != nil || len(data) == 0 {
+		return ""
+	}
+
+	var width, height int
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil {
+		width, height = config.Width, config.Height
+	}
+
+	mime := http.DetectContentType(data)
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.BigEndian, uint32(3)) // Front cover
+	binary.Write(&buf, binary.BigEndian, uint32(len(mime)))
+	buf.WriteString(mime)
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // Description
+	binary.Write(&buf, binary.BigEndian, uint32(width))
+	binary.Write(&buf, binary.BigEndian, uint32(height))
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // Color depth, unknown
+	binary.Write(&buf, binary.BigEndian, uint32(0)) // Number of colors, not indexed
+	binary.Write(&buf, binary.BigEndian, uint32(len(data)))
+	buf.Write(data)
+
+	return base64.StdEncoding.EncodeToString(buf.Bytes())
+}
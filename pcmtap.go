@@ -0,0 +1,107 @@
+package dca
+
+import (
+	"encoding/binary"
+	"io"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// PCMTap is an OpusReader that passes the frames of another one through as is,
+// while also decoding them and handing the pcm to a function.
+// Used to feed speech to text, visualizers etc with the same audio that's being streamed.
+type PCMTap struct {
+	source   OpusReader
+	decoder  *gopus.Decoder
+	channels int
+	tap      func(pcm []int16)
+}
+
+// NewPCMTap returns a PCMTap reading from source, tap is called with the decoded pcm
+// (48khz, interleaved if stereo) of every frame read. It's called from the goroutine reading the frames
+// (the one streaming them with a StreamingSession), so it should return quickly.
+// The number of channels is taken from the metadata of source if available, stereo otherwise.
+func NewPCMTap(source OpusReader, tap func(pcm []int16)) (*PCMTap, error) {
+	channels := sourceChannels(source)
+	decoder, err := gopus.NewDecoder(48000, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	return &PCMTap{
+		source:   source,
+		decoder:  decoder,
+		channels: channels,
+		tap:      tap,
+	}, nil
+}
+
+// NewPCMTapWriter is the same as NewPCMTap, but writes the pcm to w as s16le.
+// Write errors are logged and the pcm dropped, the frames are still passed through.
+func NewPCMTapWriter(source OpusReader, w io.Writer) (*PCMTap, error) {
+	var buf []byte
+	return NewPCMTap(source, func(pcm []int16) {
+		buf = buf[:0]
+		for _, sample := range pcm {
+			buf = append(buf, 0, 0)
+			binary.LittleEndian.PutUint16(buf[len(buf)-2:], uint16(sample))
+		}
+
+		_, err := w.Write(buf)
+		if err != nil {
+			logger().Error("PCM tap write error", "err", err)
+		}
+	})
+}
+
+// OpusFrame implements OpusReader
+func (p *PCMTap) OpusFrame() ([]byte, error) {
+	frame, err := p.source.OpusFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	pcm, err := p.decoder.Decode(frame, maxOpusFrameSize, false)
+	if err != nil {
+		logger().Error("PCM tap decode error", "err", err)
+		return frame, nil
+	}
+
+	p.tap(pcm)
+	return frame, nil
+}
+
+// FrameDuration implements OpusReader
+func (p *PCMTap) FrameDuration() time.Duration {
+	return p.source.FrameDuration()
+}
+
+// Channels returns the number of channels in the pcm
+func (p *PCMTap) Channels() int {
+	return p.channels
+}
+
+// Seek seeks the source if it supports it, see StreamingSession.Seek
+func (p *PCMTap) Seek(pos time.Duration) error {
+	seeker, ok := p.source.(interface{ Seek(time.Duration) error })
+	if !ok {
+		return ErrSourceNotSeekable
+	}
+
+	err := seeker.Seek(pos)
+	if err != nil {
+		return err
+	}
+
+	p.decoder.ResetState()
+	return nil
+}
+
+// sourceChannels returns the number of channels in the metadata of source, 2 if unknown
+func sourceChannels(source OpusReader) int {
+	if m := sourceMetadata(source); m != nil && m.Opus != nil && (m.Opus.Channels == 1 || m.Opus.Channels == 2) {
+		return m.Opus.Channels
+	}
+	return 2
+}
@@ -0,0 +1,609 @@
+package dca
+
+import (
+	"io"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Track is something that can be queued in a Player
+type Track struct {
+	Path    string         // File or url to encode, used if Open is nil
+	Options *EncodeOptions // Options to encode Path with, StdEncodeOptions if nil
+
+	// If set, called to open the source when the track starts playing.
+	// If the source has a Close() error or Cleanup() error method it's called when the track ends.
+	Open func() (OpusReader, error)
+
+	// Not used by dca, for keeping track of who queued it etc.
+	Data interface{}
+}
+
+// open opens the source of the track
+func (t *Track) open() (OpusReader, error) {
+	if t.Open != nil {
+		return t.Open()
+	}
+
+	options := t.Options
+	if options == nil {
+		options = StdEncodeOptions
+	}
+	return EncodeFile(t.Path, options)
+}
+
+// closeSource cleans up after a source, nil is ignored
+func closeSource(source OpusReader) {
+	switch s := source.(type) {
+	case interface{ Cleanup() error }:
+		s.Cleanup()
+	case io.Closer:
+		s.Close()
+	}
+}
+
+// sourceMetadata returns the metadata of a source, if any
+func sourceMetadata(source OpusReader) *Metadata {
+	switch s := source.(type) {
+	case *EncodeSession:
+		return s.Metadata()
+	case *Decoder:
+		return s.Metadata
+	case *OggOpusReader:
+		return s.Metadata()
+	case *MemoryTrack:
+		return s.Metadata()
+	case *FileSourceReader:
+		return s.Metadata()
+	case *Subscription:
+		return s.Metadata()
+	}
+	return nil
+}
+
+// RepeatMode is how a Player repeats tracks
+type RepeatMode int
+
+const (
+	RepeatOff RepeatMode = iota
+	RepeatOne            // Repeat the current track
+	RepeatAll            // Add tracks back to the end of the queue when they end
+)
+
+// PlayerEventType is the type of a PlayerEvent
+type PlayerEventType int
+
+const (
+	PlayerEventTrackStart PlayerEventType = iota // A track started playing
+	PlayerEventTrackEnd                          // A track ended or was skipped
+	PlayerEventTrackError                        // A track failed to open or stopped because of an error, Err is set
+	PlayerEventQueueEnd                          // The queue is empty and nothing is playing
+)
+
+// PlayerEvent is sent by a Player on track transitions
+type PlayerEvent struct {
+	Type  PlayerEventType
+	Track *Track
+	Err   error
+}
+
+// Player plays a queue of tracks through a StreamingSession
+type Player struct {
+	sync.Mutex
+
+	sender OpusSender
+	events chan *PlayerEvent
+
+	stream *StreamingSession
+	queue  []*Track
+	repeat RepeatMode
+	paused bool
+
+	// The track playing, its source and metadata
+	current     *Track
+	source      OpusReader
+	metadata    *Metadata
+	trackFrames int // Frames of the track sent
+	readFrames  int // Frames read from the source of the track
+
+	// Overlap between tracks, 0 to disable crossfading
+	crossfade time.Duration
+	// The last frames of the current track, held back to mix with the next one
+	tail [][]byte
+	// Frames to send before reading from the source again, the crossfade between two tracks
+	mixed [][]byte
+
+	// Set by Skip and Stop to end the current track
+	skip bool
+	// Set by Stop to not start the next track
+	stopping bool
+	// Incremented by Stop, to notice a Stop while a track was being opened
+	stops int
+
+	// Open the next track while the current one is playing
+	preload bool
+	// The next track, opened in advance if preload is enabled
+	preloaded       *Track
+	preloadedSource OpusReader
+}
+
+// NewPlayer returns a new player sending to sender.
+// events : If not nil, events will be sent on it on track transitions, it has to be read from as sending blocks playback
+func NewPlayer(sender OpusSender, events chan *PlayerEvent) *Player {
+	return &Player{
+		sender: sender,
+		events: events,
+	}
+}
+
+// Enqueue adds tracks to the end of the queue, starting playback if nothing is playing
+func (p *Player) Enqueue(tracks ...*Track) {
+	p.Lock()
+	p.queue = append(p.queue, tracks...)
+	p.stopping = false
+	p.startStream()
+	p.Unlock()
+}
+
+// startStream starts the streaming session if it's not running
+func (p *Player) startStream() {
+	if p.stream != nil || len(p.queue) == 0 {
+		return
+	}
+
+	done := make(chan error, 1)
+	p.stream = NewStreamTo(&playerSource{p: p}, p.sender, done)
+	p.stream.SetPaused(p.paused)
+	go p.waitStream(p.stream, done)
+}
+
+// waitStream waits for the streaming session to finish, starting a new one if tracks were queued in the meantime
+func (p *Player) waitStream(stream *StreamingSession, done chan error) {
+	err := <-done
+
+	p.Lock()
+	p.stream = nil
+
+	var events []*PlayerEvent
+	var ended OpusReader
+	if p.current != nil {
+		// Stopped by an error sending
+		var event *PlayerEvent
+		event, ended = p.endTrack(err)
+		events = append(events, event)
+	}
+
+	if !p.stopping && len(p.queue) > 0 {
+		p.startStream()
+	} else {
+		events = append(events, &PlayerEvent{Type: PlayerEventQueueEnd})
+	}
+	p.Unlock()
+
+	closeSource(ended)
+	p.emit(events...)
+}
+
+func (p *Player) emit(events ...*PlayerEvent) {
+	if p.events == nil {
+		return
+	}
+
+	for _, event := range events {
+		p.events <- event
+	}
+}
+
+// nextTrack opens the next track in the queue, returning false if there's nothing more to play.
+// Called with the lock held, which is released while opening the track.
+func (p *Player) nextTrack() (started bool, events []*PlayerEvent) {
+	for !p.stopping && len(p.queue) > 0 {
+		track := p.queue[0]
+		p.queue = p.queue[1:]
+
+		var source OpusReader
+		if p.preloaded == track {
+			source = p.preloadedSource
+			p.preloaded = nil
+			p.preloadedSource = nil
+		} else {
+			preloaded := p.takePreload()
+			stops := p.stops
+			p.Unlock()
+
+			closeSource(preloaded)
+			var err error
+			source, err = track.open()
+
+			p.Lock()
+			if err != nil {
+				events = append(events, &PlayerEvent{Type: PlayerEventTrackError, Track: track, Err: err})
+				continue
+			}
+			if p.stops != stops {
+				// Stopped while opening
+				p.Unlock()
+				closeSource(source)
+				p.Lock()
+				continue
+			}
+		}
+
+		p.current = track
+		p.source = source
+		p.metadata = nil
+		p.trackFrames = 0
+		p.readFrames = 0
+		p.skip = false
+		events = append(events, &PlayerEvent{Type: PlayerEventTrackStart, Track: track})
+		return true, events
+	}
+
+	return false, events
+}
+
+// endTrack ends the current track and requeues it depending on the repeat mode.
+// The source of the track is returned to be closed with closeSource after releasing the lock.
+func (p *Player) endTrack(err error) (event *PlayerEvent, source OpusReader) {
+	track := p.current
+	source = p.source
+	p.current = nil
+	p.source = nil
+	p.metadata = nil
+
+	if !p.skip && !p.stopping {
+		switch p.repeat {
+		case RepeatOne:
+			p.queue = append([]*Track{track}, p.queue...)
+		case RepeatAll:
+			p.queue = append(p.queue, track)
+		}
+	}
+	p.skip = false
+
+	if err != nil && err != io.EOF {
+		return &PlayerEvent{Type: PlayerEventTrackError, Track: track, Err: err}, source
+	}
+	return &PlayerEvent{Type: PlayerEventTrackEnd, Track: track}, source
+}
+
+// SetPreload sets wether the next track is opened (and for encoded tracks starts encoding) while
+// the current one is playing, so there's no gap between them. Disabled by default.
+func (p *Player) SetPreload(enabled bool) {
+	p.Lock()
+	p.preload = enabled
+	var preloaded OpusReader
+	if !enabled {
+		preloaded = p.takePreload()
+	}
+	p.Unlock()
+
+	closeSource(preloaded)
+}
+
+// SetCrossfade sets how long the end of a track overlaps with the start of the next one, 0 to disable.
+// The overlapping audio is decoded, mixed and encoded again with libopus, which assumes 48khz stereo audio.
+// Skipped tracks are not crossfaded.
+func (p *Player) SetCrossfade(d time.Duration) {
+	p.Lock()
+	p.crossfade = d
+	p.Unlock()
+}
+
+// crossfadeFrames returns the number of frames to overlap
+func (p *Player) crossfadeFrames(source OpusReader) int {
+	if p.crossfade <= 0 {
+		return 0
+	}
+	return int(p.crossfade / source.FrameDuration())
+}
+
+// crossfadeNext ends the current track and starts the next one, mixing the held back frames of the current
+// track with the start of the next one. Called with the lock held, which is released.
+func (p *Player) crossfadeNext(err error) {
+	tail := p.tail
+	p.tail = nil
+
+	event, ended := p.endTrack(err)
+	events := []*PlayerEvent{event}
+	started, startEvents := p.nextTrack()
+	events = append(events, startEvents...)
+	next := p.source
+	if !started {
+		// Nothing to crossfade with
+		p.mixed = tail
+		p.Unlock()
+		closeSource(ended)
+		p.emit(events...)
+		return
+	}
+	p.Unlock()
+	closeSource(ended)
+	p.emit(events...)
+
+	var head [][]byte
+	for len(head) < len(tail) {
+		frame, err := next.OpusFrame()
+		if err != nil {
+			break
+		}
+		head = append(head, frame)
+	}
+
+	bitrate := 128000
+	metadata := sourceMetadata(next)
+	if metadata != nil && metadata.Opus != nil && metadata.Opus.Bitrate > 0 {
+		bitrate = metadata.Opus.Bitrate
+	}
+
+	mixed, err := mixCrossfade(tail, head, next.FrameDuration(), bitrate)
+	if err != nil {
+		logger().Warn("Error crossfading, playing the tracks after each other", "err", err)
+		mixed = append(tail, head...)
+	}
+
+	p.Lock()
+	if p.source == next {
+		p.metadata = metadata
+		p.readFrames += len(head)
+	}
+	p.mixed = mixed
+	p.Unlock()
+
+	p.preloadNext()
+}
+
+// nextInQueue returns the track that will play after the current one
+func (p *Player) nextInQueue() *Track {
+	if p.repeat == RepeatOne && p.current != nil {
+		return p.current
+	}
+	if len(p.queue) > 0 {
+		return p.queue[0]
+	}
+	if p.repeat == RepeatAll {
+		return p.current
+	}
+	return nil
+}
+
+// preloadNext opens the next track if preloading is enabled, called without the lock held
+func (p *Player) preloadNext() {
+	p.Lock()
+	next := p.nextInQueue()
+	if !p.preload || next == nil || next == p.preloaded {
+		p.Unlock()
+		return
+	}
+	preloaded := p.takePreload()
+	p.Unlock()
+
+	closeSource(preloaded)
+
+	source, err := next.open()
+	if err != nil {
+		// Tried again when it's time to play it
+		return
+	}
+
+	p.Lock()
+	if p.preloaded != nil || p.nextInQueue() != next {
+		// Changed while opening
+		p.Unlock()
+		closeSource(source)
+		return
+	}
+	p.preloaded = next
+	p.preloadedSource = source
+	p.Unlock()
+}
+
+// takePreload clears the preloaded track, returning its source to be closed with closeSource after releasing the lock
+func (p *Player) takePreload() OpusReader {
+	source := p.preloadedSource
+	p.preloaded = nil
+	p.preloadedSource = nil
+	return source
+}
+
+// Queue returns a copy of the queue
+func (p *Player) Queue() []*Track {
+	p.Lock()
+	defer p.Unlock()
+
+	queue := make([]*Track, len(p.queue))
+	copy(queue, p.queue)
+	return queue
+}
+
+// Skip ends the current track and starts the next one
+func (p *Player) Skip() {
+	p.Lock()
+	if p.current != nil {
+		p.skip = true
+	}
+	p.mixed = nil
+	p.Unlock()
+}
+
+// Stop clears the queue and ends the current track
+func (p *Player) Stop() {
+	p.Lock()
+	p.queue = nil
+	p.stopping = true
+	p.stops++
+	preloaded := p.takePreload()
+	if p.current != nil {
+		p.skip = true
+	}
+	p.mixed = nil
+
+	// The stream has to be running to end
+	paused := p.paused
+	p.paused = false
+	stream := p.stream
+	p.Unlock()
+
+	closeSource(preloaded)
+	if paused && stream != nil {
+		stream.SetPaused(false)
+	}
+}
+
+// SetPaused pauses or resumes playback
+func (p *Player) SetPaused(paused bool) {
+	p.Lock()
+	p.paused = paused
+	stream := p.stream
+	p.Unlock()
+
+	if stream != nil {
+		stream.SetPaused(paused)
+	}
+}
+
+// Paused returns wether the player is paused
+func (p *Player) Paused() bool {
+	p.Lock()
+	defer p.Unlock()
+	return p.paused
+}
+
+// Shuffle shuffles the queue
+func (p *Player) Shuffle() {
+	p.Lock()
+	rand.Shuffle(len(p.queue), func(i, j int) {
+		p.queue[i], p.queue[j] = p.queue[j], p.queue[i]
+	})
+	p.Unlock()
+}
+
+// SetRepeat sets the repeat mode
+func (p *Player) SetRepeat(mode RepeatMode) {
+	p.Lock()
+	p.repeat = mode
+	p.Unlock()
+}
+
+// Repeat returns the repeat mode
+func (p *Player) Repeat() RepeatMode {
+	p.Lock()
+	defer p.Unlock()
+	return p.repeat
+}
+
+// NowPlaying returns the track playing, or nil if nothing is
+func (p *Player) NowPlaying() *Track {
+	p.Lock()
+	defer p.Unlock()
+	return p.current
+}
+
+// NowPlayingMetadata returns the metadata of the track playing, available once it has started sending audio.
+// nil if nothing is playing or the source has no metadata
+func (p *Player) NowPlayingMetadata() *Metadata {
+	p.Lock()
+	defer p.Unlock()
+	return p.metadata
+}
+
+// Position returns how far into the current track playback is
+func (p *Player) Position() time.Duration {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.source == nil {
+		return 0
+	}
+	return time.Duration(p.trackFrames) * p.source.FrameDuration()
+}
+
+// playerSource is the OpusReader for the streaming session, reading from the track playing
+// and moving on to the next one when it ends
+type playerSource struct {
+	p *Player
+}
+
+func (s *playerSource) OpusFrame() ([]byte, error) {
+	p := s.p
+	for {
+		p.Lock()
+		if len(p.mixed) > 0 {
+			frame := p.mixed[0]
+			p.mixed = p.mixed[1:]
+			if p.source != nil {
+				p.trackFrames++
+			}
+			p.Unlock()
+			return frame, nil
+		}
+
+		if p.source == nil {
+			started, events := p.nextTrack()
+			p.Unlock()
+			p.emit(events...)
+			if !started {
+				return nil, io.EOF
+			}
+			continue
+		}
+		source := p.source
+		p.Unlock()
+
+		frame, err := source.OpusFrame()
+
+		p.Lock()
+		if err == nil && !p.skip {
+			first := p.readFrames == 0
+			if first {
+				p.metadata = sourceMetadata(source)
+			}
+			p.readFrames++
+
+			if overlap := p.crossfadeFrames(source); overlap > 0 {
+				// Hold back the last frames to mix with the next track
+				p.tail = append(p.tail, frame)
+				frame = nil
+				if len(p.tail) > overlap {
+					frame = p.tail[0]
+					p.tail = p.tail[1:]
+				}
+			}
+
+			if frame != nil {
+				p.trackFrames++
+			}
+			p.Unlock()
+
+			if first {
+				p.preloadNext()
+			}
+			if frame == nil {
+				continue
+			}
+			return frame, nil
+		}
+
+		if err == io.EOF && !p.skip && len(p.tail) > 0 {
+			p.crossfadeNext(err)
+			continue
+		}
+
+		p.tail = nil
+		event, ended := p.endTrack(err)
+		p.Unlock()
+		closeSource(ended)
+		p.emit(event)
+	}
+}
+
+func (s *playerSource) FrameDuration() time.Duration {
+	s.p.Lock()
+	defer s.p.Unlock()
+
+	if s.p.source == nil {
+		return 20 * time.Millisecond
+	}
+	return s.p.source.FrameDuration()
+}
@@ -0,0 +1,86 @@
+package dca
+
+import (
+	"bytes"
+	"io"
+)
+
+// EncodeJob is something to be encoded by an EncoderPool
+type EncodeJob struct {
+	Path    string         // The file/url/other to encode, only used if Reader is nil
+	Reader  io.Reader      // Data to encode
+	Options *EncodeOptions // Options to encode with, StdEncodeOptions if nil
+}
+
+// EncodeResult is the result of a job submitted to an EncoderPool
+type EncodeResult struct {
+	Job  EncodeJob
+	Data []byte // The encoded dca data
+	Err  error
+}
+
+// EncoderPool runs encode jobs on a bounded number of workers,
+// jobs over the limit are queued until a worker frees up
+type EncoderPool struct {
+	limiter *Limiter
+}
+
+// NewEncoderPool returns a new pool running at most workers jobs at the same time
+func NewEncoderPool(workers int) *EncoderPool {
+	return &EncoderPool{
+		limiter: NewLimiter(workers),
+	}
+}
+
+// Encode starts an encode session for the job, the session doesn't start encoding until a worker is free
+func (p *EncoderPool) Encode(job EncodeJob) (*EncodeSession, error) {
+	options := StdEncodeOptions
+	if job.Options != nil {
+		options = job.Options
+	}
+
+	// Copy so we can set the limiter without touching the callers options
+	poolOptions := *options
+	poolOptions.Limiter = p.limiter
+
+	if job.Reader != nil {
+		return EncodeMem(job.Reader, &poolOptions)
+	}
+	return EncodeFile(job.Path, &poolOptions)
+}
+
+// EncodeToMemory runs the job to completion and returns the encoded data
+func (p *EncoderPool) EncodeToMemory(job EncodeJob) ([]byte, error) {
+	session, err := p.Encode(job)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Cleanup()
+
+	var buf bytes.Buffer
+	_, err = io.Copy(&buf, session)
+	if err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Submit runs the job to completion in the background, the result is sent on the returned channel
+func (p *EncoderPool) Submit(job EncodeJob) <-chan *EncodeResult {
+	result := make(chan *EncodeResult, 1)
+	go func() {
+		data, err := p.EncodeToMemory(job)
+		result <- &EncodeResult{
+			Job:  job,
+			Data: data,
+			Err:  err,
+		}
+	}()
+	return result
+}
+
+// Running returns the number of jobs currently being encoded
+func (p *EncoderPool) Running() int {
+	return p.limiter.Running()
+}
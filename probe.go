@@ -0,0 +1,83 @@
+package dca
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+)
+
+// Probe runs ffprobe on the file/url/other in path, returning information about its format and streams
+func Probe(ctx context.Context, path string) (*FFprobeMetadata, error) {
+	return probe(ctx, runner(), FFprobePath, path, nil)
+}
+
+// ProbeReader is the same as Probe but reads the input from r,
+// note that some formats can't be probed properly without seeking
+func ProbeReader(ctx context.Context, r io.Reader) (*FFprobeMetadata, error) {
+	return probe(ctx, runner(), FFprobePath, "pipe:0", r)
+}
+
+func probe(ctx context.Context, runner CommandRunner, ffprobePath, path string, stdin io.Reader) (*FFprobeMetadata, error) {
+	if _, err := runner.LookPath(ffprobePath); err != nil {
+		return nil, ErrFFprobeNotFound
+	}
+
+	var cmdBuf bytes.Buffer
+	ffprobe := runner.CommandContext(ctx, ffprobePath, "-v", "quiet", "-print_format", "json", "-show_format", "-show_streams", "-show_chapters", path)
+	ffprobe.Stdout = &cmdBuf
+	ffprobe.Stdin = stdin
+
+	err := ffprobe.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	var ffprobeData *FFprobeMetadata
+	err = json.Unmarshal(cmdBuf.Bytes(), &ffprobeData)
+	if err != nil {
+		return nil, err
+	}
+
+	if ffprobeData == nil {
+		ffprobeData = &FFprobeMetadata{}
+	}
+
+	if ffprobeData.Format == nil {
+		ffprobeData.Format = &FFprobeFormat{}
+	}
+
+	if ffprobeData.Format.Tags == nil {
+		ffprobeData.Format.Tags = &FFprobeTags{}
+	}
+
+	return ffprobeData, nil
+}
+
+// chapterMetadata converts the chapters to the format stored in the metadata frame
+func (m *FFprobeMetadata) chapterMetadata() []*ChapterMetadata {
+	if len(m.Chapters) == 0 {
+		return nil
+	}
+
+	chapters := make([]*ChapterMetadata, len(m.Chapters))
+	for i, c := range m.Chapters {
+		chapters[i] = &ChapterMetadata{
+			Title: c.Tags["title"],
+			Start: parseProbeDuration(c.StartTime).Seconds(),
+			End:   parseProbeDuration(c.EndTime).Seconds(),
+		}
+	}
+	return chapters
+}
+
+// AudioStreams returns the audio streams, in the order AudioStreamIndex refers to them
+func (m *FFprobeMetadata) AudioStreams() []*FFprobeStream {
+	var streams []*FFprobeStream
+	for _, s := range m.Streams {
+		if s.CodecType == "audio" {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
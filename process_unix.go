@@ -0,0 +1,24 @@
+//go:build !windows
+// +build !windows
+
+package dca
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// prepareCommand sets up the command so that it can be terminated gracefully,
+// the priority is set after starting it with setProcessPriority
+func prepareCommand(cmd *exec.Cmd, priority int) {}
+
+// setProcessPriority sets the nice level of the process
+func setProcessPriority(p *os.Process, priority int) error {
+	return syscall.Setpriority(syscall.PRIO_PROCESS, p.Pid, priority)
+}
+
+// terminateProcess asks the process to exit
+func terminateProcess(p *os.Process) error {
+	return p.Signal(syscall.SIGTERM)
+}
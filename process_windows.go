@@ -0,0 +1,55 @@
+//go:build windows
+// +build windows
+
+package dca
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var procGenerateConsoleCtrlEvent = syscall.NewLazyDLL("kernel32.dll").NewProc("GenerateConsoleCtrlEvent")
+
+// Process priority classes, https://learn.microsoft.com/en-us/windows/win32/procthread/scheduling-priorities
+const (
+	idlePriorityClass        = 0x00000040
+	belowNormalPriorityClass = 0x00004000
+	aboveNormalPriorityClass = 0x00008000
+	highPriorityClass        = 0x00000080
+)
+
+// prepareCommand sets up the command so that it can be terminated gracefully,
+// on windows it needs its own process group to receive a CTRL_BREAK_EVENT.
+// The nice level priority is mapped to a priority class.
+func prepareCommand(cmd *exec.Cmd, priority int) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.CreationFlags |= syscall.CREATE_NEW_PROCESS_GROUP
+
+	switch {
+	case priority >= 15:
+		cmd.SysProcAttr.CreationFlags |= idlePriorityClass
+	case priority > 0:
+		cmd.SysProcAttr.CreationFlags |= belowNormalPriorityClass
+	case priority <= -15:
+		cmd.SysProcAttr.CreationFlags |= highPriorityClass
+	case priority < 0:
+		cmd.SysProcAttr.CreationFlags |= aboveNormalPriorityClass
+	}
+}
+
+// setProcessPriority does nothing on windows, the priority class is set when creating the process in prepareCommand
+func setProcessPriority(p *os.Process, priority int) error {
+	return nil
+}
+
+// terminateProcess asks the process to exit by sending it a CTRL_BREAK_EVENT
+func terminateProcess(p *os.Process) error {
+	r, _, err := procGenerateConsoleCtrlEvent.Call(syscall.CTRL_BREAK_EVENT, uintptr(p.Pid))
+	if r == 0 {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package dca
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	"layeh.com/gopus"
+)
+
+// Rechunker is an OpusReader that converts the frames of another one to a different frame duration,
+// by decoding and encoding them again. For example to serve files cached with 20ms frames to something expecting 60ms.
+type Rechunker struct {
+	source        OpusReader
+	decoder       *gopus.Decoder
+	encoder       *gopus.Encoder
+	channels      int
+	frameDuration time.Duration
+	frameSize     int // Samples per channel in a frame
+
+	pending []int16
+	eof     bool
+}
+
+// NewRechunker returns a Rechunker reading from source and encoding frames of frameDuration with bitrate (in kb/s).
+// frameDuration has to be one of 2.5, 5, 10, 20, 40 or 60ms. The number of channels is taken from the metadata of source
+// if available, stereo otherwise. The last frame is padded with silence.
+func NewRechunker(source OpusReader, frameDuration time.Duration, bitrate int) (*Rechunker, error) {
+	switch frameDuration {
+	case 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond, 20 * time.Millisecond, 40 * time.Millisecond, 60 * time.Millisecond:
+	default:
+		return nil, fmt.Errorf("%w: frame duration has to be one of 2.5, 5, 10, 20, 40 or 60ms", ErrInvalidOptions)
+	}
+
+	channels := sourceChannels(source)
+	decoder, err := gopus.NewDecoder(48000, channels)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder, err := gopus.NewEncoder(48000, channels, gopus.Audio)
+	if err != nil {
+		return nil, err
+	}
+	encoder.SetBitrate(bitrate * 1000)
+
+	return &Rechunker{
+		source:        source,
+		decoder:       decoder,
+		encoder:       encoder,
+		channels:      channels,
+		frameDuration: frameDuration,
+		frameSize:     int(frameDuration * 48000 / time.Second),
+	}, nil
+}
+
+// OpusFrame implements OpusReader
+func (r *Rechunker) OpusFrame() ([]byte, error) {
+	n := r.frameSize * r.channels
+	for len(r.pending) < n && !r.eof {
+		frame, err := r.source.OpusFrame()
+		if err == io.EOF {
+			r.eof = true
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		pcm, err := r.decoder.Decode(frame, maxOpusFrameSize, false)
+		if err != nil {
+			return nil, err
+		}
+		r.pending = append(r.pending, pcm...)
+	}
+
+	if len(r.pending) == 0 {
+		return nil, io.EOF
+	}
+
+	// Pad the last frame
+	for len(r.pending) < n {
+		r.pending = append(r.pending, 0)
+	}
+
+	frame, err := r.encoder.Encode(r.pending[:n], r.frameSize, n*2)
+	if err != nil {
+		return nil, err
+	}
+
+	r.pending = append(r.pending[:0], r.pending[n:]...)
+	return frame, nil
+}
+
+// FrameDuration implements OpusReader
+func (r *Rechunker) FrameDuration() time.Duration {
+	return r.frameDuration
+}
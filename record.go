@@ -0,0 +1,238 @@
+package dca
+
+import (
+	"io"
+	"sync"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// MaxRecordGap is the longest gap in the received audio filled with silence by a Recorder,
+// longer gaps (a user being quiet for a long time) are shortened to it
+var MaxRecordGap = 5 * time.Minute
+
+// Samples per channel in a 20ms frame at 48khz, the RTP timestamp of received packets increases by this much per frame
+const recordFrameSamples = 960
+
+// Recorder records the opus audio received on a voice connection to dca files, one per user.
+// Gaps in the audio (packet loss or the user not speaking) are filled with silence, so the files have the same timing as the call.
+// With SetAlignStart the files also start at the same time, so they can be mixed into one with MixTo.
+//
+// The voice connection must not be deafened, and nothing else should be reading from vc.OpusRecv.
+type Recorder struct {
+	sync.Mutex
+
+	vc     *discordgo.VoiceConnection
+	create func(userID string, ssrc uint32) (io.WriteCloser, error)
+
+	alignStart bool
+	started    time.Time
+
+	users   map[uint32]string
+	streams map[uint32]*recordStream
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+	err      error // First error that occured
+}
+
+// recordStream is the audio of a single ssrc being recorded
+type recordStream struct {
+	w         io.WriteCloser
+	writer    *Writer
+	sequence  uint16
+	timestamp uint32
+	failed    bool
+}
+
+// NewRecorder starts recording the audio received on vc, create is called the first time audio is received from someone
+// to create the file (or other writer) it's written to. The user id is empty if discord hasn't told us who it is yet.
+// The writers are closed when the recording is stopped with Stop.
+func NewRecorder(vc *discordgo.VoiceConnection, create func(userID string, ssrc uint32) (io.WriteCloser, error)) *Recorder {
+	r := &Recorder{
+		vc:      vc,
+		create:  create,
+		started: time.Now(),
+		users:   make(map[uint32]string),
+		streams: make(map[uint32]*recordStream),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	vc.AddHandler(func(vc *discordgo.VoiceConnection, vs *discordgo.VoiceSpeakingUpdate) {
+		r.Lock()
+		r.users[uint32(vs.SSRC)] = vs.UserID
+		r.Unlock()
+	})
+
+	go r.run()
+	return r
+}
+
+// SetAlignStart sets wether the files of users start with silence from when the recording started,
+// instead of when they first spoke. Only affects users that haven't spoken yet.
+func (r *Recorder) SetAlignStart(align bool) {
+	r.Lock()
+	r.alignStart = align
+	r.Unlock()
+}
+
+func (r *Recorder) run() {
+	defer func() {
+		r.closeStreams()
+		close(r.done)
+	}()
+
+	for {
+		select {
+		case <-r.stop:
+			return
+		case packet, ok := <-r.vc.OpusRecv:
+			if !ok {
+				return
+			}
+			r.handlePacket(packet)
+		}
+	}
+}
+
+// handlePacket writes a received packet to the stream of its ssrc, filling in gaps with silence
+func (r *Recorder) handlePacket(packet *discordgo.Packet) {
+	r.Lock()
+	defer r.Unlock()
+
+	stream, ok := r.streams[packet.SSRC]
+	if !ok {
+		stream = r.newStream(packet)
+		r.streams[packet.SSRC] = stream
+		if stream.failed {
+			return
+		}
+
+		if r.alignStart {
+			r.writeSilence(stream, int(time.Since(r.started)/(20*time.Millisecond)))
+		}
+		r.writeFrame(stream, packet.Opus)
+		return
+	}
+
+	if stream.failed {
+		return
+	}
+
+	// Drop duplicated and out of order packets
+	if int16(packet.Sequence-stream.sequence) <= 0 {
+		return
+	}
+
+	// Lost packets and periods of not speaking show up as a jump in the timestamp
+	gap := time.Duration(int32(packet.Timestamp-stream.timestamp)/recordFrameSamples-1) * 20 * time.Millisecond
+	if gap > MaxRecordGap {
+		gap = MaxRecordGap
+	}
+	r.writeSilence(stream, int(gap/(20*time.Millisecond)))
+
+	stream.sequence = packet.Sequence
+	stream.timestamp = packet.Timestamp
+	r.writeFrame(stream, packet.Opus)
+}
+
+// newStream creates the writer for the ssrc of packet, r has to be locked
+func (r *Recorder) newStream(packet *discordgo.Packet) *recordStream {
+	stream := &recordStream{
+		sequence:  packet.Sequence,
+		timestamp: packet.Timestamp,
+	}
+
+	userID := r.users[packet.SSRC]
+	w, err := r.create(userID, packet.SSRC)
+	if err != nil {
+		r.streamFailed(stream, err)
+		return stream
+	}
+	stream.w = w
+
+	extra := ExtraMetadata{}
+	extra.Set("user_id", userID)
+	extra.Set("ssrc", packet.SSRC)
+
+	stream.writer, err = NewWriter(w, &Metadata{
+		Dca: dcaMetadata(),
+		Opus: &OpusMetadata{
+			SampleRate: 48000,
+			FrameSize:  recordFrameSamples * 2,
+			Channels:   2,
+		},
+		SongInfo: &SongMetadata{},
+		Origin: &OriginMetadata{
+			Source:   "discord",
+			Channels: 2,
+			Encoding: "opus",
+		},
+		Extra: &extra,
+	})
+	if err != nil {
+		r.streamFailed(stream, err)
+	}
+	return stream
+}
+
+// writeSilence writes n silence frames, r has to be locked
+func (r *Recorder) writeSilence(stream *recordStream, n int) {
+	for i := 0; i < n && !stream.failed; i++ {
+		r.writeFrame(stream, SilenceFrame)
+	}
+}
+
+// writeFrame writes a frame, r has to be locked
+func (r *Recorder) writeFrame(stream *recordStream, frame []byte) {
+	err := stream.writer.WriteFrame(frame)
+	if err != nil {
+		r.streamFailed(stream, err)
+	}
+}
+
+// streamFailed stops recording the stream after an error, r has to be locked
+func (r *Recorder) streamFailed(stream *recordStream, err error) {
+	logger().Error("Failed recording voice, stopping recording of user", "err", err)
+	stream.failed = true
+	if r.err == nil {
+		r.err = err
+	}
+}
+
+// closeStreams closes all the writers
+func (r *Recorder) closeStreams() {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, stream := range r.streams {
+		if stream.w == nil {
+			continue
+		}
+
+		err := stream.w.Close()
+		if err != nil && r.err == nil {
+			r.err = err
+		}
+	}
+}
+
+// Stop stops recording and closes the writers, returning the first error that occured while recording if any
+func (r *Recorder) Stop() error {
+	r.stopOnce.Do(func() {
+		close(r.stop)
+	})
+	<-r.done
+
+	r.Lock()
+	defer r.Unlock()
+	return r.err
+}
+
+// Done returns a channel that's closed when the recording stops, either with Stop or because vc.OpusRecv was closed
+func (r *Recorder) Done() <-chan struct{} {
+	return r.done
+}
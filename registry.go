@@ -0,0 +1,66 @@
+package dca
+
+import (
+	"context"
+	"sync"
+)
+
+// registry keeps track of all running encode sessions, so they can be stopped with Shutdown
+var registry = struct {
+	sync.Mutex
+	sessions map[*EncodeSession]struct{}
+}{sessions: make(map[*EncodeSession]struct{})}
+
+func registerSession(e *EncodeSession) {
+	registry.Lock()
+	registry.sessions[e] = struct{}{}
+	registry.Unlock()
+}
+
+func unregisterSession(e *EncodeSession) {
+	registry.Lock()
+	delete(registry.sessions, e)
+	registry.Unlock()
+}
+
+// RunningSessions returns all encode sessions that has not finished yet
+func RunningSessions() []*EncodeSession {
+	registry.Lock()
+	sessions := make([]*EncodeSession, 0, len(registry.sessions))
+	for s := range registry.sessions {
+		sessions = append(sessions, s)
+	}
+	registry.Unlock()
+	return sessions
+}
+
+// Shutdown stops and cleans up all running encode sessions, throwing away any unread frames.
+// If ctx is done before all of them has exited, the remaining ffmpeg processes are killed and ctx.Err() is returned
+func Shutdown(ctx context.Context) error {
+	sessions := RunningSessions()
+
+	done := make(chan struct{})
+	go func() {
+		var wg sync.WaitGroup
+		wg.Add(len(sessions))
+		for _, s := range sessions {
+			go func(s *EncodeSession) {
+				s.Cleanup()
+				wg.Done()
+			}(s)
+		}
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	for _, s := range sessions {
+		s.kill()
+	}
+	return ctx.Err()
+}
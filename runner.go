@@ -0,0 +1,47 @@
+package dca
+
+import (
+	"context"
+	"os/exec"
+)
+
+// CommandRunner creates the ffmpeg and ffprobe commands, replace it to run something else in their place,
+// for example a fake ffmpeg printing scripted output in tests.
+type CommandRunner interface {
+	// LookPath returns the path of an executable, see exec.LookPath
+	LookPath(file string) (string, error)
+	// CommandContext returns a command that hasn't been started yet, see exec.CommandContext
+	CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd
+}
+
+// DefaultCommandRunner is used when no CommandRunner is set in the options
+var DefaultCommandRunner CommandRunner = ExecRunner{}
+
+// ExecRunner is a CommandRunner running the executables with os/exec
+type ExecRunner struct{}
+
+// LookPath implements CommandRunner
+func (ExecRunner) LookPath(file string) (string, error) {
+	return exec.LookPath(file)
+}
+
+// CommandContext implements CommandRunner
+func (ExecRunner) CommandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	return exec.CommandContext(ctx, name, args...)
+}
+
+// runner returns DefaultCommandRunner, or ExecRunner if it's nil
+func runner() CommandRunner {
+	if DefaultCommandRunner == nil {
+		return ExecRunner{}
+	}
+	return DefaultCommandRunner
+}
+
+// runner returns the CommandRunner to use for sessions with the options
+func (e EncodeOptions) runner() CommandRunner {
+	if e.CommandRunner != nil {
+		return e.CommandRunner
+	}
+	return runner()
+}
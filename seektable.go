@@ -0,0 +1,106 @@
+package dca
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	ErrBadSeekTable = errors.New("Seek table doesn't match the file")
+)
+
+// SeekTable holds the byte offsets of frames at a fixed interval,
+// allowing a Decoder to seek without scanning through the file.
+//
+// It can be stored alongside the file (see WriteTo and ReadSeekTable)
+// or embedded in the metadata.
+type SeekTable struct {
+	// Number of frames between each offset
+	Interval int `json:"interval"`
+	// Offset of every Interval'th frame, relative to the first frame (after the metadata)
+	Offsets []int64 `json:"offsets"`
+	// Total number of frames
+	Frames int `json:"frames"`
+}
+
+// BuildSeekTable scans through the dca file in r and builds a seek table with an offset every interval
+func BuildSeekTable(r io.Reader, interval time.Duration) (*SeekTable, error) {
+	decoder := NewDecoder(r)
+	err := decoder.checkMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	frameInterval := int(interval / decoder.FrameDuration())
+	if frameInterval < 1 {
+		frameInterval = 1
+	}
+
+	table := &SeekTable{
+		Interval: frameInterval,
+	}
+
+	for {
+		if decoder.frameNum%frameInterval == 0 {
+			table.Offsets = append(table.Offsets, decoder.pos-decoder.dataStart)
+		}
+
+		err = decoder.skipFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	table.Frames = decoder.frameNum
+
+	// The last one was recorded at the end of the file
+	if table.Frames%frameInterval == 0 {
+		table.Offsets = table.Offsets[:len(table.Offsets)-1]
+	}
+
+	return table, nil
+}
+
+// ReadSeekTable reads a seek table written with WriteTo
+func ReadSeekTable(r io.Reader) (*SeekTable, error) {
+	var table *SeekTable
+	err := json.NewDecoder(r).Decode(&table)
+	if err != nil {
+		return nil, err
+	}
+
+	if table == nil || table.Interval < 1 {
+		return nil, ErrBadSeekTable
+	}
+
+	return table, nil
+}
+
+// WriteTo writes the seek table to w, implementing io.WriterTo
+func (t *SeekTable) WriteTo(w io.Writer) (int64, error) {
+	data, err := json.Marshal(t)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := w.Write(data)
+	return int64(n), err
+}
+
+// lookup returns the closest frame at or before frame that's in the table, and its offset
+func (t *SeekTable) lookup(frame int) (tableFrame int, offset int64, ok bool) {
+	if t.Interval < 1 || len(t.Offsets) == 0 || frame < 0 {
+		return 0, 0, false
+	}
+
+	i := frame / t.Interval
+	if i >= len(t.Offsets) {
+		i = len(t.Offsets) - 1
+	}
+
+	return i * t.Interval, t.Offsets[i], true
+}
@@ -0,0 +1,172 @@
+package dca
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"time"
+)
+
+// EncodeFileSegmented encodes the file in path by splitting it into segments that are encoded in parallel
+// by up to workers ffmpeg processes, the frames are then written to w in order.
+// This is a lot faster than EncodeFile for long files on machines with many cores.
+//
+// The segments are encoded separately, so there may be a tiny glitch where they meet.
+// They're written to temporary files in options.SpillDir until it's their turn.
+// If the duration of the file can't be determined it's encoded as a whole.
+func EncodeFileSegmented(w io.Writer, path string, options *EncodeOptions, workers int) error {
+	err := options.Validate()
+	if err != nil {
+		return err
+	}
+
+	err = options.checkExecutables(true)
+	if err != nil {
+		return err
+	}
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	probeData, err := probeFile(path, options)
+	if err != nil {
+		return err
+	}
+
+	// Segments are in whole seconds so they're always a multiple of the frame duration
+	duration := probeData.Format.ParsedDuration() - time.Duration(options.StartTime)*time.Second
+	segmentLen := int((duration + time.Duration(workers)*time.Second - 1) / time.Duration(workers) / time.Second)
+	if duration <= 0 || workers == 1 || segmentLen < 1 {
+		return encodeFileTo(w, path, options)
+	}
+
+	// Resolve the options once, so the metadata and every segment agree on the format
+	session := newEncodeSession(options)
+	session.filePath = path
+	session.probeData = probeData
+	if options.AutoChannels || options.AutoFrameRate {
+		session.resolveAutoFormat()
+	}
+	if options.CopyOpus {
+		session.copyStream = session.opusCopyStream()
+	}
+
+	resolved := *session.options
+	resolved.AutoChannels = false
+	resolved.AutoFrameRate = false
+	resolved.CopyOpus = session.copyStream != nil
+
+	var metaFrame *Frame
+	if !options.RawOutput {
+		metaFrame = session.metadataFrame()
+	}
+
+	if metaFrame != nil {
+		_, err = w.Write(metaFrame.data)
+		if err != nil {
+			return err
+		}
+	}
+
+	type segmentResult struct {
+		file *os.File
+		err  error
+	}
+
+	// Start them all right away, the last segment gets whatever is left
+	sessions := make([]*EncodeSession, 0, workers)
+	results := make([]chan segmentResult, 0, workers)
+	for start := 0; time.Duration(start)*time.Second < duration; start += segmentLen {
+		segmentOptions := resolved
+		segmentOptions.RawOutput = true
+		segmentOptions.StartTime = 0
+		segmentOptions.ExtraInputArgs = append([]string{"-ss", strconv.Itoa(options.StartTime + start)}, options.ExtraInputArgs...)
+		if time.Duration(start+segmentLen)*time.Second < duration {
+			segmentOptions.ExtraOutputArgs = append([]string{"-t", strconv.Itoa(segmentLen)}, options.ExtraOutputArgs...)
+		}
+
+		segment, err := EncodeFile(path, &segmentOptions)
+		if err != nil {
+			for _, s := range sessions {
+				s.Stop()
+			}
+			for _, result := range results {
+				if r := <-result; r.file != nil {
+					removeTempFile(r.file)
+				}
+			}
+			return err
+		}
+		sessions = append(sessions, segment)
+
+		result := make(chan segmentResult, 1)
+		results = append(results, result)
+		go func() {
+			file, err := writeSegment(segment, options.SpillDir)
+			result <- segmentResult{file, err}
+		}()
+	}
+
+	for i, result := range results {
+		r := <-result
+		if r.err == nil {
+			_, r.err = io.Copy(w, r.file)
+			removeTempFile(r.file)
+		}
+
+		if r.err != nil {
+			// No point in finishing the remaining ones
+			for _, s := range sessions[i+1:] {
+				s.Stop()
+			}
+			for _, remaining := range results[i+1:] {
+				if r := <-remaining; r.file != nil {
+					removeTempFile(r.file)
+				}
+			}
+			return r.err
+		}
+	}
+
+	return nil
+}
+
+// writeSegment writes the output of session to a temporary file in dir, returning it seeked to the start
+func writeSegment(session *EncodeSession, dir string) (*os.File, error) {
+	defer session.Cleanup()
+
+	file, err := ioutil.TempFile(dir, "dca-segment")
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = io.Copy(file, session)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		removeTempFile(file)
+		return nil, err
+	}
+	return file, nil
+}
+
+// removeTempFile closes and removes a temporary file
+func removeTempFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+// encodeFileTo encodes path and writes the output to w
+func encodeFileTo(w io.Writer, path string, options *EncodeOptions) error {
+	session, err := EncodeFile(path, options)
+	if err != nil {
+		return err
+	}
+	defer session.Cleanup()
+
+	_, err = io.Copy(w, session)
+	return err
+}
@@ -0,0 +1,79 @@
+package dca
+
+import (
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// OpusSender is where a StreamingSession sends its opus frames, implement it to stream
+// to something other than a discordgo voice connection.
+type OpusSender interface {
+	// SendOpus sends a frame, returning ErrVoiceConnClosed (or another error) if it couldn't be sent
+	SendOpus(frame []byte) error
+
+	// Ready returns true if frames can be sent
+	Ready() bool
+}
+
+// timeoutSender is implemented by senders that support a per call send timeout, used by StreamingSession.SetSendTimeout
+type timeoutSender interface {
+	SendOpusTimeout(frame []byte, timeout time.Duration) error
+}
+
+// speakingSender is implemented by senders with a speaking state, used by StreamingSession.SetManageSpeaking
+type speakingSender interface {
+	Speaking(speaking bool) error
+}
+
+// DiscordSender sends opus frames to a discordgo voice connection
+type DiscordSender struct {
+	VC *discordgo.VoiceConnection
+
+	// How long to wait for the voice connection to accept a frame before giving up.
+	// When it times out ErrVoiceConnClosed is returned if the voice connection isn't ready, ErrSendTimeout otherwise.
+	Timeout time.Duration
+}
+
+// NewDiscordSender returns a DiscordSender for vc with a timeout of 1 second
+func NewDiscordSender(vc *discordgo.VoiceConnection) *DiscordSender {
+	return &DiscordSender{
+		VC:      vc,
+		Timeout: time.Second,
+	}
+}
+
+// SendOpus implements OpusSender
+func (d *DiscordSender) SendOpus(frame []byte) error {
+	return d.SendOpusTimeout(frame, d.Timeout)
+}
+
+// SendOpusTimeout is the same as SendOpus, but with a timeout other than d.Timeout
+func (d *DiscordSender) SendOpusTimeout(frame []byte, timeout time.Duration) error {
+	timeOut := time.NewTimer(timeout)
+	defer timeOut.Stop()
+
+	select {
+	case <-timeOut.C:
+		if !d.Ready() {
+			return ErrVoiceConnClosed
+		}
+		return ErrSendTimeout
+	case d.VC.OpusSend <- frame:
+	}
+
+	return nil
+}
+
+// Ready implements OpusSender
+func (d *DiscordSender) Ready() bool {
+	d.VC.RLock()
+	ready := d.VC.Ready
+	d.VC.RUnlock()
+	return ready
+}
+
+// Speaking sets the speaking state of the voice connection
+func (d *DiscordSender) Speaking(speaking bool) error {
+	return d.VC.Speaking(speaking)
+}
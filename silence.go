@@ -0,0 +1,81 @@
+package dca
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrSilence = errors.New("Stopped after a long silence")
+)
+
+// SilenceAction is what a SilenceDetector does when the audio has been silent for too long
+type SilenceAction int
+
+const (
+	SilenceStop SilenceAction = iota // End with ErrSilence
+	SilenceTrim                      // Skip the silent frames until the audio comes back
+)
+
+// SilenceDetector is an OpusReader that detects prolonged silence in the frames of another one,
+// so for example a live radio stream that goes quiet doesn't keep playing forever
+type SilenceDetector struct {
+	meter *PCMTap
+	level Level
+
+	threshold   float64
+	minDuration time.Duration
+	action      SilenceAction
+	silentFor   time.Duration
+}
+
+// NewSilenceDetector returns a SilenceDetector reading from source. Frames with a peak level below threshold (in dBFS, ex -50)
+// are silent, once they've been silent for longer than minDuration action is taken.
+func NewSilenceDetector(source OpusReader, threshold float64, minDuration time.Duration, action SilenceAction) (*SilenceDetector, error) {
+	d := &SilenceDetector{
+		threshold:   threshold,
+		minDuration: minDuration,
+		action:      action,
+	}
+
+	meter, err := NewMeter(source, func(level Level) {
+		d.level = level
+	})
+	if err != nil {
+		return nil, err
+	}
+	d.meter = meter
+
+	return d, nil
+}
+
+// OpusFrame implements OpusReader
+func (d *SilenceDetector) OpusFrame() ([]byte, error) {
+	for {
+		d.level = Level{Peak: 1}
+		frame, err := d.meter.OpusFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		if d.level.PeakDB() >= d.threshold {
+			d.silentFor = 0
+			return frame, nil
+		}
+
+		d.silentFor += d.FrameDuration()
+		if d.silentFor <= d.minDuration {
+			return frame, nil
+		}
+
+		if d.action == SilenceStop {
+			return nil, ErrSilence
+		}
+		// Trimmed
+	}
+}
+
+// FrameDuration implements OpusReader
+func (d *SilenceDetector) FrameDuration() time.Duration {
+	return d.meter.FrameDuration()
+}
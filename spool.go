@@ -0,0 +1,150 @@
+package dca
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+// frameSpool moves frames to a temporary file once the frame channel is full,
+// and feeds them back into the channel in order as it's being read from.
+// This way ffmpeg never has to wait for the frames to be read.
+type frameSpool struct {
+	sync.Mutex
+	cond *sync.Cond
+
+	file     *os.File
+	frames   chan *Frame
+	writeOff int64
+	readOff  int64
+
+	// Number of frames in the file that has not been sent on the channel yet
+	pending int
+	closed  bool
+	done    chan struct{}
+	err     error
+
+	logger Logger
+}
+
+func newFrameSpool(dir string, frames chan *Frame, logger Logger) (*frameSpool, error) {
+	file, err := ioutil.TempFile(dir, "dca-spool")
+	if err != nil {
+		return nil, err
+	}
+
+	spool := &frameSpool{
+		file:   file,
+		frames: frames,
+		done:   make(chan struct{}),
+		logger: logger,
+	}
+	spool.cond = sync.NewCond(spool)
+
+	go spool.feed()
+	return spool, nil
+}
+
+// push sends the frame directly if there's room in the channel and nothing on disk,
+// otherwise it's appended to the file
+func (s *frameSpool) push(f *Frame) error {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.pending == 0 {
+		select {
+		case s.frames <- f:
+			return nil
+		default:
+		}
+	}
+
+	_, err := s.file.WriteAt(f.data, s.writeOff)
+	if err != nil {
+		return err
+	}
+
+	s.writeOff += int64(len(f.data))
+	s.pending++
+	putFrame(f)
+
+	s.cond.Signal()
+	return nil
+}
+
+// feed moves frames from the file to the channel
+func (s *frameSpool) feed() {
+	defer close(s.done)
+
+	for {
+		s.Lock()
+		for s.pending == 0 && !s.closed {
+			s.cond.Wait()
+		}
+
+		if s.pending == 0 {
+			s.Unlock()
+			return
+		}
+		off := s.readOff
+		s.Unlock()
+
+		f, err := s.readFrame(off)
+		if err != nil {
+			s.Lock()
+			s.err = err
+			s.Unlock()
+			s.logger.Error("Error reading spooled frame", "err", err)
+			return
+		}
+
+		// pending is decremented after the send so that push can't overtake this frame
+		s.frames <- f
+
+		s.Lock()
+		s.readOff += int64(len(f.data))
+		s.pending--
+		s.Unlock()
+	}
+}
+
+func (s *frameSpool) readFrame(off int64) (*Frame, error) {
+	var sizeBuf [2]byte
+	_, err := s.file.ReadAt(sizeBuf[:], off)
+	if err != nil {
+		return nil, err
+	}
+
+	size := int(binary.LittleEndian.Uint16(sizeBuf[:]))
+	f := framePool.Get().(*Frame)
+	f.metaData = false
+	if cap(f.data) < size+2 {
+		f.data = make([]byte, size+2)
+	}
+	f.data = f.data[:size+2]
+
+	_, err = s.file.ReadAt(f.data, off)
+	if err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// close waits for all the spooled frames to be sent on the channel and removes the file
+func (s *frameSpool) close() error {
+	s.Lock()
+	s.closed = true
+	s.cond.Signal()
+	s.Unlock()
+
+	<-s.done
+
+	s.file.Close()
+	os.Remove(s.file.Name())
+
+	s.Lock()
+	defer s.Unlock()
+	return s.err
+}
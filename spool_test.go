@@ -0,0 +1,40 @@
+package dca
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+func TestFrameSpool(t *testing.T) {
+	frames := make(chan *Frame, 2)
+	spool, err := newFrameSpool("", frames, logger())
+	if err != nil {
+		t.Fatal("Failed creating spool", err)
+	}
+
+	// Way more than fits in the channel, push should never block
+	for i := 0; i < 100; i++ {
+		f := &Frame{data: []byte{1, 0, byte(i)}}
+		err = spool.push(f)
+		if err != nil {
+			t.Fatal("Failed pushing frame", err)
+		}
+	}
+
+	go func() {
+		spool.close()
+		close(frames)
+	}()
+
+	i := 0
+	for f := range frames {
+		if binary.LittleEndian.Uint16(f.data) != 1 || f.data[2] != byte(i) {
+			t.Fatalf("Incorrect frame %d (got %v)", i, f.data)
+		}
+		i++
+	}
+
+	if i != 100 {
+		t.Errorf("Incorrect number of frames (got %d expected %d)", i, 100)
+	}
+}
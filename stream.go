@@ -1,6 +1,7 @@
 package dca
 
 import (
+	"context"
 	"errors"
 	"github.com/bwmarrin/discordgo"
 	"io"
@@ -9,9 +10,52 @@ import (
 )
 
 var (
-	ErrVoiceConnClosed = errors.New("Voice connection closed")
+	ErrVoiceConnClosed   = errors.New("Voice connection closed")
+	ErrSendTimeout       = errors.New("Timed out sending a frame, the voice connection is congested")
+	ErrStreamFinished    = errors.New("Stream has finished")
+	ErrSourceNotSeekable = errors.New("The source doesn't support seeking")
+	ErrNotEncodeSession  = errors.New("The source isn't an encode session")
+	ErrSourceChanged     = errors.New("The source was changed while switching encode options")
 )
 
+// SilenceFrame is an opus frame of silence, discord recommends sending 5 of them when the audio stops
+// to avoid unintended interpolation with the next audio
+var SilenceFrame = []byte{0xF8, 0xFF, 0xFE}
+
+// Number of silence frames sent when the audio stops
+const silenceFrames = 5
+
+// Number of times a frame is sent again after ErrSendTimeout before giving up
+const sendRetries = 3
+
+// SpeakingDebounce is how long a paused stream waits before setting speaking to false, with SetManageSpeaking enabled.
+// Resuming within it doesn't change the speaking state at all.
+var SpeakingDebounce = 250 * time.Millisecond
+
+// StreamState is the state of a StreamingSession
+type StreamState int
+
+const (
+	StreamIdle     StreamState = iota // Not started sending yet
+	StreamPlaying                     // Sending frames
+	StreamPaused                      // Paused with SetPaused
+	StreamFinished                    // The source ended or an error occured, it can't be resumed
+)
+
+func (s StreamState) String() string {
+	switch s {
+	case StreamIdle:
+		return "Idle"
+	case StreamPlaying:
+		return "Playing"
+	case StreamPaused:
+		return "Paused"
+	case StreamFinished:
+		return "Finished"
+	}
+	return "Unknown"
+}
+
 // StreamingSession provides an easy way to directly transmit opus audio
 // to discord from an encode session.
 type StreamingSession struct {
@@ -20,15 +64,82 @@ type StreamingSession struct {
 	// If this channel is not nil, an error will be sen when finished (or nil if no error)
 	done chan error
 
-	source OpusReader
-	vc     *discordgo.VoiceConnection
+	// Streaming stops when it's done
+	ctx context.Context
 
-	paused     bool
+	// Closed when the stream finishes
+	finished chan struct{}
+
+	// Closed to stop the goroutine started by SetPositionUpdates
+	positionStop chan struct{}
+
+	// Held while reading from or seeking the source
+	sourceMu sync.Mutex
+	source   OpusReader
+	sender   OpusSender
+	pending  []byte // Already read from the source by SwitchEncodeOptions, sent before reading again
+
+	state      StreamState
+	wantPaused bool          // Set by SetPaused, the control goroutine moves to the paused state when it sees it
+	wake       chan struct{} // Signaled by SetPaused to wake the control goroutine up when paused
 	framesSent int
 
-	finished bool
-	running  bool
-	err      error // If an error occured and we had to stop
+	err error // If an error occured and we had to stop
+
+	// Don't send silence frames when pausing or finishing
+	noSilence bool
+
+	// Rewind the source at EOF instead of finishing
+	loop bool
+
+	// Timeout for sending a frame, 0 to use the default of the sender
+	sendTimeout time.Duration
+
+	// If not nil, wait for the voice connection to come back when it closes
+	reconnect *ReconnectPolicy
+
+	// Set the speaking state of the sender when starting, pausing, resuming and finishing
+	manageSpeaking bool
+	speakingMu     sync.Mutex  // Held while changing the speaking state
+	speaking       bool        // The speaking state last set
+	speakingGen    int         // Incremented on every change, used to ignore outdated debounce timers
+	speakingTimer  *time.Timer // Debounce timer for setting speaking to false when paused
+
+	// Playback stats, AvgSendTime and Drift are calculated in Stats
+	stats        StreamStats
+	sends        int           // Number of calls to the sender
+	sendTime     time.Duration // Total time spent blocked sending frames
+	audioSent    time.Duration // Duration of the frames sent
+	playTime     time.Duration // Wall-clock time spent playing, not counting the current stretch
+	playingSince time.Time     // When the current stretch of playing started, zero if not playing
+}
+
+// StreamStats is playback stats of a StreamingSession, useful for diagnosing stutter
+type StreamStats struct {
+	FramesSent    int           // Frames sent, including silence frames
+	Timeouts      int           // Sends that timed out because the voice connection was congested, they're retried
+	FramesDropped int           // Frames that couldn't be sent at all, the stream stops when this happens
+	AvgSendTime   time.Duration // Average time spent blocked sending a frame
+	MaxSendTime   time.Duration // Longest time spent blocked sending a frame
+
+	// How far playback is behind the wall-clock time spent playing (not counting pauses),
+	// steadily growing drift means the voice connection can't keep up
+	Drift time.Duration
+}
+
+// ReconnectPolicy controls how a StreamingSession waits for the voice connection to be ready again
+// after it was closed, for example while the voice gateway is reconnecting
+type ReconnectPolicy struct {
+	Timeout    time.Duration // How long to wait before giving up and stopping with ErrVoiceConnClosed
+	MinBackoff time.Duration // Delay between the first checks, doubled after every check
+	MaxBackoff time.Duration // Max delay between checks
+}
+
+// DefaultReconnectPolicy waits up to 30 seconds, checking every 100ms to 2s
+var DefaultReconnectPolicy = &ReconnectPolicy{
+	Timeout:    30 * time.Second,
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
 }
 
 // Creates a new stream from an Opusreader.
@@ -36,78 +147,145 @@ type StreamingSession struct {
 // vc       : The voice connecion to stream to.
 // done     : If not nil, an error will be sent on it when completed.
 func NewStream(source OpusReader, vc *discordgo.VoiceConnection, done chan error) *StreamingSession {
+	return NewStreamTo(source, NewDiscordSender(vc), done)
+}
+
+// NewStreamTo is the same as NewStream, but sends the frames to sender instead of a discordgo voice connection
+func NewStreamTo(source OpusReader, sender OpusSender, done chan error) *StreamingSession {
+	return NewStreamContext(context.Background(), source, sender, done)
+}
+
+// NewStreamContext is the same as NewStreamTo, but stops streaming when ctx is done, even if paused.
+// The error from ctx is sent on done, and the source is cleaned up (with Cleanup() or Close() if it has either)
+func NewStreamContext(ctx context.Context, source OpusReader, sender OpusSender, done chan error) *StreamingSession {
 	session := &StreamingSession{
-		source: source,
-		vc:     vc,
-		done:   done,
+		ctx:      ctx,
+		source:   source,
+		sender:   sender,
+		done:     done,
+		wake:     make(chan struct{}, 1),
+		finished: make(chan struct{}),
 	}
 
-	go session.stream()
+	go session.run()
 
 	return session
 }
 
-func (s *StreamingSession) stream() {
-	// Check if we are already running and if so stop
-	s.Lock()
-	if s.running {
-		s.Unlock()
-		panic("Stream is already running!")
-		return
-	}
-	s.running = true
-	s.Unlock()
-
-	defer func() {
-		s.Lock()
-		s.running = false
-		s.Unlock()
-	}()
+// run is the control goroutine, it's the only one reading frames and changing the state
+func (s *StreamingSession) run() {
+	metrics().StreamStarted()
 
 	for {
+		if err := s.ctx.Err(); err != nil {
+			s.finish(err)
+
+			s.sourceMu.Lock()
+			closeSource(s.source)
+			s.sourceMu.Unlock()
+			return
+		}
+
 		s.Lock()
-		if s.paused {
+		if s.wantPaused {
+			wasPlaying := s.state == StreamPlaying
+			s.state = StreamPaused
 			s.Unlock()
-			return
+
+			if wasPlaying {
+				s.sendSilence()
+				s.stopClock()
+				s.setSpeaking(false, true)
+			}
+
+			// Wait for SetPaused
+			select {
+			case <-s.wake:
+			case <-s.ctx.Done():
+			}
+			continue
+		}
+		starting := s.state != StreamPlaying
+		if starting {
+			s.state = StreamPlaying
+			s.playingSince = time.Now()
 		}
 		s.Unlock()
 
+		if starting {
+			s.setSpeaking(true, false)
+		}
+
 		err := s.readNext()
 		if err != nil {
-			s.Lock()
+			s.finish(err)
+			return
+		}
+	}
+}
 
-			s.finished = true
-			if err != io.EOF {
-				s.err = err
-			}
+// finish moves to the finished state, sending the error on the done channel
+func (s *StreamingSession) finish(err error) {
+	if err != ErrVoiceConnClosed && err != ErrSendTimeout {
+		s.sendSilence()
+	}
+	s.stopClock()
+	s.setSpeaking(false, false)
 
-			if s.done != nil {
-				go func() {
-					s.done <- err
-				}()
-			}
+	s.Lock()
+	s.state = StreamFinished
+	if err != io.EOF {
+		s.err = err
+	}
+	s.Unlock()
+	close(s.finished)
 
-			s.Unlock()
-			break
-		}
+	if err == io.EOF {
+		metrics().StreamFinished(nil)
+	} else {
+		metrics().StreamFinished(err)
+	}
+
+	if s.done != nil {
+		go func() {
+			s.done <- err
+		}()
 	}
 }
 
 func (s *StreamingSession) readNext() error {
-	opus, err := s.source.OpusFrame()
+	s.sourceMu.Lock()
+	s.Lock()
+	source := s.source
+	s.Unlock()
+
+	var opus []byte
+	var err error
+	if s.pending != nil {
+		opus = s.pending
+		s.pending = nil
+	} else {
+		opus, err = source.OpusFrame()
+		if err == io.EOF {
+			opus, err = s.rewind(source)
+		}
+	}
+	s.sourceMu.Unlock()
 	if err != nil {
 		return err
 	}
 
-	// Timeout after 100ms (Maybe this needs to be changed?)
-	timeOut := time.NewTimer(time.Second)
-
-	// This will attempt to send on the channel before the timeout, which is 1s
-	select {
-	case <-timeOut.C:
-		return ErrVoiceConnClosed
-	case s.vc.OpusSend <- opus:
-		timeOut.Stop()
+	duration := source.FrameDuration()
+	err = s.sendFrame(opus, duration)
+	for err == ErrVoiceConnClosed && s.waitReconnect() {
+		// Try again now that it's back
+		err = s.sendFrame(opus, duration)
+	}
+	if err != nil {
+		s.Lock()
+		s.stats.FramesDropped++
+		s.Unlock()
+		return err
 	}
 
 	s.Lock()
@@ -117,44 +295,530 @@ func (s *StreamingSession) readNext() error {
 	return nil
 }
 
-// SetPaused provides pause/unpause functionality
-func (s *StreamingSession) SetPaused(paused bool) {
+// sendFrame sends a frame of the given duration, retrying a few times if the connection is congested
+func (s *StreamingSession) sendFrame(opus []byte, duration time.Duration) error {
 	s.Lock()
+	timeout := s.sendTimeout
+	s.Unlock()
 
-	if s.finished {
-		s.Unlock()
+	var err error
+	for i := 0; i <= sendRetries; i++ {
+		started := time.Now()
+		if ts, ok := s.sender.(timeoutSender); ok && timeout > 0 {
+			err = ts.SendOpusTimeout(opus, timeout)
+		} else {
+			err = s.sender.SendOpus(opus)
+		}
+		s.recordSend(time.Since(started), err, duration)
+
+		if err != ErrSendTimeout {
+			return err
+		}
+	}
+
+	return err
+}
+
+// recordSend updates the stats after trying to send a frame
+func (s *StreamingSession) recordSend(blocked time.Duration, err error, duration time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.sends++
+	s.sendTime += blocked
+	if blocked > s.stats.MaxSendTime {
+		s.stats.MaxSendTime = blocked
+	}
+
+	switch err {
+	case nil:
+		s.stats.FramesSent++
+		s.audioSent += duration
+		metrics().FrameSent(blocked)
+	case ErrSendTimeout:
+		s.stats.Timeouts++
+		metrics().SendTimeout()
+	}
+}
+
+// stopClock stops counting wall-clock time spent playing
+func (s *StreamingSession) stopClock() {
+	s.Lock()
+	if !s.playingSince.IsZero() {
+		s.playTime += time.Since(s.playingSince)
+		s.playingSince = time.Time{}
+	}
+	s.Unlock()
+}
+
+// Stats returns the playback stats so far
+func (s *StreamingSession) Stats() StreamStats {
+	s.Lock()
+	defer s.Unlock()
+
+	stats := s.stats
+	if s.sends > 0 {
+		stats.AvgSendTime = s.sendTime / time.Duration(s.sends)
+	}
+
+	playTime := s.playTime
+	if !s.playingSince.IsZero() {
+		playTime += time.Since(s.playingSince)
+	}
+	stats.Drift = playTime - s.audioSent
+	return stats
+}
+
+// rewind seeks back to the start of source and reads the first frame if looping,
+// returns io.EOF if not looping, the source isn't seekable or it's empty
+func (s *StreamingSession) rewind(source OpusReader) ([]byte, error) {
+	s.Lock()
+	loop := s.loop
+	s.Unlock()
+
+	seeker, ok := source.(interface{ Seek(time.Duration) error })
+	if !loop || !ok {
+		return nil, io.EOF
+	}
+
+	err := seeker.Seek(0)
+	if err == ErrCantSeek || err == ErrSourceNotSeekable {
+		// Has a Seek method but can't seek this source, like a decoder reading from a pipe
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	s.Lock()
+	s.framesSent = 0
+	s.Unlock()
+
+	return source.OpusFrame()
+}
+
+// SetLoop sets wether the source is rewound and played again when it ends, instead of finishing the stream.
+// Only sources with a Seek(time.Duration) error method can be looped, like a decoder reading from a file or a bytes.Reader,
+// other sources finish as usual.
+func (s *StreamingSession) SetLoop(loop bool) {
+	s.Lock()
+	s.loop = loop
+	s.Unlock()
+}
+
+// Looping returns wether the source is played again when it ends
+func (s *StreamingSession) Looping() bool {
+	s.Lock()
+	defer s.Unlock()
+	return s.loop
+}
+
+// SetReconnectPolicy sets how to wait for the voice connection when it's closed mid-stream,
+// nil (the default) to stop with ErrVoiceConnClosed right away
+func (s *StreamingSession) SetReconnectPolicy(policy *ReconnectPolicy) {
+	s.Lock()
+	s.reconnect = policy
+	s.Unlock()
+}
+
+// waitReconnect waits for the sender to be ready according to the reconnect policy,
+// returns false if there's no policy or it timed out
+func (s *StreamingSession) waitReconnect() bool {
+	s.Lock()
+	policy := s.reconnect
+	s.Unlock()
+	if policy == nil {
+		return false
+	}
+
+	deadline := time.Now().Add(policy.Timeout)
+	backoff := policy.MinBackoff
+	if backoff <= 0 {
+		backoff = 10 * time.Millisecond
+	}
+	for {
+		if s.sender.Ready() {
+			return true
+		}
+
+		if !time.Now().Add(backoff).Before(deadline) {
+			return false
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-s.ctx.Done():
+			return false
+		}
+
+		backoff *= 2
+		if backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+}
+
+// SetSendTimeout sets how long to wait for the voice connection to accept a frame, the default is 1 second.
+// Sending is retried a few times when it times out while the voice connection is ready,
+// if it isn't ready the stream stops with ErrVoiceConnClosed.
+func (s *StreamingSession) SetSendTimeout(timeout time.Duration) {
+	s.Lock()
+	s.sendTimeout = timeout
+	s.Unlock()
+}
+
+// SetManageSpeaking sets wether the stream sets the speaking state of the voice connection itself,
+// true when it starts or resumes and false when it's paused or finishes. Pausing is debounced by SpeakingDebounce.
+// Disabled by default. Only senders with a Speaking(bool) error method (like DiscordSender) are supported.
+func (s *StreamingSession) SetManageSpeaking(enabled bool) {
+	s.Lock()
+	s.manageSpeaking = enabled
+	playing := s.state == StreamPlaying
+	s.Unlock()
+
+	// Already started
+	if enabled && playing {
+		s.setSpeaking(true, false)
+	}
+}
+
+// setSpeaking sets the speaking state of the sender if SetManageSpeaking is enabled,
+// if delay is true it's set after SpeakingDebounce unless it's changed again before that
+func (s *StreamingSession) setSpeaking(speaking, delay bool) {
+	s.Lock()
+	manage := s.manageSpeaking
+	s.Unlock()
+
+	sender, ok := s.sender.(speakingSender)
+	if !manage || !ok {
+		return
+	}
+
+	s.speakingMu.Lock()
+	defer s.speakingMu.Unlock()
+
+	s.speakingGen++
+	if s.speakingTimer != nil {
+		s.speakingTimer.Stop()
+		s.speakingTimer = nil
+	}
+
+	if delay {
+		gen := s.speakingGen
+		s.speakingTimer = time.AfterFunc(SpeakingDebounce, func() {
+			s.speakingMu.Lock()
+			if s.speakingGen == gen {
+				s.updateSpeaking(sender, speaking)
+			}
+			s.speakingMu.Unlock()
+		})
 		return
 	}
 
-	// Already running
-	if !paused && s.running {
-		if s.paused {
-			// Was set to stop running after next frame so undo this
-			s.paused = false
+	s.updateSpeaking(sender, speaking)
+}
+
+// updateSpeaking calls Speaking on the sender if the state changed, speakingMu has to be held
+func (s *StreamingSession) updateSpeaking(sender speakingSender, speaking bool) {
+	if s.speaking == speaking {
+		return
+	}
+
+	err := sender.Speaking(speaking)
+	if err != nil {
+		logger().Warn("Failed setting speaking state", "err", err)
+		return
+	}
+	s.speaking = speaking
+}
+
+// sendSilence sends the silence frames, unless disabled with SetSendSilence
+func (s *StreamingSession) sendSilence() {
+	s.Lock()
+	noSilence := s.noSilence
+	s.Unlock()
+	if noSilence {
+		return
+	}
+
+	for i := 0; i < silenceFrames; i++ {
+		if s.sendFrame(SilenceFrame, 20*time.Millisecond) != nil {
+			s.Lock()
+			s.stats.FramesDropped++
+			s.Unlock()
+			return
 		}
+	}
+}
 
+// SetSendSilence sets wether 5 silence frames are sent when the stream is paused, finishes or stops because of an error.
+// Enabled by default as recommended by discord.
+func (s *StreamingSession) SetSendSilence(enabled bool) {
+	s.Lock()
+	s.noSilence = !enabled
+	s.Unlock()
+}
+
+// SetPaused provides pause/unpause functionality.
+// Pausing takes effect after the frame being sent, and does nothing once the stream has finished.
+func (s *StreamingSession) SetPaused(paused bool) {
+	s.Lock()
+	if s.state == StreamFinished {
 		s.Unlock()
 		return
 	}
+	s.wantPaused = paused
+	s.Unlock()
+
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// SetSource switches to sending frames from source, without a gap in the audio.
+// The previous source is returned, it's up to the caller to clean it up.
+func (s *StreamingSession) SetSource(source OpusReader) OpusReader {
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+
+	s.Lock()
+	old := s.source
+	s.source = source
+	s.Unlock()
+	s.pending = nil
+
+	return old
+}
+
+// Seek moves playback to pos in the source.
+//
+// Decoder sources (and other sources with a Seek(time.Duration) error method) are seeked directly,
+// encode sessions of a file or url are restarted with StartTime set to pos (rounded down to whole seconds),
+// the old session is cleaned up. Other sources returns ErrSourceNotSeekable.
+func (s *StreamingSession) Seek(pos time.Duration) error {
+	if pos < 0 {
+		pos = 0
+	}
 
-	// Already stopped
-	if paused && !s.running {
-		// Not running, but starting up..
-		if !s.paused {
-			s.paused = true
+	s.Lock()
+	finished := s.state == StreamFinished
+	s.Unlock()
+	if finished {
+		return ErrStreamFinished
+	}
+
+	s.sourceMu.Lock()
+
+	newSource := s.source
+	var oldSession *EncodeSession
+	switch source := s.source.(type) {
+	case *EncodeSession:
+		newSession, err := source.restartAt(pos)
+		if err != nil {
+			s.sourceMu.Unlock()
+			return err
 		}
 
-		s.Unlock()
+		oldSession = source
+		newSource = newSession
+		pos = pos.Truncate(time.Second)
+	case interface{ Seek(time.Duration) error }:
+		err := source.Seek(pos)
+		if err != nil {
+			s.sourceMu.Unlock()
+			return err
+		}
+	default:
+		s.sourceMu.Unlock()
+		return ErrSourceNotSeekable
+	}
+
+	s.Lock()
+	s.source = newSource
+	s.framesSent = int(pos / newSource.FrameDuration())
+	s.Unlock()
+	s.pending = nil
+	s.sourceMu.Unlock()
+
+	// Cleanup can take a while if ffmpeg is slow to exit, don't block the stream meanwhile
+	if oldSession != nil {
+		oldSession.Cleanup()
+	}
+	return nil
+}
+
+// SetPositionUpdates sends the playback position on updates every interval while playing, until the stream finishes.
+// Updates are skipped if updates isn't ready to receive, so it won't slow down the stream.
+// Calling it again replaces the previous channel, a nil channel or 0 interval stops the updates.
+func (s *StreamingSession) SetPositionUpdates(interval time.Duration, updates chan<- time.Duration) {
+	s.Lock()
+	defer s.Unlock()
+
+	if s.positionStop != nil {
+		close(s.positionStop)
+		s.positionStop = nil
+	}
+
+	if updates == nil || interval <= 0 {
 		return
 	}
 
-	// Time to start it up again
-	if !s.running && s.paused && !paused {
-		go s.stream()
+	stop := make(chan struct{})
+	s.positionStop = stop
+	go s.positionUpdates(interval, updates, stop)
+}
+
+// positionUpdates sends the position on updates every interval, until stop is closed or the stream finishes
+func (s *StreamingSession) positionUpdates(interval time.Duration, updates chan<- time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-s.finished:
+			return
+		}
+
+		if s.State() != StreamPlaying {
+			continue
+		}
+
+		select {
+		case updates <- s.PlaybackPosition():
+		default:
+		}
+	}
+}
+
+// SkipFrames reads and discards the next n frames of the source without sending them,
+// returning the number of frames skipped. Unlike Seek it works with any source, including live encodes.
+// If the source ends while skipping io.EOF is returned, and the stream finishes (or loops) right after.
+func (s *StreamingSession) SkipFrames(n int) (int, error) {
+	s.Lock()
+	finished := s.state == StreamFinished
+	s.Unlock()
+	if finished {
+		return 0, ErrStreamFinished
+	}
+
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+
+	skipped := 0
+	if s.pending != nil && n > 0 {
+		s.pending = nil
+		skipped++
+	}
+
+	var err error
+	for skipped < n {
+		_, err = s.source.OpusFrame()
+		if err != nil {
+			break
+		}
+		skipped++
+	}
+
+	s.Lock()
+	s.framesSent += skipped
+	s.Unlock()
+	return skipped, err
+}
+
+// FastForward skips d (rounded down to whole frames) of audio, see SkipFrames
+func (s *StreamingSession) FastForward(d time.Duration) (time.Duration, error) {
+	s.Lock()
+	frameDuration := s.source.FrameDuration()
+	s.Unlock()
+
+	skipped, err := s.SkipFrames(int(d / frameDuration))
+	return time.Duration(skipped) * frameDuration, err
+}
+
+// SetBitrate switches to a new encode session of the same file with the bitrate set to bitrate (in kb/s),
+// for example when the bitrate of the voice channel changes. See SwitchEncodeOptions.
+func (s *StreamingSession) SetBitrate(bitrate int) error {
+	return s.SwitchEncodeOptions(func(options *EncodeOptions) {
+		options.Bitrate = bitrate
+	})
+}
+
+// SwitchEncodeOptions starts a new encode session of the same file with the options changed by modify,
+// and switches to it at the current position without a gap, cleaning up the old one.
+// The old session keeps playing while the new one starts, the new one is then fast forwarded to the same frame.
+//
+// The source has to be an encode session of a file or url, ErrNotEncodeSession is returned otherwise,
+// and ErrSourceNotSeekable for sessions encoding from a reader.
+func (s *StreamingSession) SwitchEncodeOptions(modify func(options *EncodeOptions)) error {
+	s.Lock()
+	finished := s.state == StreamFinished
+	s.Unlock()
+	if finished {
+		return ErrStreamFinished
+	}
+
+	s.sourceMu.Lock()
+	session, ok := s.source.(*EncodeSession)
+	s.sourceMu.Unlock()
+	if !ok {
+		return ErrNotEncodeSession
+	}
+
+	options := *session.Options()
+	modify(&options)
+
+	// StartTime is in whole seconds, the frames up to the current position are skipped below
+	start := s.PlaybackPosition().Truncate(time.Second)
+	newSession, err := session.restartWith(start, &options)
+	if err != nil {
+		return err
+	}
+
+	// Wait for the first frame, the old session keeps playing meanwhile
+	first, err := newSession.OpusFrame()
+	if err != nil {
+		newSession.Cleanup()
+		return err
+	}
+
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+
+	if s.source != session {
+		newSession.Cleanup()
+		return ErrSourceChanged
+	}
+
+	s.Lock()
+	pos := time.Duration(s.framesSent) * session.FrameDuration()
+	s.Unlock()
+
+	// Catch up to the old session, the first frame was already read
+	frames := int((pos - start) / newSession.FrameDuration())
+	if frames == 0 {
+		// The old session hasn't gotten past the first frame, so that's the next one to send
+		s.pending = first
+	}
+
+	for i := 1; i < frames; i++ {
+		_, err = newSession.OpusFrame()
+		if err != nil {
+			newSession.Cleanup()
+			return err
+		}
 	}
 
-	s.paused = paused
+	s.Lock()
+	s.source = newSession
+	s.framesSent = int((start + time.Duration(frames)*newSession.FrameDuration()) / newSession.FrameDuration())
 	s.Unlock()
+
+	session.Cleanup()
+	return nil
 }
 
 // PlaybackPosition returns the the duration of content we have transmitted so far
@@ -165,11 +829,56 @@ func (s *StreamingSession) PlaybackPosition() time.Duration {
 	return dur
 }
 
+// TotalDuration returns the total duration of the source, false if it's unknown.
+// It's reported by ffprobe for encode sessions, and read from the metadata for decoders,
+// or if it's not in there found by scanning the file if the decoder is reading from an io.Seeker.
+func (s *StreamingSession) TotalDuration() (time.Duration, bool) {
+	s.sourceMu.Lock()
+	defer s.sourceMu.Unlock()
+
+	dur := sourceDuration(s.source)
+	return dur, dur > 0
+}
+
+// Remaining returns the duration left to play, false if the total duration is unknown (see TotalDuration)
+func (s *StreamingSession) Remaining() (time.Duration, bool) {
+	total, ok := s.TotalDuration()
+	if !ok {
+		return 0, false
+	}
+
+	remaining := total - s.PlaybackPosition()
+	if remaining < 0 {
+		remaining = 0
+	}
+	return remaining, true
+}
+
+// sourceDuration returns the total duration of source, 0 if unknown
+func sourceDuration(source OpusReader) time.Duration {
+	if session, ok := source.(*EncodeSession); ok {
+		return session.SourceDuration()
+	}
+
+	if m := sourceMetadata(source); m != nil && m.SongInfo != nil && m.SongInfo.Duration > 0 {
+		return time.Duration(m.SongInfo.Duration * float64(time.Second))
+	}
+
+	if d, ok := source.(interface{ Duration() (time.Duration, error) }); ok {
+		dur, err := d.Duration()
+		if err == nil {
+			return dur
+		}
+	}
+
+	return 0
+}
+
 // Finished returns wether the stream finished or not, and any error that caused it to stop
 func (s *StreamingSession) Finished() (bool, error) {
 	s.Lock()
 	err := s.err
-	fin := s.finished
+	fin := s.state == StreamFinished
 	s.Unlock()
 
 	return fin, err
@@ -178,8 +887,15 @@ func (s *StreamingSession) Finished() (bool, error) {
 // Paused returns wether the sream is paused or not
 func (s *StreamingSession) Paused() bool {
 	s.Lock()
-	p := s.paused
+	p := s.wantPaused && s.state != StreamFinished
 	s.Unlock()
 
 	return p
 }
+
+// State returns the current state of the stream
+func (s *StreamingSession) State() StreamState {
+	s.Lock()
+	defer s.Unlock()
+	return s.state
+}
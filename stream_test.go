@@ -0,0 +1,636 @@
+package dca
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/bwmarrin/discordgo"
+)
+
+// chanSender is an OpusSender sending to a channel
+type chanSender chan []byte
+
+func (c chanSender) SendOpus(frame []byte) error {
+	c <- frame
+	return nil
+}
+
+func (c chanSender) Ready() bool {
+	return true
+}
+
+func TestStream(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sender := make(chanSender, 1000)
+	done := make(chan error)
+	stream := NewStreamTo(NewDecoder(file), sender, done)
+
+	err = <-done
+	if err != io.EOF {
+		t.Fatal("Expected io.EOF, got", err)
+	}
+
+	// The frames and the silence after them
+	if len(sender) != 755+silenceFrames {
+		t.Errorf("Incorrect number of frames sent (got %d expected %d)", len(sender), 755+silenceFrames)
+	}
+
+	if pos := stream.PlaybackPosition(); pos != 755*20*time.Millisecond {
+		t.Errorf("Incorrect playback position (got %v expected %v)", pos, 755*20*time.Millisecond)
+	}
+}
+
+func TestBroadcaster(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	a := make(chanSender, 1000)
+	b := make(chanSender, 1000)
+
+	// Add the targets before any frames are read
+	start := make(chan struct{})
+	done := make(chan error)
+	broadcaster := NewBroadcaster(&gatedReader{OpusReader: NewDecoder(file), start: start}, done)
+	broadcaster.Add(a)
+	broadcaster.Add(b)
+	close(start)
+
+	err = <-done
+	if err != io.EOF {
+		t.Fatal("Expected io.EOF, got", err)
+	}
+
+	if len(a) != 755 || len(b) != 755 {
+		t.Errorf("Incorrect number of frames sent (got %d and %d expected %d)", len(a), len(b), 755)
+	}
+}
+
+// gatedReader waits for start to be closed before reading
+type gatedReader struct {
+	OpusReader
+	start chan struct{}
+}
+
+func (g *gatedReader) OpusFrame() ([]byte, error) {
+	<-g.start
+	return g.OpusReader.OpusFrame()
+}
+
+func TestPlayer(t *testing.T) {
+	data, err := ioutil.ReadFile("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var player *Player
+	track := &Track{
+		Open: func() (OpusReader, error) {
+			// Tracks are opened without the lock held, so this doesn't deadlock
+			player.Queue()
+			return NewDecoder(bytes.NewReader(data)), nil
+		},
+	}
+
+	sender := make(chanSender, 2000)
+	events := make(chan *PlayerEvent)
+	player = NewPlayer(sender, events)
+	player.SetPreload(true)
+	player.Enqueue(track, track)
+
+	var started, ended int
+	for event := range events {
+		switch event.Type {
+		case PlayerEventTrackStart:
+			started++
+		case PlayerEventTrackEnd:
+			ended++
+		case PlayerEventTrackError:
+			t.Fatal(event.Err)
+		}
+		if event.Type == PlayerEventQueueEnd {
+			break
+		}
+	}
+
+	if started != 2 || ended != 2 {
+		t.Errorf("Incorrect number of events (got %d started, %d ended expected 2 and 2)", started, ended)
+	}
+
+	if len(sender) != 755*2+silenceFrames {
+		t.Errorf("Incorrect number of frames sent (got %d expected %d)", len(sender), 755*2+silenceFrames)
+	}
+}
+
+func TestStreamPause(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sender := make(chanSender)
+	done := make(chan error, 1)
+	stream := NewStreamTo(NewDecoder(file), sender, done)
+
+	frames := 0
+	countFrame := func(frame []byte) {
+		if !bytes.Equal(frame, SilenceFrame) {
+			frames++
+		}
+	}
+
+	for i := 0; i < 10; i++ {
+		countFrame(<-sender)
+	}
+	stream.SetPaused(true)
+
+	// Read until the silence sent when pausing
+	for silence := 0; silence < silenceFrames; {
+		frame := <-sender
+		if bytes.Equal(frame, SilenceFrame) {
+			silence++
+		}
+		countFrame(frame)
+	}
+
+	for stream.State() != StreamPaused {
+		time.Sleep(time.Millisecond)
+	}
+
+	stream.SetPaused(false)
+	for {
+		select {
+		case frame := <-sender:
+			countFrame(frame)
+			continue
+		case err = <-done:
+		}
+		break
+	}
+
+	if err != io.EOF || stream.State() != StreamFinished {
+		t.Errorf("Expected io.EOF and finished, got %v and %v", err, stream.State())
+	}
+	if frames != 755 {
+		t.Errorf("Incorrect number of frames sent (got %d expected %d)", frames, 755)
+	}
+	if stats := stream.Stats(); stats.FramesSent != 755+silenceFrames*2 || stats.FramesDropped != 0 {
+		t.Errorf("Incorrect stats, got %+v", stats)
+	}
+}
+
+func TestStreamContext(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sender := make(chanSender)
+	done := make(chan error, 1)
+	stream := NewStreamContext(ctx, NewDecoder(file), sender, done)
+	stream.SetSendSilence(false)
+
+	<-sender
+	stream.SetPaused(true)
+
+	if total, ok := stream.TotalDuration(); !ok || total != 755*20*time.Millisecond {
+		t.Errorf("Incorrect total duration, got %v (%v)", total, ok)
+	}
+	for stream.State() != StreamPaused {
+		select {
+		case <-sender:
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	cancel()
+	if err = <-done; err != context.Canceled {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+	if stream.State() != StreamFinished {
+		t.Errorf("Expected finished, got %v", stream.State())
+	}
+}
+
+func TestStreamLoop(t *testing.T) {
+	data, err := ioutil.ReadFile("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sender := make(chanSender)
+	done := make(chan error, 1)
+	stream := NewStreamTo(NewDecoder(bytes.NewReader(data)), sender, done)
+	stream.SetLoop(true)
+
+	// Play it through twice, then let it finish
+	for i := 0; i < 755*2; i++ {
+		<-sender
+	}
+	stream.SetLoop(false)
+
+	for {
+		select {
+		case <-sender:
+			continue
+		case err = <-done:
+		}
+		break
+	}
+
+	if err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+
+	// A decoder reading from something that can't seek finishes as usual
+	done = make(chan error, 1)
+	stream = NewStreamTo(NewDecoder(bytes.NewBuffer(data)), sender, done)
+	stream.SetLoop(true)
+
+	frames := 0
+	for {
+		select {
+		case frame := <-sender:
+			if !bytes.Equal(frame, SilenceFrame) {
+				frames++
+			}
+			continue
+		case err = <-done:
+		}
+		break
+	}
+
+	if err != io.EOF || frames != 755 {
+		t.Errorf("Expected io.EOF after 755 frames, got %v after %d", err, frames)
+	}
+}
+
+// speakingChanSender is a chanSender that records the speaking state changes
+type speakingChanSender struct {
+	chanSender
+	changes chan bool
+}
+
+func (s speakingChanSender) Speaking(speaking bool) error {
+	s.changes <- speaking
+	return nil
+}
+
+func TestStreamSpeaking(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sender := speakingChanSender{make(chanSender), make(chan bool, 10)}
+	done := make(chan error, 1)
+	stream := NewStreamTo(NewDecoder(file), sender, done)
+	stream.SetManageSpeaking(true)
+	stream.SetSendSilence(false)
+
+	for {
+		select {
+		case <-sender.chanSender:
+			continue
+		case err = <-done:
+		}
+		break
+	}
+
+	close(sender.changes)
+	var changes []bool
+	for speaking := range sender.changes {
+		changes = append(changes, speaking)
+	}
+	if len(changes) != 2 || !changes[0] || changes[1] {
+		t.Errorf("Expected speaking to be set to true then false, got %v", changes)
+	}
+}
+
+func TestStreamSkipFrames(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	sender := make(chanSender)
+	done := make(chan error, 1)
+	stream := NewStreamTo(NewDecoder(file), sender, done)
+
+	frames := 1
+	<-sender
+	skipped, err := stream.FastForward(time.Second)
+	if err != nil || skipped != time.Second {
+		t.Fatalf("Expected to skip 1s, skipped %v: %v", skipped, err)
+	}
+
+	for {
+		select {
+		case frame := <-sender:
+			if !bytes.Equal(frame, SilenceFrame) {
+				frames++
+			}
+			continue
+		case err = <-done:
+		}
+		break
+	}
+
+	if frames != 755-50 {
+		t.Errorf("Incorrect number of frames sent (got %d expected %d)", frames, 755-50)
+	}
+}
+
+func TestSwitchEncodeOptionsStart(t *testing.T) {
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+
+	session, err := EncodeFile("song.mp3", &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Not started, so frames are only sent by readNext below
+	sender := make(chanSender, 10)
+	stream := &StreamingSession{source: session, sender: sender}
+
+	err = stream.SetBitrate(96)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stream.source.(*EncodeSession).Cleanup()
+
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decoder := NewDecoder(file)
+
+	// The fake ffmpeg always encodes testaudio.dca, so no frame should be skipped or repeated
+	for i := 0; i < 3; i++ {
+		err = stream.readNext()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		expected, _ := decoder.OpusFrame()
+		if frame := <-sender; !bytes.Equal(frame, expected) {
+			t.Fatalf("Frame %d after switching isn't frame %d of the file", i, i)
+		}
+	}
+
+	if pos := stream.PlaybackPosition(); pos != 60*time.Millisecond {
+		t.Errorf("Incorrect position (got %v expected %v)", pos, 60*time.Millisecond)
+	}
+}
+
+func TestSeekCleanupUnlocked(t *testing.T) {
+	defer func(timeout time.Duration) { CleanupTimeout = timeout }(CleanupTimeout)
+	CleanupTimeout = 500 * time.Millisecond
+
+	options := *StdEncodeOptions
+	options.CommandRunner = fakeRunner{}
+	options.RawOutput = true
+	options.StopGracePeriod = time.Minute
+
+	// ffmpeg ignores being asked to exit, so cleaning up the old session takes a while
+	session, err := EncodeFile("hang.mp3", &options)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100 && !session.Running(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	stream := &StreamingSession{source: session, sender: make(chanSender, 10)}
+
+	seekDone := make(chan error, 1)
+	go func() {
+		seekDone <- stream.Seek(5 * time.Second)
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	stream.TotalDuration()
+	select {
+	case err = <-seekDone:
+		t.Error("Expected the source to be usable while the old session is cleaned up")
+	default:
+		err = <-seekDone
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.source.(*EncodeSession).Cleanup()
+
+	if pos := stream.PlaybackPosition(); pos != 5*time.Second {
+		t.Errorf("Incorrect position (got %v expected %v)", pos, 5*time.Second)
+	}
+}
+
+// bufferCloser is a bytes.Buffer with a no-op Close
+type bufferCloser struct {
+	bytes.Buffer
+}
+
+func (b *bufferCloser) Close() error {
+	return nil
+}
+
+func TestRecorder(t *testing.T) {
+	vc := &discordgo.VoiceConnection{OpusRecv: make(chan *discordgo.Packet)}
+	var output bufferCloser
+	recorder := NewRecorder(vc, func(userID string, ssrc uint32) (io.WriteCloser, error) {
+		return &output, nil
+	})
+
+	frame := []byte{0xFC, 0xFF, 0xFE}
+	packets := []*discordgo.Packet{
+		{SSRC: 1, Sequence: 1, Timestamp: 0, Opus: frame},
+		{SSRC: 1, Sequence: 2, Timestamp: 960, Opus: frame},
+		{SSRC: 1, Sequence: 2, Timestamp: 960, Opus: frame}, // Duplicate
+		{SSRC: 1, Sequence: 4, Timestamp: 960 * 5, Opus: frame},
+	}
+	for _, p := range packets {
+		vc.OpusRecv <- p
+	}
+
+	err := recorder.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	decoder := NewDecoder(bytes.NewReader(output.Bytes()))
+	var frames [][]byte
+	for {
+		f, err := decoder.OpusFrame()
+		if err != nil {
+			break
+		}
+		frames = append(frames, f)
+	}
+
+	// 2 frames, 3 frames of silence for the jump in the timestamp and the last frame
+	if len(frames) != 6 || !bytes.Equal(frames[2], SilenceFrame) || !bytes.Equal(frames[5], frame) {
+		t.Errorf("Incorrect frames recorded: %v", frames)
+	}
+	if decoder.Metadata == nil || decoder.Metadata.Origin.Source != "discord" {
+		t.Errorf("Incorrect metadata: %+v", decoder.Metadata)
+	}
+}
+
+func TestStreamGenerated(t *testing.T) {
+	sender := make(chanSender)
+	done := make(chan error, 1)
+	NewStreamTo(SilenceSource(time.Second), sender, done)
+
+	frames := 0
+	for {
+		select {
+		case <-sender:
+			frames++
+			continue
+		case err := <-done:
+			if err != io.EOF {
+				t.Errorf("Expected io.EOF, got %v", err)
+			}
+		}
+		break
+	}
+
+	// 50 frames and the silence frames sent when finishing
+	if frames != 50+silenceFrames {
+		t.Errorf("Incorrect number of frames sent (got %d expected %d)", frames, 50+silenceFrames)
+	}
+}
+
+func TestMemoryTrack(t *testing.T) {
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	track, err := LoadIntoMemory(NewDecoder(file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if track.Frames() != 755 || track.Metadata() == nil {
+		t.Fatalf("Expected 755 frames and metadata, got %d frames and %v", track.Frames(), track.Metadata())
+	}
+
+	// Two streams playing it at the same time
+	for i := 0; i < 2; i++ {
+		sender := make(chanSender)
+		done := make(chan error, 1)
+		stream := NewStreamTo(track.Reader(), sender, done)
+		stream.SetSendSilence(false)
+
+		frames := 0
+		for {
+			select {
+			case <-sender:
+				frames++
+				continue
+			case <-done:
+			}
+			break
+		}
+		if frames != 755 {
+			t.Errorf("Incorrect number of frames sent (got %d expected %d)", frames, 755)
+		}
+	}
+}
+
+func TestFileSource(t *testing.T) {
+	source, err := OpenFileSource("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.Close()
+
+	if source.Frames() != 755 {
+		t.Fatalf("Expected 755 frames, got %d", source.Frames())
+	}
+
+	file, err := os.Open("testaudio.dca")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	decoder := NewDecoder(file)
+
+	a, b := source.Reader(), source.Reader()
+	b.Seek(time.Second)
+	for i := 0; i < 755; i++ {
+		expected, err := decoder.OpusFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		frame, err := a.OpusFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(frame, expected) {
+			t.Fatalf("Frame %d differs from the decoder", i)
+		}
+	}
+
+	if _, err := a.OpusFrame(); err != io.EOF {
+		t.Errorf("Expected io.EOF, got %v", err)
+	}
+	if b.Position() != time.Second {
+		t.Errorf("Reading one reader moved the other, position %s", b.Position())
+	}
+}
+
+func TestStreamMetrics(t *testing.T) {
+	m := NewExpvarMetrics("dca_test_stream")
+	DefaultMetrics = m
+	defer func() { DefaultMetrics = nil }()
+
+	sender := make(chanSender)
+	done := make(chan error, 1)
+	NewStreamTo(SilenceSource(time.Second), sender, done)
+	for {
+		select {
+		case <-sender:
+			continue
+		case <-done:
+		}
+		break
+	}
+
+	// Including the silence frames sent when finishing
+	framesSent := strconv.Itoa(50 + silenceFrames)
+	for key, expected := range map[string]string{"streams_started": "1", "streams_finished": "1", "frames_sent": framesSent} {
+		v := m.Map().Get(key)
+		if v == nil || v.String() != expected {
+			t.Errorf("Expected %s to be %s, got %v", key, expected, v)
+		}
+	}
+	if m.Map().Get("streams_failed") != nil {
+		t.Error("Stream reaching the end counted as failed")
+	}
+}
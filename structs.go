@@ -1,5 +1,22 @@
 package dca
 
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrNoCover = errors.New("No cover art")
+)
+
 // Base metadata struct
 //
 // https://github.com/bwmarrin/dca/issues/5#issuecomment-189713886
@@ -9,6 +26,9 @@ type Metadata struct {
 	SongInfo *SongMetadata   `json:"info"`
 	Origin   *OriginMetadata `json:"origin"`
 	Extra    *ExtraMetadata  `json:"extra"`
+
+	Chapters  []*ChapterMetadata `json:"chapters,omitempty"`
+	SeekTable *SeekTable         `json:"seek_table,omitempty"`
 }
 
 // DCA metadata struct
@@ -17,6 +37,11 @@ type Metadata struct {
 type DCAMetadata struct {
 	Version int8             `json:"version"`
 	Tool    *DCAToolMetadata `json:"tool"`
+
+	// The following are only available in version 2
+	Frames   int     `json:"frames,omitempty"`   // Total number of frames
+	Duration float64 `json:"duration,omitempty"` // Total duration in seconds
+	CRC      bool    `json:"crc,omitempty"`      // Wether every frame is followed by a CRC32 (IEEE) of it
 }
 
 // DCA tool metadata struct
@@ -39,6 +64,52 @@ type SongMetadata struct {
 	Genre    string  `json:"genre"`
 	Comments string  `json:"comments"`
 	Cover    *string `json:"cover"`
+
+	AlbumArtist string  `json:"album_artist,omitempty"`
+	Composer    string  `json:"composer,omitempty"`
+	Track       int     `json:"track,omitempty"`
+	Disc        int     `json:"disc,omitempty"`
+	Date        string  `json:"date,omitempty"`
+	Duration    float64 `json:"duration,omitempty"` // Duration of the song in seconds
+}
+
+// DecodeCover decodes the cover art, returning the image and the name of its format (ex "jpeg")
+// jpeg and png are supported out of the box, for webp import golang.org/x/image/webp
+func (s *SongMetadata) DecodeCover() (image.Image, string, error) {
+	if s.Cover == nil || *s.Cover == "" {
+		return nil, "", ErrNoCover
+	}
+
+	data, err := base64.StdEncoding.DecodeString(*s.Cover)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return image.Decode(bytes.NewReader(data))
+}
+
+// merge overwrites the fields in s with the non-empty ones in o
+func (s *SongMetadata) merge(o *SongMetadata) {
+	mergeString(&s.Title, o.Title)
+	mergeString(&s.Artist, o.Artist)
+	mergeString(&s.Album, o.Album)
+	mergeString(&s.Genre, o.Genre)
+	mergeString(&s.Comments, o.Comments)
+	mergeString(&s.AlbumArtist, o.AlbumArtist)
+	mergeString(&s.Composer, o.Composer)
+	mergeString(&s.Date, o.Date)
+	if o.Cover != nil {
+		s.Cover = o.Cover
+	}
+	if o.Track != 0 {
+		s.Track = o.Track
+	}
+	if o.Disc != 0 {
+		s.Disc = o.Disc
+	}
+	if o.Duration != 0 {
+		s.Duration = o.Duration
+	}
 }
 
 // Origin information metadata struct
@@ -53,6 +124,25 @@ type OriginMetadata struct {
 	Url      string `json:"url"`
 }
 
+// merge overwrites the fields in m with the non-empty ones in o
+func (m *OriginMetadata) merge(o *OriginMetadata) {
+	mergeString(&m.Source, o.Source)
+	mergeString(&m.Encoding, o.Encoding)
+	mergeString(&m.Url, o.Url)
+	if o.Bitrate != 0 {
+		m.Bitrate = o.Bitrate
+	}
+	if o.Channels != 0 {
+		m.Channels = o.Channels
+	}
+}
+
+func mergeString(dst *string, src string) {
+	if src != "" {
+		*dst = src
+	}
+}
+
 // Opus metadata struct
 //
 // Contains information about how the file was encoded
@@ -66,15 +156,73 @@ type OpusMetadata struct {
 	VBR         bool   `json:"vbr"`
 }
 
-// Extra metadata struct
-type ExtraMetadata struct{}
+// Chapter metadata struct
+//
+// Contains the title and position of a chapter, in seconds.
+type ChapterMetadata struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+}
+
+// StartTime returns the start of the chapter as a time.Duration
+func (c *ChapterMetadata) StartTime() time.Duration {
+	return time.Duration(c.Start * float64(time.Second))
+}
+
+// EndTime returns the end of the chapter as a time.Duration
+func (c *ChapterMetadata) EndTime() time.Duration {
+	return time.Duration(c.End * float64(time.Second))
+}
+
+// Extra metadata
+//
+// Arbitrary data stored by the application that encoded the file, as json.
+type ExtraMetadata map[string]json.RawMessage
+
+// Get unmarshals the value stored under key into v, returns false if there's no such key
+func (e ExtraMetadata) Get(key string, v interface{}) (bool, error) {
+	raw, ok := e[key]
+	if !ok {
+		return false, nil
+	}
+
+	return true, json.Unmarshal(raw, v)
+}
+
+// String returns the string stored under key, or "" if there's none
+func (e ExtraMetadata) String(key string) string {
+	var str string
+	e.Get(key, &str)
+	return str
+}
+
+// Int returns the integer stored under key, or 0 if there's none
+func (e ExtraMetadata) Int(key string) int64 {
+	var i int64
+	e.Get(key, &i)
+	return i
+}
+
+// Set stores v as json under key
+func (e ExtraMetadata) Set(key string, v interface{}) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+
+	e[key] = raw
+	return nil
+}
 
 ////////////////////////////////////////////////////////
 /// FFprobe Structures
 ////////////////////////////////////////////////////////
 
 type FFprobeMetadata struct {
-	Format *FFprobeFormat `json:"format"`
+	Format   *FFprobeFormat    `json:"format"`
+	Streams  []*FFprobeStream  `json:"streams"`
+	Chapters []*FFprobeChapter `json:"chapters"`
 }
 
 type FFprobeFormat struct {
@@ -92,12 +240,86 @@ type FFprobeFormat struct {
 	Tags *FFprobeTags `json:"tags"`
 }
 
+// ParsedDuration returns the duration, or 0 if unknown
+func (f *FFprobeFormat) ParsedDuration() time.Duration {
+	return parseProbeDuration(f.Duration)
+}
+
+// ParsedBitrate returns the bitrate in bits per second, or 0 if unknown
+func (f *FFprobeFormat) ParsedBitrate() int {
+	bitrate, _ := strconv.Atoi(f.Bitrate)
+	return bitrate
+}
+
+type FFprobeStream struct {
+	Index         int               `json:"index"`
+	CodecName     string            `json:"codec_name"`
+	CodecLongName string            `json:"codec_long_name"`
+	CodecType     string            `json:"codec_type"`
+	SampleRate    string            `json:"sample_rate"`
+	Channels      int               `json:"channels"`
+	ChannelLayout string            `json:"channel_layout"`
+	StartTime     string            `json:"start_time"`
+	Duration      string            `json:"duration"`
+	Bitrate       string            `json:"bit_rate"`
+	Disposition   map[string]int    `json:"disposition"`
+	Tags          map[string]string `json:"tags"`
+}
+
+// ParsedDuration returns the duration, or 0 if unknown
+func (s *FFprobeStream) ParsedDuration() time.Duration {
+	return parseProbeDuration(s.Duration)
+}
+
+// ParsedBitrate returns the bitrate in bits per second, or 0 if unknown
+func (s *FFprobeStream) ParsedBitrate() int {
+	bitrate, _ := strconv.Atoi(s.Bitrate)
+	return bitrate
+}
+
+// ParsedSampleRate returns the sample rate, or 0 if unknown
+func (s *FFprobeStream) ParsedSampleRate() int {
+	sampleRate, _ := strconv.Atoi(s.SampleRate)
+	return sampleRate
+}
+
+type FFprobeChapter struct {
+	ID        int               `json:"id"`
+	TimeBase  string            `json:"time_base"`
+	StartTime string            `json:"start_time"`
+	EndTime   string            `json:"end_time"`
+	Tags      map[string]string `json:"tags"`
+}
+
+// parseProbeDuration parses a duration in seconds as printed by ffprobe
+func parseProbeDuration(d string) time.Duration {
+	seconds, err := strconv.ParseFloat(d, 64)
+	if err != nil {
+		return 0
+	}
+
+	return time.Duration(seconds * float64(time.Second))
+}
+
 type FFprobeTags struct {
 	Date        string `json:"date"`
 	Track       string `json:"track"`
+	Disc        string `json:"disc"`
 	Artist      string `json:"artist"`
+	AlbumArtist string `json:"album_artist"`
+	Composer    string `json:"composer"`
 	Genre       string `json:"genre"`
 	Title       string `json:"title"`
 	Album       string `json:"album"`
 	Compilation string `json:"compilation"`
 }
+
+// parseTagNumber parses track and disc numbers, which may be in the form "3/12"
+func parseTagNumber(tag string) int {
+	if i := strings.IndexByte(tag, '/'); i != -1 {
+		tag = tag[:i]
+	}
+
+	n, _ := strconv.Atoi(strings.TrimSpace(tag))
+	return n
+}
@@ -0,0 +1,140 @@
+package dca
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// Subscription is an OpusReader receiving all the opus frames of an EncodeSession, see EncodeSession.Subscribe
+type Subscription struct {
+	session   *EncodeSession
+	frames    chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe returns a new consumer of the opus frames (without the metadata frame) of the session,
+// every subscription gets all the frames from when it subscribed, so the same encode can be streamed to multiple places.
+//
+// Reading is paced by the slowest subscription, each can be BufferedFrames behind before holding up the others,
+// and paused while there are none. After the first call to Subscribe the session shouldn't be read from directly.
+// The frames are shared between the subscriptions and must not be modified.
+func (e *EncodeSession) Subscribe() *Subscription {
+	e.subscribeStart.Do(func() {
+		e.subscribers = make(map[*Subscription]struct{})
+		e.subscribeCond = sync.NewCond(&e.subscribersMu)
+		go e.runSubscriptions()
+
+		// Wake up runSubscriptions if it's waiting for subscribers, so the remaining frames are read
+		go func() {
+			<-e.done
+			e.subscribersMu.Lock()
+			e.subscribeCond.Broadcast()
+			e.subscribersMu.Unlock()
+		}()
+	})
+
+	s := &Subscription{
+		session: e,
+		frames:  make(chan []byte, e.options.BufferedFrames),
+		closed:  make(chan struct{}),
+	}
+
+	e.subscribersMu.Lock()
+	if e.subscribeEnded {
+		close(s.frames)
+	} else {
+		e.subscribers[s] = struct{}{}
+		e.subscribeCond.Broadcast()
+	}
+	e.subscribersMu.Unlock()
+
+	return s
+}
+
+// runSubscriptions reads the frames of the session and sends them to the subscriptions
+func (e *EncodeSession) runSubscriptions() {
+	var targets []*Subscription
+	for {
+		e.subscribersMu.Lock()
+		for len(e.subscribers) == 0 && !e.isDone() {
+			e.subscribeCond.Wait()
+		}
+
+		targets = targets[:0]
+		for s := range e.subscribers {
+			targets = append(targets, s)
+		}
+		e.subscribersMu.Unlock()
+
+		frame, err := e.OpusFrame()
+		if err != nil {
+			e.subscribersMu.Lock()
+			e.subscribeEnded = true
+			for s := range e.subscribers {
+				close(s.frames)
+			}
+			e.subscribersMu.Unlock()
+			return
+		}
+
+		for _, s := range targets {
+			select {
+			case s.frames <- frame:
+			case <-s.closed:
+			}
+		}
+	}
+}
+
+// isDone returns true if the session has finished
+func (e *EncodeSession) isDone() bool {
+	select {
+	case <-e.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// OpusFrame implements OpusReader, when the session ends the error it ended with is returned in place of io.EOF
+func (s *Subscription) OpusFrame() ([]byte, error) {
+	select {
+	case <-s.closed:
+		return nil, io.EOF
+	default:
+	}
+
+	select {
+	case frame, ok := <-s.frames:
+		if !ok {
+			return nil, s.session.endErr()
+		}
+		return frame, nil
+	case <-s.closed:
+		return nil, io.EOF
+	}
+}
+
+// FrameDuration implements OpusReader
+func (s *Subscription) FrameDuration() time.Duration {
+	return s.session.FrameDuration()
+}
+
+// Metadata returns the metadata of the session, see EncodeSession.Metadata
+func (s *Subscription) Metadata() *Metadata {
+	return s.session.Metadata()
+}
+
+// Unsubscribe stops receiving frames, OpusFrame returns io.EOF after this
+func (s *Subscription) Unsubscribe() {
+	s.closeOnce.Do(func() {
+		e := s.session
+		e.subscribersMu.Lock()
+		delete(e.subscribers, s)
+		e.subscribersMu.Unlock()
+
+		close(s.closed)
+	})
+}
@@ -0,0 +1,90 @@
+package dca
+
+import (
+	"io"
+	"time"
+)
+
+// TeeReader is an OpusReader that passes the frames of another one through as is,
+// while also writing them as dca to a writer. For example to cache a track while playing it for the first time.
+type TeeReader struct {
+	source OpusReader
+	w      io.Writer
+	writer *Writer
+	err    error
+}
+
+// TeeOpusReader returns a TeeReader writing the frames read from source to w.
+// The metadata of source (encode sessions, decoders and ogg readers) is written before the first frame if available,
+// otherwise metadata with just the opus section is written.
+// If writing fails the frames are still passed through, see Err.
+func TeeOpusReader(source OpusReader, w io.Writer) *TeeReader {
+	return &TeeReader{
+		source: source,
+		w:      w,
+	}
+}
+
+// OpusFrame implements OpusReader
+func (t *TeeReader) OpusFrame() ([]byte, error) {
+	frame, err := t.source.OpusFrame()
+	if err != nil || t.err != nil {
+		return frame, err
+	}
+
+	if t.writer == nil {
+		t.writer, t.err = NewWriter(t.w, t.metadata())
+		if t.err != nil {
+			logger().Error("Tee write error", "err", t.err)
+			return frame, nil
+		}
+	}
+
+	t.err = t.writer.WriteFrame(frame)
+	if t.err != nil {
+		logger().Error("Tee write error", "err", t.err)
+	}
+	return frame, nil
+}
+
+// metadata returns the metadata to write, the one of source if it has any
+func (t *TeeReader) metadata() *Metadata {
+	if m := sourceMetadata(t.source); m != nil {
+		// The dca section and seek table of the source doesn't apply to what's written
+		copied := *m
+		copied.Dca = dcaMetadata()
+		copied.SeekTable = nil
+		return &copied
+	}
+
+	// Like the encoder the frame size is in samples of all channels
+	channels := sourceChannels(t.source)
+	return &Metadata{
+		Dca: dcaMetadata(),
+		Opus: &OpusMetadata{
+			SampleRate: 48000,
+			FrameSize:  int(t.FrameDuration()*48000/time.Second) * channels,
+			Channels:   channels,
+		},
+		SongInfo: &SongMetadata{},
+		Origin:   &OriginMetadata{},
+	}
+}
+
+// FrameDuration implements OpusReader
+func (t *TeeReader) FrameDuration() time.Duration {
+	return t.source.FrameDuration()
+}
+
+// Err returns the error that stopped the frames from being written, nil if none
+func (t *TeeReader) Err() error {
+	return t.err
+}
+
+// Frames returns the number of frames written
+func (t *TeeReader) Frames() int {
+	if t.writer == nil {
+		return 0
+	}
+	return t.writer.Frames()
+}
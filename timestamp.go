@@ -0,0 +1,73 @@
+package dca
+
+import (
+	"time"
+)
+
+// TimestampedFrame is an opus frame with its presentation timestamp
+type TimestampedFrame struct {
+	Data      []byte
+	Timestamp time.Duration // Time from the start of the stream to the start of the frame
+	Duration  time.Duration
+}
+
+// TimestampedReader wraps an OpusReader, giving every frame a timestamp.
+// The duration of each frame is taken from its opus TOC, falling back to the FrameDuration of the reader.
+type TimestampedReader struct {
+	r   OpusReader
+	pos time.Duration
+}
+
+// NewTimestampedReader returns a new TimestampedReader reading from r.
+// If r is a *Decoder the timestamps starts at its current position.
+func NewTimestampedReader(r OpusReader) *TimestampedReader {
+	reader := &TimestampedReader{
+		r: r,
+	}
+
+	if decoder, ok := r.(*Decoder); ok {
+		reader.pos = decoder.Position()
+	}
+
+	return reader
+}
+
+// Next returns the next frame with its timestamp
+func (t *TimestampedReader) Next() (*TimestampedFrame, error) {
+	data, err := t.r.OpusFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	duration := t.r.FrameDuration()
+	if info, err := ParsePacket(data); err == nil {
+		duration = info.Duration()
+	}
+
+	frame := &TimestampedFrame{
+		Data:      data,
+		Timestamp: t.pos,
+		Duration:  duration,
+	}
+	t.pos += duration
+	return frame, nil
+}
+
+// Position returns the timestamp of the next frame
+func (t *TimestampedReader) Position() time.Duration {
+	return t.pos
+}
+
+// OpusFrame implements OpusReader
+func (t *TimestampedReader) OpusFrame() ([]byte, error) {
+	frame, err := t.Next()
+	if err != nil {
+		return nil, err
+	}
+	return frame.Data, nil
+}
+
+// FrameDuration implements OpusReader
+func (t *TimestampedReader) FrameDuration() time.Duration {
+	return t.r.FrameDuration()
+}
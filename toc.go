@@ -0,0 +1,126 @@
+package dca
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrBadPacket = errors.New("Invalid opus packet")
+)
+
+// OpusMode is the coding mode of an opus packet
+type OpusMode int
+
+const (
+	ModeSILK OpusMode = iota
+	ModeHybrid
+	ModeCELT
+)
+
+func (m OpusMode) String() string {
+	switch m {
+	case ModeSILK:
+		return "SILK"
+	case ModeHybrid:
+		return "Hybrid"
+	case ModeCELT:
+		return "CELT"
+	}
+	return "Unknown"
+}
+
+// OpusBandwidth is the audio bandwidth of an opus packet
+type OpusBandwidth int
+
+const (
+	BandwidthNarrowband    OpusBandwidth = iota // 4khz
+	BandwidthMediumband                         // 6khz
+	BandwidthWideband                           // 8khz
+	BandwidthSuperWideband                      // 12khz
+	BandwidthFullband                           // 20khz
+)
+
+func (b OpusBandwidth) String() string {
+	switch b {
+	case BandwidthNarrowband:
+		return "Narrowband"
+	case BandwidthMediumband:
+		return "Mediumband"
+	case BandwidthWideband:
+		return "Wideband"
+	case BandwidthSuperWideband:
+		return "Super Wideband"
+	case BandwidthFullband:
+		return "Fullband"
+	}
+	return "Unknown"
+}
+
+// PacketInfo is the information in the TOC (table of contents) byte of an opus packet,
+// see https://tools.ietf.org/html/rfc6716#section-3.1
+type PacketInfo struct {
+	Config        int // The configuration number (0 - 31)
+	Mode          OpusMode
+	Bandwidth     OpusBandwidth
+	Stereo        bool
+	FrameDuration time.Duration // Duration of each frame in the packet
+	Frames        int           // Number of frames in the packet
+}
+
+// Duration returns the total duration of the packet
+func (p PacketInfo) Duration() time.Duration {
+	return p.FrameDuration * time.Duration(p.Frames)
+}
+
+// Channels returns the number of channels, 1 or 2
+func (p PacketInfo) Channels() int {
+	if p.Stereo {
+		return 2
+	}
+	return 1
+}
+
+// ParsePacket parses the TOC byte of an opus packet (a dca frame)
+func ParsePacket(packet []byte) (PacketInfo, error) {
+	if len(packet) < 1 {
+		return PacketInfo{}, ErrBadPacket
+	}
+
+	toc := packet[0]
+	info := PacketInfo{
+		Config: int(toc >> 3),
+		Stereo: toc&0x4 != 0,
+		Frames: 1,
+	}
+
+	switch {
+	case info.Config < 12:
+		info.Mode = ModeSILK
+		info.Bandwidth = OpusBandwidth(info.Config / 4)
+		info.FrameDuration = []time.Duration{10, 20, 40, 60}[info.Config%4] * time.Millisecond
+	case info.Config < 16:
+		info.Mode = ModeHybrid
+		info.Bandwidth = BandwidthSuperWideband + OpusBandwidth((info.Config-12)/2)
+		info.FrameDuration = []time.Duration{10, 20}[info.Config%2] * time.Millisecond
+	default:
+		info.Mode = ModeCELT
+		info.Bandwidth = []OpusBandwidth{BandwidthNarrowband, BandwidthWideband, BandwidthSuperWideband, BandwidthFullband}[(info.Config-16)/4]
+		info.FrameDuration = []time.Duration{2500, 5000, 10000, 20000}[info.Config%4] * time.Microsecond
+	}
+
+	switch toc & 0x3 {
+	case 1, 2:
+		info.Frames = 2
+	case 3:
+		if len(packet) < 2 {
+			return info, ErrBadPacket
+		}
+		info.Frames = int(packet[1] & 0x3f)
+		if info.Frames == 0 || info.Duration() > 120*time.Millisecond {
+			return info, ErrBadPacket
+		}
+	}
+
+	return info, nil
+}
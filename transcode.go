@@ -0,0 +1,172 @@
+package dca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"layeh.com/gopus"
+)
+
+// Transcode decodes the dca file in r and encodes it again with opts, writing the new dca file to w.
+// Useful for creating lower bitrate versions of cached files.
+//
+// The song info, origin, chapters and extra fields of the metadata are kept, with the extra fields
+// in opts added on top. If opts.RawOutput is set no metadata is written.
+func Transcode(r io.Reader, opts *EncodeOptions, w io.Writer) error {
+	if opts == nil {
+		opts = StdEncodeOptions
+	}
+
+	decoder := NewDecoder(r)
+	err := decoder.checkMetadata()
+	if err != nil {
+		return err
+	}
+
+	sampleRate := 48000
+	channels := 2
+	if decoder.Metadata != nil && decoder.Metadata.Opus != nil {
+		if decoder.Metadata.Opus.SampleRate != 0 {
+			sampleRate = decoder.Metadata.Opus.SampleRate
+		}
+		if decoder.Metadata.Opus.Channels != 0 {
+			channels = decoder.Metadata.Opus.Channels
+		}
+	}
+
+	opusDecoder, err := gopus.NewDecoder(sampleRate, channels)
+	if err != nil {
+		return err
+	}
+
+	// Feed the decoded pcm to ffmpeg
+	encodeOpts := *opts
+	encodeOpts.InputFormat = "s16le"
+	encodeOpts.InputSampleRate = sampleRate
+	encodeOpts.InputChannels = channels
+	encodeOpts.RawOutput = true
+
+	pcmReader, pcmWriter := io.Pipe()
+	session, err := EncodeMem(pcmReader, &encodeOpts)
+	if err != nil {
+		return err
+	}
+	defer session.Cleanup()
+
+	go func() {
+		pcmWriter.CloseWithError(decodeToPCM(decoder, opusDecoder, sampleRate, pcmWriter))
+	}()
+
+	if !opts.RawOutput {
+		err = writeMetadataHeader(w, FormatVersion, transcodeMetadata(decoder.Metadata, opts))
+		if err != nil {
+			pcmReader.CloseWithError(err)
+			return err
+		}
+	}
+
+	var buf bytes.Buffer
+	for {
+		frame, err := session.OpusFrame()
+		if err != nil {
+			if err == io.EOF {
+				break
+			}
+			pcmReader.CloseWithError(err)
+			return err
+		}
+
+		buf.Reset()
+		binary.Write(&buf, binary.LittleEndian, int16(len(frame)))
+		buf.Write(frame)
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			pcmReader.CloseWithError(err)
+			return err
+		}
+	}
+
+	return session.Error()
+}
+
+// decodeToPCM decodes all the frames in decoder and writes them as s16le pcm to w
+func decodeToPCM(decoder *Decoder, opusDecoder *gopus.Decoder, sampleRate int, w io.Writer) error {
+	// Max opus frame duration is 120ms
+	maxFrameSize := sampleRate / 1000 * 120
+
+	var buf []byte
+	for {
+		frame, err := decoder.OpusFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		pcm, err := opusDecoder.Decode(frame, maxFrameSize, false)
+		if err != nil {
+			return err
+		}
+
+		buf = buf[:0]
+		for _, sample := range pcm {
+			buf = append(buf, byte(sample), byte(sample>>8))
+		}
+
+		_, err = w.Write(buf)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// transcodeMetadata returns the metadata for the transcoded file
+func transcodeMetadata(source *Metadata, opts *EncodeOptions) *Metadata {
+	metadata := &Metadata{
+		SongInfo: &SongMetadata{},
+		Origin:   &OriginMetadata{},
+		Extra:    &ExtraMetadata{},
+	}
+	if source != nil {
+		*metadata = *source
+		metadata.SeekTable = nil
+	}
+
+	metadata.Dca = dcaMetadata()
+	metadata.Opus = opts.opusMetadata()
+
+	// Copied so the source metadata isn't changed
+	songInfo := SongMetadata{}
+	if metadata.SongInfo != nil {
+		songInfo = *metadata.SongInfo
+	}
+	mergeString(&songInfo.Comments, opts.Comment)
+	if opts.SongInfo != nil {
+		songInfo.merge(opts.SongInfo)
+	}
+	metadata.SongInfo = &songInfo
+
+	origin := OriginMetadata{}
+	if metadata.Origin != nil {
+		origin = *metadata.Origin
+	}
+	if opts.Origin != nil {
+		origin.merge(opts.Origin)
+	}
+	metadata.Origin = &origin
+
+	extra := ExtraMetadata{}
+	if metadata.Extra != nil {
+		for k, v := range *metadata.Extra {
+			extra[k] = v
+		}
+	}
+	for k, v := range *opts.extraMetadata() {
+		extra[k] = v
+	}
+	metadata.Extra = &extra
+
+	return metadata
+}
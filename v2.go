@@ -0,0 +1,156 @@
+package dca
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+var (
+	ErrBadCRC = fmt.Errorf("%w: frame CRC mismatch", ErrCorrupted)
+)
+
+// V2Options are options for writing a dca v2 file
+type V2Options struct {
+	CRC          bool          // Store a CRC32 checksum after every frame
+	SeekInterval time.Duration // Interval between seek table entries, 0 for 1 second
+}
+
+// WriteV2 converts the dca (v1, v2 or raw) file in r into a v2 file written to w.
+//
+// A v2 file stores the total duration, frame count and a seek table in the metadata,
+// and optionally a checksum after every frame. r is read twice, so it has to be seekable.
+func WriteV2(w io.Writer, r io.ReadSeeker, options V2Options) error {
+	start, err := r.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+
+	interval := options.SeekInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	// First pass, find the frame count and offsets
+	table, err := v2SeekTable(r, interval, options.CRC)
+	if err != nil {
+		return err
+	}
+
+	_, err = r.Seek(start, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	decoder := NewDecoder(r)
+	err = decoder.checkMetadata()
+	if err != nil {
+		return err
+	}
+
+	metadata := decoder.Metadata
+	if metadata == nil {
+		metadata = &Metadata{}
+	}
+	if metadata.Dca == nil {
+		metadata.Dca = &DCAMetadata{}
+	}
+
+	frameDuration := decoder.FrameDuration()
+	metadata.Dca.Version = FormatVersion2
+	metadata.Dca.Frames = table.Frames
+	metadata.Dca.Duration = (time.Duration(table.Frames) * frameDuration).Seconds()
+	metadata.Dca.CRC = options.CRC
+	metadata.SeekTable = table
+
+	err = writeMetadataHeader(w, FormatVersion2, metadata)
+	if err != nil {
+		return err
+	}
+
+	// Second pass, copy the frames
+	var buf bytes.Buffer
+	for {
+		frame, err := decoder.OpusFrame()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		buf.Reset()
+		binary.Write(&buf, binary.LittleEndian, int16(len(frame)))
+		buf.Write(frame)
+		if options.CRC {
+			binary.Write(&buf, binary.LittleEndian, crc32.ChecksumIEEE(frame))
+		}
+
+		_, err = w.Write(buf.Bytes())
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// v2SeekTable builds the seek table for the frames in r as WriteV2 writes them,
+// the offsets can't be taken from r as its frames may have CRCs when the output doesn't or the other way around
+func v2SeekTable(r io.Reader, interval time.Duration, crc bool) (*SeekTable, error) {
+	decoder := NewDecoder(r)
+	err := decoder.checkMetadata()
+	if err != nil {
+		return nil, err
+	}
+
+	frameInterval := int(interval / decoder.FrameDuration())
+	if frameInterval < 1 {
+		frameInterval = 1
+	}
+
+	table := &SeekTable{
+		Interval: frameInterval,
+	}
+
+	var offset int64
+	for {
+		frame, err := decoder.OpusFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if table.Frames%frameInterval == 0 {
+			table.Offsets = append(table.Offsets, offset)
+		}
+		table.Frames++
+
+		offset += 2 + int64(len(frame))
+		if crc {
+			offset += crc32.Size
+		}
+	}
+
+	return table, nil
+}
+
+// writeMetadataHeader writes the magic header and metadata
+func writeMetadataHeader(w io.Writer, version int8, metadata *Metadata) error {
+	jsonData, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(fmt.Sprintf("DCA%d", version))
+	binary.Write(&buf, binary.LittleEndian, int32(len(jsonData)))
+	buf.Write(jsonData)
+
+	_, err = w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,135 @@
+package dca
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Report is the result of verifying a dca file with Verify
+type Report struct {
+	FormatVersion int       // 0 for raw dca without metadata
+	Metadata      *Metadata // nil if raw or the metadata was invalid
+
+	Frames   int           // Number of valid frames
+	Duration time.Duration // Duration of the valid frames, going by their TOC
+
+	SkippedFrames      int  // Damaged frames that were skipped
+	EmptyFrames        int  // Frames with no data
+	InvalidPackets     int  // Frames with an invalid opus TOC
+	DurationMismatches int  // Frames with a different duration than the metadata says
+	ChannelMismatches  int  // Frames with a different number of channels than the metadata says
+	Truncated          bool // The file ends in the middle of a frame
+
+	// Descriptions of the problems found, empty if the file is fine
+	Problems []string
+}
+
+// OK returns true if no problems were found
+func (r *Report) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *Report) addProblem(format string, a ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, a...))
+}
+
+// Verify reads through the dca file in r checking the magic header, the metadata,
+// the frame sizes and that the opus TOC of every frame is consistent with the metadata.
+//
+// Problems with the file are described in the report, the error is only set if reading failed.
+func Verify(r io.Reader) (*Report, error) {
+	report := &Report{}
+
+	decoder := NewDecoder(r)
+	decoder.Tolerant = true
+	err := decoder.checkMetadata()
+	if err != nil {
+		if err == io.EOF {
+			report.addProblem("file is empty")
+			return report, nil
+		}
+		if errors.Is(err, ErrCorrupted) || errors.Is(err, io.ErrUnexpectedEOF) {
+			report.FormatVersion = decoder.FormatVersion
+			report.addProblem("invalid metadata: %v", err)
+			return report, nil
+		}
+		return report, err
+	}
+
+	report.FormatVersion = decoder.FormatVersion
+	report.Metadata = decoder.Metadata
+	if report.FormatVersion != 0 && report.Metadata == nil {
+		report.addProblem("metadata is empty")
+	}
+
+	frameDuration := decoder.FrameDuration()
+	channels := 0
+	if report.Metadata != nil && report.Metadata.Opus != nil {
+		channels = report.Metadata.Opus.Channels
+	}
+
+	for {
+		frame, err := decoder.OpusFrame()
+		if err != nil {
+			if err == ErrTruncated {
+				report.Truncated = true
+				break
+			}
+			if err == io.EOF {
+				break
+			}
+			return report, err
+		}
+
+		report.Frames++
+		if len(frame) == 0 {
+			report.EmptyFrames++
+			continue
+		}
+
+		info, err := ParsePacket(frame)
+		if err != nil {
+			report.InvalidPackets++
+			continue
+		}
+
+		report.Duration += info.Duration()
+		if info.Duration() != frameDuration {
+			report.DurationMismatches++
+		}
+
+		if channels != 0 && info.Channels() != channels {
+			report.ChannelMismatches++
+		}
+	}
+	report.SkippedFrames = decoder.SkippedFrames
+
+	if report.SkippedFrames > 0 {
+		report.addProblem("%d damaged frames", report.SkippedFrames)
+	}
+	if report.Truncated {
+		report.addProblem("file is truncated")
+	}
+	if report.EmptyFrames > 0 {
+		report.addProblem("%d empty frames", report.EmptyFrames)
+	}
+	if report.InvalidPackets > 0 {
+		report.addProblem("%d frames with an invalid opus TOC", report.InvalidPackets)
+	}
+	if report.DurationMismatches > 0 {
+		report.addProblem("%d frames with a duration other than %s", report.DurationMismatches, frameDuration)
+	}
+	if report.ChannelMismatches > 0 {
+		report.addProblem("%d frames with a different number of channels than %d", report.ChannelMismatches, channels)
+	}
+
+	if report.Metadata != nil && report.Metadata.Dca != nil && report.Metadata.Dca.Frames > 0 {
+		if expected := report.Metadata.Dca.Frames; expected != report.Frames+report.SkippedFrames {
+			report.addProblem("metadata says %d frames, found %d", expected, report.Frames+report.SkippedFrames)
+		}
+	}
+
+	return report, nil
+}
@@ -0,0 +1,56 @@
+package dca
+
+import (
+	"encoding/binary"
+	"io"
+)
+
+// Writer writes opus frames in the dca format, for audio that isn't encoded by an EncodeSession
+// (received from discord for example)
+type Writer struct {
+	w      io.Writer
+	buf    []byte
+	frames int
+}
+
+// NewWriter writes the magic header and metadata to w and returns a Writer for the frames.
+// If metadata is nil nothing is written before the frames (the same as RawOutput),
+// a nil Dca section is filled in.
+func NewWriter(w io.Writer, metadata *Metadata) (*Writer, error) {
+	if metadata != nil {
+		if metadata.Dca == nil {
+			metadata.Dca = dcaMetadata()
+		}
+
+		err := writeMetadataHeader(w, FormatVersion, metadata)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &Writer{w: w}, nil
+}
+
+// WriteFrame writes an opus frame
+func (w *Writer) WriteFrame(opus []byte) error {
+	if len(opus) > MaxFrameSize {
+		return ErrFrameTooLarge
+	}
+
+	w.buf = append(w.buf[:0], 0, 0)
+	binary.LittleEndian.PutUint16(w.buf, uint16(len(opus)))
+	w.buf = append(w.buf, opus...)
+
+	_, err := w.w.Write(w.buf)
+	if err != nil {
+		return err
+	}
+
+	w.frames++
+	return nil
+}
+
+// Frames returns the number of frames written
+func (w *Writer) Frames() int {
+	return w.frames
+}